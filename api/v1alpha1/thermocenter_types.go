@@ -25,10 +25,56 @@ SOFTWARE.
 package v1alpha1
 
 import (
+	"time"
+
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// DefaultMemcachedPort is the default port of memcached
+	DefaultMemcachedPort = 11211
+
+	// DefaultMQTTPort is the default port of MQTT brokers
+	DefaultMQTTPort = 1883
+
+	// DefaultMQTTTLSPort is the default port of MQTT brokers with TLS
+	DefaultMQTTTLSPort = 8883
+
+	// DefaultDatabasePort is the default port of PostgreSQL
+	DefaultDatabasePort = 5432
+
+	// DefaultRedisPort is the default port of Redis
+	DefaultRedisPort = 6379
+
+	// DefaultPoolSize is the default number of PgBouncer server connections
+	DefaultPoolSize = 10
+
+	// DefaultDiscoveryPrefix is the default prefix of Home Assistant MQTT discovery topics
+	DefaultDiscoveryPrefix = "homeassistant"
+
+	// DefaultDiscoveryInterval is the default interval of republishing discovery configurations in seconds
+	DefaultDiscoveryInterval = 300
+
+	// DefaultBackupRetention is the default number of backups kept
+	DefaultBackupRetention = 7
+
+	// DefaultVerificationDeadlineSeconds is the default runtime limit of upgrade verifications
+	DefaultVerificationDeadlineSeconds = 300
+
+	// DefaultUpdateInterval is the default interval between checks for new versions
+	DefaultUpdateInterval = 6 * time.Hour
+)
+
+const (
+	// ClusterKindCloudNativePG references a postgresql.cnpg.io Cluster
+	ClusterKindCloudNativePG = "Cluster"
+
+	// ClusterKindZalando references an acid.zalan.do postgresql
+	ClusterKindZalando = "postgresql"
+)
+
 // ExternalMemcached represents an external memcached instance to be used
 type ExternalMemcached struct {
 	// Hostname of memcached
@@ -38,26 +84,317 @@ type ExternalMemcached struct {
 	Port int `json:"port,omitempty"`
 }
 
+// ExternalRedis represents an external Redis instance to be used
+type ExternalRedis struct {
+	// Hostname of Redis
+	Hostname string `json:"hostname"`
+
+	// Port of Redis, defaults to 6379
+	Port int `json:"port,omitempty"`
+
+	// PasswordSecretRef references a Secret key holding the password
+	PasswordSecretRef *v1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+
+	// Database number, defaults to 0
+	// +kubebuilder:validation:Minimum=0
+	Database int32 `json:"database,omitempty"`
+}
+
+const (
+	// CacheTypeMemcached uses memcached as cache
+	CacheTypeMemcached = "memcached"
+
+	// CacheTypeRedis uses Redis as cache
+	CacheTypeRedis = "redis"
+)
+
+// Cache selects the cache used by api and grpcserver
+type Cache struct {
+	// Type of the cache, either memcached or redis, defaults to memcached
+	// +kubebuilder:validation:Enum=memcached;redis
+	Type string `json:"type,omitempty"`
+
+	// ExternalRedis points to an external Redis instance, instead of the built-in one
+	ExternalRedis *ExternalRedis `json:"externalRedis,omitempty"`
+}
+
+// CacheServer specifies a built-in cache
+type CacheServer struct {
+	Deployment `json:",inline"`
+
+	// MemorySize limits memory used for cached items, defaults to 16Mi
+	MemorySize *resource.Quantity `json:"memorySize,omitempty"`
+
+	// Resources of the cache container, memory requests default to MemorySize
+	Resources *v1.ResourceRequirements `json:"resources,omitempty"`
+}
+
 // ExternalMQTT represents an external MQTT instance to be used
 type ExternalMQTT struct {
 	// Hostname of MQTT broker
 	Hostname string `json:"hostname"`
 
-	// Port of MQTT broker, defaults to 1883
+	// Port of MQTT broker, defaults to 1883, or 8883 with TLS
 	Port int `json:"port,omitempty"`
+
+	// UsernameSecretRef references a Secret key holding the username
+	UsernameSecretRef *v1.SecretKeySelector `json:"usernameSecretRef,omitempty"`
+
+	// PasswordSecretRef references a Secret key holding the password
+	PasswordSecretRef *v1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+
+	// TLS enables TLS connections to the broker
+	TLS *MQTTTLS `json:"tls,omitempty"`
+}
+
+// MQTTTLS specifies TLS options of MQTT connections
+type MQTTTLS struct {
+	// CASecretRef references a Secret key holding the CA bundle used to verify the broker,
+	// defaults to the system CA bundle
+	CASecretRef *v1.SecretKeySelector `json:"caSecretRef,omitempty"`
+
+	// ClientCertificateSecretRef references a kubernetes.io/tls Secret holding
+	// the client certificate and key used to authenticate to the broker
+	ClientCertificateSecretRef *v1.LocalObjectReference `json:"clientCertificateSecretRef,omitempty"`
 }
 
 // Database specifies database connection parameters
 type Database struct {
-	Host     string `json:"host"`
-	Port     int32  `json:"port"`
-	Name     string `json:"name"`
-	User     string `json:"user"`
-	Password string `json:"password"`
+	// Host of PostgreSQL, taken from the cluster when ClusterRef is specified
+	Host string `json:"host,omitempty"`
+
+	// Port of PostgreSQL, defaults to 5432
+	Port int32 `json:"port,omitempty"`
+
+	// Name of the database, taken from the cluster when ClusterRef is specified
+	Name string `json:"name,omitempty"`
+
+	// User to connect as, taken from the cluster when ClusterRef is specified
+	User string `json:"user,omitempty"`
+
+	// Password of the user, taken from the cluster when ClusterRef is specified
+	Password string `json:"password,omitempty"`
+
+	// ClusterRef references an operator managed PostgreSQL cluster
+	ClusterRef *ClusterRef `json:"clusterRef,omitempty"`
+
+	// Bootstrap creates the database and its role before the first migration
+	Bootstrap *DatabaseBootstrap `json:"bootstrap,omitempty"`
+
+	// SSLMode of connections, defaults to the client library's default
+	// +kubebuilder:validation:Enum=disable;allow;prefer;require;verify-ca;verify-full
+	SSLMode string `json:"sslMode,omitempty"`
+
+	// CASecretRef references a Secret key holding the CA bundle used to verify the server
+	CASecretRef *v1.SecretKeySelector `json:"caSecretRef,omitempty"`
+
+	// ConnMaxAge is the lifetime of database connections in seconds, 0 closes them after each request
+	// +kubebuilder:validation:Minimum=0
+	ConnMaxAge *int32 `json:"connMaxAge,omitempty"`
+}
+
+// DatabaseBootstrap specifies admin access used to create the database and its role
+type DatabaseBootstrap struct {
+	// AdminSecretRef references a Secret holding admin credentials in its username and password keys
+	AdminSecretRef v1.LocalObjectReference `json:"adminSecretRef"`
+
+	// AdminDatabase to connect to, defaults to postgres
+	AdminDatabase string `json:"adminDatabase,omitempty"`
+}
+
+// ClusterRef references a PostgreSQL cluster managed by an operator in the same namespace
+type ClusterRef struct {
+	// Kind of the cluster, Cluster for CloudNativePG, postgresql for Zalando
+	// +kubebuilder:validation:Enum=Cluster;postgresql
+	Kind string `json:"kind"`
+
+	// Name of the cluster
+	Name string `json:"name"`
+}
+
+// PostgreSQL specifies the built-in PostgreSQL instance
+type PostgreSQL struct {
+	// Image of PostgreSQL, defaults to postgres:13-alpine
+	Image string `json:"image,omitempty"`
+
+	// StorageSize of the data volume, defaults to 1Gi
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+
+	// StorageClassName of the data volume, defaults to the cluster's default storage class
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// If specified, the pod's scheduling constraints
+	// +optional
+	Affinity *v1.Affinity `json:"affinity,omitempty"`
+
+	// NodeSelector is a selector which must be true for the pod to fit on a node.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// If specified, the pod's tolerations.
+	// +optional
+	Tolerations []v1.Toleration `json:"tolerations,omitempty"`
+}
+
+const (
+	// PoolModeSession releases server connections when clients disconnect
+	PoolModeSession = "session"
+
+	// PoolModeTransaction releases server connections after each transaction
+	PoolModeTransaction = "transaction"
+)
+
+// Pooler specifies the PgBouncer connection pooler
+type Pooler struct {
+	Deployment `json:",inline"`
+
+	// PoolMode of PgBouncer, defaults to session
+	// +kubebuilder:validation:Enum=session;transaction
+	PoolMode string `json:"poolMode,omitempty"`
+
+	// PoolSize is the number of server connections per database and user, defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	PoolSize *int32 `json:"poolSize,omitempty"`
+}
+
+// HomeAssistant specifies the Home Assistant MQTT discovery publisher
+type HomeAssistant struct {
+	Deployment `json:",inline"`
+
+	// DiscoveryPrefix of Home Assistant MQTT discovery topics, defaults to homeassistant
+	DiscoveryPrefix string `json:"discoveryPrefix,omitempty"`
+
+	// Interval of republishing discovery configurations in seconds, defaults to 300
+	// +kubebuilder:validation:Minimum=10
+	Interval *int32 `json:"interval,omitempty"`
+}
+
+// MQTT specifies the built-in MQTT broker
+type MQTT struct {
+	Deployment `json:",inline"`
+
+	// Persistence stores retained messages and subscriptions on a volume
+	Persistence *MQTTPersistence `json:"persistence,omitempty"`
+
+	// Expose makes the broker reachable from outside the cluster
+	Expose *MQTTExpose `json:"expose,omitempty"`
+
+	// Bridge mirrors topics to and from an upstream broker
+	Bridge *MQTTBridge `json:"bridge,omitempty"`
+}
+
+const (
+	// BridgeDirectionOut forwards local messages to the upstream broker
+	BridgeDirectionOut = "out"
+
+	// BridgeDirectionIn forwards upstream messages to the local broker
+	BridgeDirectionIn = "in"
+
+	// BridgeDirectionBoth forwards messages in both directions
+	BridgeDirectionBoth = "both"
+)
+
+// MQTTBridge specifies a bridge from the built-in broker to an upstream broker
+type MQTTBridge struct {
+	// Address of the upstream broker, as host:port
+	Address string `json:"address"`
+
+	// CredentialsSecretRef references a Secret holding username and password keys
+	CredentialsSecretRef *v1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+
+	// TLS enables TLS connections to the upstream broker, verified with the system
+	// CA bundle unless a CA is specified
+	TLS *MQTTTLS `json:"tls,omitempty"`
+
+	// Topics to bridge
+	// +kubebuilder:validation:MinItems=1
+	Topics []MQTTBridgeTopic `json:"topics"`
+}
+
+// MQTTBridgeTopic specifies bridged topics
+type MQTTBridgeTopic struct {
+	// Pattern of topics, may contain wildcards
+	Pattern string `json:"pattern"`
+
+	// Direction of forwarding, one of out, in or both, defaults to out
+	// +kubebuilder:validation:Enum=out;in;both
+	Direction string `json:"direction,omitempty"`
+
+	// QoS of bridged messages, defaults to 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=2
+	QoS int32 `json:"qos,omitempty"`
+}
+
+// MQTTExpose specifies access to the built-in MQTT broker from outside the cluster
+type MQTTExpose struct {
+	// ServiceType of the mqtt Service, either LoadBalancer or NodePort
+	// +kubebuilder:validation:Enum=LoadBalancer;NodePort
+	ServiceType v1.ServiceType `json:"serviceType"`
+
+	// WebSockets enables a websockets listener, routed through the Ingress at /mqtt
+	WebSockets bool `json:"webSockets,omitempty"`
+
+	// AllowedCIDRs lists networks of clients connecting through the Service,
+	// 0.0.0.0/0 admits any address
+	// +kubebuilder:validation:MinItems=1
+	// +listType=set
+	AllowedCIDRs []string `json:"allowedCIDRs"`
+}
+
+// MQTTPersistence specifies the data volume of the built-in MQTT broker
+type MQTTPersistence struct {
+	// StorageSize of the data volume, defaults to 100Mi
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+
+	// StorageClassName of the data volume, defaults to the cluster's default storage class
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// Backup specifies scheduled database backups
+type Backup struct {
+	// Schedule of backups in cron format
+	Schedule string `json:"schedule"`
+
+	// Retention is the number of backups kept, defaults to 7
+	// +kubebuilder:validation:Minimum=1
+	Retention *int32 `json:"retention,omitempty"`
+
+	// Image providing pg_dump, defaults to postgres:13-alpine. Its major version must not be lower than the server's.
+	Image string `json:"image,omitempty"`
+
+	// Storage of backups
+	Storage BackupStorage `json:"storage"`
+}
+
+// BackupStorage specifies where backups are stored, exactly one of the fields must be set
+type BackupStorage struct {
+	// PersistentVolumeClaim stores backups in the named claim
+	PersistentVolumeClaim *v1.LocalObjectReference `json:"persistentVolumeClaim,omitempty"`
+
+	// S3 stores backups in an S3 compatible bucket
+	S3 *S3Storage `json:"s3,omitempty"`
+}
+
+// S3Storage specifies an S3 compatible bucket
+type S3Storage struct {
+	// Endpoint URL of the S3 service
+	Endpoint string `json:"endpoint"`
+
+	// Bucket to store backups in
+	Bucket string `json:"bucket"`
+
+	// Prefix of object names
+	Prefix string `json:"prefix,omitempty"`
+
+	// CredentialsSecretRef references a Secret holding accessKey and secretKey keys
+	CredentialsSecretRef v1.LocalObjectReference `json:"credentialsSecretRef"`
 }
 
 // Deployment base parameters
 type Deployment struct {
+	// Image overrides the component's image. If no tag is specified, the
+	// desired version is used. May be pinned by digest, as image@sha256:...
 	Image    string `json:"image,omitempty"`
 	Replicas *int32 `json:"replicas,omitempty"`
 
@@ -106,6 +443,93 @@ type Graphite struct {
 	MetricPathTemplate string `json:"metricPathTemplate,omitempty"`
 }
 
+const (
+	// MaintenanceModeScaleDown scales api, grpcserver and ws to zero while migrating
+	MaintenanceModeScaleDown = "ScaleDown"
+
+	// MaintenanceModePage additionally serves a maintenance page while migrating
+	MaintenanceModePage = "Page"
+)
+
+// Maintenance parameters, applied while the database is being migrated
+type Maintenance struct {
+	// Mode specifies how the instance is put into maintenance, either ScaleDown or Page
+	// +kubebuilder:validation:Enum=ScaleDown;Page
+	Mode string `json:"mode"`
+
+	// Page specifies the maintenance page deployment, used in Page mode
+	Page *Deployment `json:"page,omitempty"`
+}
+
+// UpgradeVerification parameters
+type UpgradeVerification struct {
+	// Image used to run verification checks, defaults to curlimages/curl
+	Image string `json:"image,omitempty"`
+
+	// ActiveDeadlineSeconds limits the runtime of the verification, defaults to 300
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// Rollback reverts components to the previous version if verification fails
+	// and the database schema permits
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+const (
+	// UpdateChannelStable allows updating to any newer release
+	UpdateChannelStable = "stable"
+
+	// UpdateChannelPatch allows updating to newer patch releases of the specified version
+	UpdateChannelPatch = "patch"
+)
+
+// UpdateWindow specifies a daily window when updates may be applied
+type UpdateWindow struct {
+	// Start of the window in HH:MM format, in UTC
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// Duration of the window
+	Duration metav1.Duration `json:"duration"`
+}
+
+// UpdatePolicy specifies automatic version updates
+type UpdatePolicy struct {
+	// Channel selects allowed versions, either stable or patch
+	// +kubebuilder:validation:Enum=stable;patch
+	Channel string `json:"channel"`
+
+	// Window restricts when updates are applied, defaults to any time
+	Window *UpdateWindow `json:"window,omitempty"`
+
+	// Interval between checks for new versions, defaults to 6h
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// ImageRewrite replaces a prefix of image names
+type ImageRewrite struct {
+	// From is the image name prefix to replace, e.g. docker.io/ or ghcr.io/rkojedzinszky/.
+	// Images without a registry are matched as docker.io/ images. The prefix
+	// must end at a path, tag or digest separator, or match the whole name.
+	From string `json:"from"`
+
+	// To is the replacement prefix
+	To string `json:"to"`
+}
+
+// ImageRegistry specifies how images are pulled
+type ImageRegistry struct {
+	// Rewrites are applied to image names of all pods, the first matching rule wins
+	// +listType=atomic
+	Rewrites []ImageRewrite `json:"rewrites,omitempty"`
+
+	// PullSecrets are added to all pods as imagePullSecrets
+	// +listType=atomic
+	PullSecrets []v1.LocalObjectReference `json:"pullSecrets,omitempty"`
+
+	// PullPolicy is set as imagePullPolicy on all containers
+	PullPolicy v1.PullPolicy `json:"pullPolicy,omitempty"`
+}
+
 // ThermoCenterSpec defines the desired state of ThermoCenter
 type ThermoCenterSpec struct {
 	// Ingress represents Ingress parameters
@@ -117,15 +541,33 @@ type ThermoCenterSpec struct {
 	// ExternalMQTT points to an external mqtt instance
 	ExternalMQTT *ExternalMQTT `json:"externalMQTT,omitempty"`
 
-	// Version defines the desired version. If empty, uses 'latest' tag for all images
+	// Version defines the desired version. If empty, uses 'latest' tag for all images,
+	// and migrates the database whenever the digest of the api image changes
 	Version *string `json:"version,omitempty"`
 
+	// ImageRegistry specifies image rewrites and pull options
+	ImageRegistry *ImageRegistry `json:"imageRegistry,omitempty"`
+
+	// PinDigests pins component images by digest. Digests are resolved
+	// once per version, and recorded in status.
+	PinDigests bool `json:"pinDigests,omitempty"`
+
+	// UpdatePolicy enables automatic updates to versions newer than Version
+	UpdatePolicy *UpdatePolicy `json:"updatePolicy,omitempty"`
+
 	// Desired replicas of all components, defaults to 1
 	Replicas int32 `json:"replicas"`
 
-	// Postgresql access configuration
+	// Postgresql access configuration. If not specified, a built-in
+	// PostgreSQL instance is provisioned.
 	Database *Database `json:"database,omitempty"`
 
+	// PostgreSQL specifies the built-in PostgreSQL instance, used when no database is specified
+	PostgreSQL *PostgreSQL `json:"postgresql,omitempty"`
+
+	// Pooler enables connection pooling for api and grpcserver components
+	Pooler *Pooler `json:"pooler,omitempty"`
+
 	// Deployment specifications, on production deployments these are typically not specified
 	UI       *Deployment `json:"ui,omitempty"`
 	API      *Deployment `json:"api,omitempty"`
@@ -133,20 +575,200 @@ type ThermoCenterSpec struct {
 	GRPC     *Deployment `json:"grpc,omitempty"`
 	Receiver *Deployment `json:"receiver,omitempty"`
 
+	// Cache selects the cache type, and optionally an external Redis instance
+	Cache *Cache `json:"cache,omitempty"`
+
+	// Memcached specifies the built-in memcached
+	Memcached *CacheServer `json:"memcached,omitempty"`
+
+	// Redis specifies the built-in Redis, used with the redis cache type
+	Redis *CacheServer `json:"redis,omitempty"`
+
+	// MQTT specifies the built-in MQTT broker
+	MQTT *MQTT `json:"mqtt,omitempty"`
+
+	// HomeAssistant enables publishing sensors to Home Assistant through MQTT discovery
+	HomeAssistant *HomeAssistant `json:"homeAssistant,omitempty"`
+
 	// Graphite parameter specification
 	Graphite Graphite `json:"graphite,omitempty"`
+
+	// Maintenance enables maintenance mode during database migrations
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+
+	// UpgradeVerification enables checking components after an upgrade
+	UpgradeVerification *UpgradeVerification `json:"upgradeVerification,omitempty"`
+
+	// Backup enables scheduled database backups
+	Backup *Backup `json:"backup,omitempty"`
+
+	// InitFrom initializes the empty database of a new instance from a backup
+	InitFrom *BackupSource `json:"initFrom,omitempty"`
+
+	// EnableReceiver runs the receiver of instances initialized from a backup,
+	// which is disabled by default so that clones do not compete for the radio
+	EnableReceiver bool `json:"enableReceiver,omitempty"`
+
+	// DeletionPolicy specifies what happens to the database when the instance is deleted,
+	// one of Retain, Backup or Drop, defaults to Retain
+	// +kubebuilder:validation:Enum=Retain;Backup;Drop
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
 }
 
+const (
+	// DeletionPolicyRetain leaves the database untouched
+	DeletionPolicyRetain = "Retain"
+
+	// DeletionPolicyBackup takes a final backup of the database
+	DeletionPolicyBackup = "Backup"
+
+	// DeletionPolicyDrop takes a final backup if backups are enabled, then drops the database and its role
+	DeletionPolicyDrop = "Drop"
+)
+
+const (
+	// DeletionBackingUp is the phase of taking the final backup
+	DeletionBackingUp = "BackingUp"
+
+	// DeletionDropping is the phase of dropping the database
+	DeletionDropping = "Dropping"
+
+	// DeletionFailed is the phase of a failed deletion step, blocking removal of the instance
+	DeletionFailed = "Failed"
+)
+
+// DeletionStatus records progress of the deletion policy
+type DeletionStatus struct {
+	// Phase is one of BackingUp, Dropping or Failed
+	Phase string `json:"phase"`
+
+	// Backup is the name of the final ThermoCenterBackup
+	Backup string `json:"backup,omitempty"`
+
+	// Message describes failures
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// MigrationSucceeded is the outcome of a successful migration
+	MigrationSucceeded = "Succeeded"
+
+	// MigrationFailed is the outcome of a failed migration
+	MigrationFailed = "Failed"
+)
+
+// Migration records a database migration attempt
+type Migration struct {
+	// From is the database version before the migration
+	From string `json:"from,omitempty"`
+
+	// To is the target database version of the migration
+	To string `json:"to"`
+
+	// StartTime is the time the migration job started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// EndTime is the time the migration job finished
+	EndTime *metav1.Time `json:"endTime,omitempty"`
+
+	// Outcome is either Succeeded or Failed
+	Outcome string `json:"outcome"`
+
+	// JobName is the name of the migration job
+	JobName string `json:"jobName"`
+
+	// Attempts is the number of consecutive attempts to migrate to the target version
+	Attempts int32 `json:"attempts"`
+}
+
+// ComponentStatus reports the effective configuration of a component
+type ComponentStatus struct {
+	// Name of the component
+	Name string `json:"name"`
+
+	// Image configured for the component
+	Image string `json:"image"`
+
+	// Replicas configured for the component
+	Replicas int32 `json:"replicas"`
+
+	// Digests of images reported by running pods
+	// +listType=set
+	Digests []string `json:"digests,omitempty"`
+}
+
+const (
+	// ConditionUpgradeFailed is true when components failed verification after an upgrade
+	ConditionUpgradeFailed = "UpgradeFailed"
+
+	// ConditionDatabaseBootstrapped is true once the database and its role have been created
+	ConditionDatabaseBootstrapped = "DatabaseBootstrapped"
+
+	// ConditionInitialized is true once the database has been initialized from a backup
+	ConditionInitialized = "Initialized"
+
+	// ConditionDigestsPinned is false when image digests of the desired version could not be resolved
+	ConditionDigestsPinned = "DigestsPinned"
+
+	// ConditionBackupScheduled is true while a CronJob runs scheduled backups
+	ConditionBackupScheduled = "BackupScheduled"
+)
+
 // ThermoCenterStatus defines the observed state of ThermoCenter
 type ThermoCenterStatus struct {
 	DatabaseVersion string `json:"databaseVersion"`
 	Status          string `json:"status"`
+
+	// PreviousVersion is the database version before the last migration
+	PreviousVersion string `json:"previousVersion,omitempty"`
+
+	// VerifiedVersion is the last version which passed upgrade verification
+	VerifiedVersion string `json:"verifiedVersion,omitempty"`
+
+	// FailedVersion is the last version which failed upgrade verification
+	FailedVersion string `json:"failedVersion,omitempty"`
+
+	// RollbackVersion is the version components were reverted to after FailedVersion failed
+	RollbackVersion string `json:"rollbackVersion,omitempty"`
+
+	// UpdateVersion is the newest version allowed by the update policy
+	UpdateVersion string `json:"updateVersion,omitempty"`
+
+	// NextUpdateCheck is the time of the next check for new versions
+	NextUpdateCheck *metav1.Time `json:"nextUpdateCheck,omitempty"`
+
+	// PinnedVersion is the version PinnedDigests were resolved for
+	PinnedVersion string `json:"pinnedVersion,omitempty"`
+
+	// PinnedDigests maps components to their pinned image digests
+	PinnedDigests map[string]string `json:"pinnedDigests,omitempty"`
+
+	// Components lists effective replicas, configured and running images of components
+	// +listType=map
+	// +listMapKey=name
+	Components []ComponentStatus `json:"components,omitempty"`
+
+	// MigrationHistory lists the most recent migrations, oldest first
+	MigrationHistory []Migration `json:"migrationHistory,omitempty"`
+
+	// LastMigrationTime is the time the last migration finished
+	LastMigrationTime *metav1.Time `json:"lastMigrationTime,omitempty"`
+
+	// Deletion records progress of the deletion policy while the instance is being deleted
+	Deletion *DeletionStatus `json:"deletion,omitempty"`
+
+	// Conditions represent the latest observations of the instance's state
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 // +kubebuilder:printcolumn:JSONPath=.status.databaseVersion,description="Database version",name=DBVer,type=string
 // +kubebuilder:printcolumn:JSONPath=.status.status,description="ThermoCenter status",name=Status,type=string
+// +kubebuilder:printcolumn:JSONPath=.status.lastMigrationTime,description="Last migration time",name=LastMigration,type=date
 
 // ThermoCenter is the Schema for the thermocenters API
 type ThermoCenter struct {
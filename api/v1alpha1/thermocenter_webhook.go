@@ -0,0 +1,500 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// SetupWebhookWithManager registers ThermoCenter webhooks
+func (r *ThermoCenter) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-kojedz-in-v1alpha1-thermocenter,mutating=true,failurePolicy=fail,sideEffects=None,groups=kojedz.in,resources=thermocenters,verbs=create;update,versions=v1alpha1,name=mthermocenter.kojedz.in,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &ThermoCenter{}
+
+// Default implements webhook.Defaulter, making defaults visible in the spec
+func (r *ThermoCenter) Default() {
+	if r.Spec.ExternalMemcached != nil && r.Spec.ExternalMemcached.Port == 0 {
+		r.Spec.ExternalMemcached.Port = DefaultMemcachedPort
+	}
+
+	if r.Spec.ExternalMQTT != nil && r.Spec.ExternalMQTT.Port == 0 {
+		if r.Spec.ExternalMQTT.TLS != nil {
+			r.Spec.ExternalMQTT.Port = DefaultMQTTTLSPort
+		} else {
+			r.Spec.ExternalMQTT.Port = DefaultMQTTPort
+		}
+	}
+
+	if r.Spec.Database != nil && r.Spec.Database.Port == 0 {
+		r.Spec.Database.Port = DefaultDatabasePort
+	}
+
+	if r.Spec.Cache != nil && r.Spec.Cache.ExternalRedis != nil && r.Spec.Cache.ExternalRedis.Port == 0 {
+		r.Spec.Cache.ExternalRedis.Port = DefaultRedisPort
+	}
+
+	// Replicas and images of thermo-center components follow spec.replicas
+	// and spec.version, thus are reported in status only. Auxiliary services
+	// run a single replica.
+	if r.Spec.Pooler != nil {
+		defaultReplicas(&r.Spec.Pooler.Deployment, 1)
+
+		if r.Spec.Pooler.PoolMode == "" {
+			r.Spec.Pooler.PoolMode = PoolModeSession
+		}
+		if r.Spec.Pooler.PoolSize == nil {
+			poolSize := int32(DefaultPoolSize)
+			r.Spec.Pooler.PoolSize = &poolSize
+		}
+	}
+
+	if r.Spec.Memcached != nil {
+		defaultReplicas(&r.Spec.Memcached.Deployment, 1)
+	}
+
+	if r.Spec.Redis != nil {
+		defaultReplicas(&r.Spec.Redis.Deployment, 1)
+	}
+
+	if r.Spec.MQTT != nil {
+		defaultReplicas(&r.Spec.MQTT.Deployment, 1)
+	}
+
+	if r.Spec.HomeAssistant != nil {
+		defaultReplicas(&r.Spec.HomeAssistant.Deployment, 1)
+
+		if r.Spec.HomeAssistant.DiscoveryPrefix == "" {
+			r.Spec.HomeAssistant.DiscoveryPrefix = DefaultDiscoveryPrefix
+		}
+		if r.Spec.HomeAssistant.Interval == nil {
+			interval := int32(DefaultDiscoveryInterval)
+			r.Spec.HomeAssistant.Interval = &interval
+		}
+	}
+
+	if r.Spec.UpdatePolicy != nil && r.Spec.UpdatePolicy.Interval == nil {
+		r.Spec.UpdatePolicy.Interval = &metav1.Duration{Duration: DefaultUpdateInterval}
+	}
+
+	if r.Spec.UpgradeVerification != nil && r.Spec.UpgradeVerification.ActiveDeadlineSeconds == nil {
+		activeDeadlineSeconds := int64(DefaultVerificationDeadlineSeconds)
+		r.Spec.UpgradeVerification.ActiveDeadlineSeconds = &activeDeadlineSeconds
+	}
+
+	if r.Spec.Backup != nil && r.Spec.Backup.Retention == nil {
+		retention := int32(DefaultBackupRetention)
+		r.Spec.Backup.Retention = &retention
+	}
+}
+
+// defaultReplicas sets unspecified replicas of a deployment
+func defaultReplicas(dep *Deployment, replicas int32) {
+	if dep.Replicas == nil {
+		dep.Replicas = &replicas
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-kojedz-in-v1alpha1-thermocenter,mutating=false,failurePolicy=fail,sideEffects=None,groups=kojedz.in,resources=thermocenters,verbs=create;update,versions=v1alpha1,name=vthermocenter.kojedz.in,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &ThermoCenter{}
+
+// ValidateCreate implements webhook.Validator
+func (r *ThermoCenter) ValidateCreate() error {
+	return r.toError(r.validate())
+}
+
+// ValidateUpdate implements webhook.Validator
+func (r *ThermoCenter) ValidateUpdate(old runtime.Object) error {
+	// Deleted instances must remain updatable, for finalizers to be removed
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+
+	errs := r.validate()
+
+	if oldInstance, ok := old.(*ThermoCenter); ok {
+		errs = append(errs, r.validateUpdate(oldInstance)...)
+	}
+
+	return r.toError(errs)
+}
+
+// ValidateDelete implements webhook.Validator
+func (r *ThermoCenter) ValidateDelete() error {
+	return nil
+}
+
+func (r *ThermoCenter) toError(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("ThermoCenter").GroupKind(), r.Name, errs)
+}
+
+func (r *ThermoCenter) validate() field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+
+	// Ingress
+	hostNames := spec.Child("ingress", "hostNames")
+	if len(r.Spec.Ingress.HostNames) == 0 {
+		errs = append(errs, field.Required(hostNames, "at least one host name is required"))
+	}
+	for idx, host := range r.Spec.Ingress.HostNames {
+		var msgs []string
+		if len(host) > 2 && host[:2] == "*." {
+			msgs = validation.IsWildcardDNS1123Subdomain(host)
+		} else {
+			msgs = validation.IsDNS1123Subdomain(host)
+		}
+
+		for _, msg := range msgs {
+			errs = append(errs, field.Invalid(hostNames.Index(idx), host, msg))
+		}
+	}
+
+	// Version
+	if r.Spec.Version != nil && *r.Spec.Version != "" && !isRelease(*r.Spec.Version) {
+		errs = append(errs, field.Invalid(spec.Child("version"), *r.Spec.Version, "must be a semantic version, like 4.1.0"))
+	}
+
+	if r.Spec.UpdatePolicy != nil && r.Spec.UpdatePolicy.Channel == UpdateChannelPatch && (r.Spec.Version == nil || *r.Spec.Version == "") {
+		errs = append(errs, field.Required(spec.Child("version"), "required by the patch update channel"))
+	}
+
+	// Database
+	errs = append(errs, validateDatabase(spec.Child("database"), r.Spec.Database)...)
+
+	// External services
+	if r.Spec.ExternalMemcached != nil {
+		errs = append(errs, validateService(spec.Child("externalMemcached"), r.Spec.ExternalMemcached.Hostname, r.Spec.ExternalMemcached.Port)...)
+	}
+	if r.Spec.ExternalMQTT != nil {
+		errs = append(errs, validateService(spec.Child("externalMQTT"), r.Spec.ExternalMQTT.Hostname, r.Spec.ExternalMQTT.Port)...)
+		if r.Spec.ExternalMQTT.PasswordSecretRef != nil && r.Spec.ExternalMQTT.UsernameSecretRef == nil {
+			errs = append(errs, field.Required(spec.Child("externalMQTT", "usernameSecretRef"), "required with passwordSecretRef"))
+		}
+	}
+
+	// Replicas
+	if r.Spec.Replicas < 0 {
+		errs = append(errs, field.Invalid(spec.Child("replicas"), r.Spec.Replicas, "must not be negative"))
+	}
+
+	for name, dep := range map[string]*Deployment{
+		"ui":       r.Spec.UI,
+		"api":      r.Spec.API,
+		"ws":       r.Spec.WS,
+		"grpc":     r.Spec.GRPC,
+		"receiver": r.Spec.Receiver,
+	} {
+		errs = append(errs, validateReplicas(spec.Child(name, "replicas"), dep)...)
+	}
+
+	if r.Spec.Maintenance != nil {
+		errs = append(errs, validateReplicas(spec.Child("maintenance", "page", "replicas"), r.Spec.Maintenance.Page)...)
+	}
+	if r.Spec.Backup != nil {
+		errs = append(errs, validateBackupStorage(spec.Child("backup", "storage"), &r.Spec.Backup.Storage)...)
+	}
+	if r.Spec.InitFrom != nil {
+		errs = append(errs, validateBackupSource(spec.Child("initFrom"), r.Spec.InitFrom)...)
+	}
+	if r.Spec.Pooler != nil {
+		errs = append(errs, validateReplicas(spec.Child("pooler", "replicas"), &r.Spec.Pooler.Deployment)...)
+	}
+	// Cache
+	if r.Spec.Memcached != nil {
+		errs = append(errs, validateReplicas(spec.Child("memcached", "replicas"), &r.Spec.Memcached.Deployment)...)
+	}
+	if r.Spec.Redis != nil {
+		errs = append(errs, validateReplicas(spec.Child("redis", "replicas"), &r.Spec.Redis.Deployment)...)
+		if r.Spec.Redis.Replicas != nil && *r.Spec.Redis.Replicas > 1 {
+			errs = append(errs, field.Invalid(spec.Child("redis", "replicas"), *r.Spec.Redis.Replicas, "must not exceed 1"))
+		}
+	}
+	if r.Spec.Cache != nil && r.Spec.Cache.ExternalRedis != nil {
+		errs = append(errs, validateService(spec.Child("cache", "externalRedis"), r.Spec.Cache.ExternalRedis.Hostname, r.Spec.Cache.ExternalRedis.Port)...)
+		if r.Spec.Cache.Type != CacheTypeRedis {
+			errs = append(errs, field.Forbidden(spec.Child("cache", "externalRedis"), "requires the redis cache type"))
+		}
+	}
+
+	if r.Spec.HomeAssistant != nil {
+		errs = append(errs, validateReplicas(spec.Child("homeAssistant", "replicas"), &r.Spec.HomeAssistant.Deployment)...)
+	}
+	if r.Spec.MQTT != nil {
+		errs = append(errs, validateReplicas(spec.Child("mqtt", "replicas"), &r.Spec.MQTT.Deployment)...)
+		if r.Spec.MQTT.Persistence != nil && r.Spec.MQTT.Replicas != nil && *r.Spec.MQTT.Replicas > 1 {
+			errs = append(errs, field.Invalid(spec.Child("mqtt", "replicas"), *r.Spec.MQTT.Replicas, "must not exceed 1 with persistence"))
+		}
+		if r.Spec.MQTT.Expose != nil {
+			errs = append(errs, validateMQTTExpose(spec.Child("mqtt", "expose"), r.Spec.MQTT.Expose)...)
+			if r.Spec.ExternalMQTT != nil {
+				errs = append(errs, field.Forbidden(spec.Child("mqtt", "expose"), "must not be set with externalMQTT"))
+			}
+		}
+		if r.Spec.MQTT.Bridge != nil {
+			errs = append(errs, validateMQTTBridge(spec.Child("mqtt", "bridge"), r.Spec.MQTT.Bridge)...)
+			if r.Spec.ExternalMQTT != nil {
+				errs = append(errs, field.Forbidden(spec.Child("mqtt", "bridge"), "must not be set with externalMQTT"))
+			}
+		}
+	}
+
+	// Deletion policy
+	switch r.Spec.DeletionPolicy {
+	case DeletionPolicyBackup:
+		if r.Spec.Backup == nil {
+			errs = append(errs, field.Required(spec.Child("backup"), "required by the Backup deletion policy"))
+		}
+	case DeletionPolicyDrop:
+		if r.Spec.Database != nil && r.Spec.Database.Bootstrap == nil {
+			errs = append(errs, field.Required(spec.Child("database", "bootstrap"), "admin credentials are required by the Drop deletion policy"))
+		}
+	}
+
+	return errs
+}
+
+// validateUpdate checks changes against the previous state
+func (r *ThermoCenter) validateUpdate(old *ThermoCenter) field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+
+	// The database version in status describes the current database,
+	// which would become invalid by pointing to another database
+	if r.Spec.Database != nil && old.Spec.Database != nil {
+		if clusterRefName(r.Spec.Database) != clusterRefName(old.Spec.Database) {
+			errs = append(errs, field.Forbidden(spec.Child("database", "clusterRef"), "field is immutable"))
+		}
+		if r.Spec.Database.Host != old.Spec.Database.Host {
+			errs = append(errs, field.Forbidden(spec.Child("database", "host"), "field is immutable"))
+		}
+		if r.Spec.Database.Name != old.Spec.Database.Name {
+			errs = append(errs, field.Forbidden(spec.Child("database", "name"), "field is immutable"))
+		}
+	}
+	if (r.Spec.Database == nil) != (old.Spec.Database == nil) && old.Status.DatabaseVersion != "" {
+		errs = append(errs, field.Forbidden(spec.Child("database"), "switching between built-in and external database is not allowed"))
+	}
+
+	// Migrations can not be reverted. Only checked on version changes, as
+	// automatic updates migrate beyond the specified version.
+	if r.Spec.Version != nil && isRelease(*r.Spec.Version) && isRelease(old.Status.DatabaseVersion) && versionChanged(r.Spec.Version, old.Spec.Version) {
+		if semver.Compare("v"+*r.Spec.Version, "v"+old.Status.DatabaseVersion) < 0 {
+			errs = append(errs, field.Forbidden(spec.Child("version"), "must not be lower than the migrated database version "+old.Status.DatabaseVersion))
+		}
+	}
+
+	return errs
+}
+
+func validateDatabase(path *field.Path, db *Database) field.ErrorList {
+	var errs field.ErrorList
+
+	// Built-in PostgreSQL is used
+	if db == nil {
+		return errs
+	}
+
+	if db.ClusterRef != nil {
+		if db.ClusterRef.Name == "" {
+			errs = append(errs, field.Required(path.Child("clusterRef", "name"), ""))
+		}
+		if db.Host != "" {
+			errs = append(errs, field.Forbidden(path.Child("host"), "must not be set with clusterRef"))
+		}
+		if db.Bootstrap != nil {
+			errs = append(errs, field.Forbidden(path.Child("bootstrap"), "must not be set with clusterRef"))
+		}
+	} else {
+		errs = append(errs, validateDatabaseAccess(path, db)...)
+	}
+
+	if db.Bootstrap != nil && db.Bootstrap.AdminSecretRef.Name == "" {
+		errs = append(errs, field.Required(path.Child("bootstrap", "adminSecretRef", "name"), ""))
+	}
+
+	if db.Port != 0 {
+		for _, msg := range validation.IsValidPortNum(int(db.Port)) {
+			errs = append(errs, field.Invalid(path.Child("port"), db.Port, msg))
+		}
+	}
+
+	return errs
+}
+
+// validateDatabaseAccess checks explicitly specified access parameters
+func validateDatabaseAccess(path *field.Path, db *Database) field.ErrorList {
+	var errs field.ErrorList
+
+	if db.Host == "" {
+		errs = append(errs, field.Required(path.Child("host"), ""))
+	}
+	if db.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+	if db.User == "" {
+		errs = append(errs, field.Required(path.Child("user"), ""))
+	}
+
+	return errs
+}
+
+// validateBackupStorage checks that exactly one storage is specified
+func validateBackupStorage(path *field.Path, storage *BackupStorage) field.ErrorList {
+	var errs field.ErrorList
+
+	if (storage.PersistentVolumeClaim == nil) == (storage.S3 == nil) {
+		errs = append(errs, field.Invalid(path, "", "exactly one of persistentVolumeClaim and s3 must be specified"))
+	}
+
+	return errs
+}
+
+// validateBackupSource checks that exactly one backup reference is specified
+func validateBackupSource(path *field.Path, src *BackupSource) field.ErrorList {
+	var errs field.ErrorList
+
+	if (src.Backup == "") == (src.Location == nil) {
+		errs = append(errs, field.Invalid(path, "", "exactly one of backup and location must be specified"))
+	}
+	if src.Location != nil {
+		errs = append(errs, validateBackupStorage(path.Child("location", "storage"), &src.Location.Storage)...)
+	}
+
+	return errs
+}
+
+// clusterRefName identifies the referenced cluster, if any
+func clusterRefName(db *Database) string {
+	if db.ClusterRef == nil {
+		return ""
+	}
+
+	return db.ClusterRef.Kind + "/" + db.ClusterRef.Name
+}
+
+func validateService(path *field.Path, host string, port int) field.ErrorList {
+	var errs field.ErrorList
+
+	if host == "" {
+		errs = append(errs, field.Required(path.Child("hostname"), ""))
+	}
+
+	if port != 0 {
+		for _, msg := range validation.IsValidPortNum(port) {
+			errs = append(errs, field.Invalid(path.Child("port"), port, msg))
+		}
+	}
+
+	return errs
+}
+
+func validateMQTTExpose(path *field.Path, expose *MQTTExpose) field.ErrorList {
+	var errs field.ErrorList
+
+	// An exposed broker must not be open to anyone by accident
+	if len(expose.AllowedCIDRs) == 0 {
+		errs = append(errs, field.Required(path.Child("allowedCIDRs"), "must list allowed client networks, 0.0.0.0/0 admits any address"))
+	}
+
+	for idx, cidr := range expose.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, field.Invalid(path.Child("allowedCIDRs").Index(idx), cidr, "must be a CIDR, like 192.168.0.0/24"))
+		}
+	}
+
+	return errs
+}
+
+func validateMQTTBridge(path *field.Path, bridge *MQTTBridge) field.ErrorList {
+	var errs field.ErrorList
+
+	if _, port, err := net.SplitHostPort(bridge.Address); err != nil {
+		errs = append(errs, field.Invalid(path.Child("address"), bridge.Address, "must be host:port"))
+	} else if _, err = strconv.Atoi(port); err != nil {
+		errs = append(errs, field.Invalid(path.Child("address"), bridge.Address, "port must be numeric"))
+	}
+
+	if bridge.CredentialsSecretRef != nil && bridge.CredentialsSecretRef.Name == "" {
+		errs = append(errs, field.Required(path.Child("credentialsSecretRef", "name"), ""))
+	}
+
+	if len(bridge.Topics) == 0 {
+		errs = append(errs, field.Required(path.Child("topics"), "at least one topic is required"))
+	}
+	for idx, topic := range bridge.Topics {
+		if topic.Pattern == "" || strings.ContainsAny(topic.Pattern, " \t\n\"") {
+			errs = append(errs, field.Invalid(path.Child("topics").Index(idx).Child("pattern"), topic.Pattern, "must be a non-empty topic pattern without whitespace"))
+		}
+	}
+
+	return errs
+}
+
+// validateReplicas checks replicas of a deployment
+func validateReplicas(path *field.Path, dep *Deployment) field.ErrorList {
+	var errs field.ErrorList
+
+	if dep != nil && dep.Replicas != nil && *dep.Replicas < 0 {
+		errs = append(errs, field.Invalid(path, *dep.Replicas, "must not be negative"))
+	}
+
+	return errs
+}
+
+// versionChanged reports whether the desired version differs
+func versionChanged(version *string, old *string) bool {
+	if version == nil || old == nil {
+		return version != old
+	}
+
+	return *version != *old
+}
+
+// isRelease reports whether version is a full semantic version, like 4.1.0
+func isRelease(version string) bool {
+	return semver.IsValid("v"+version) && semver.Canonical("v"+version) == "v"+version
+}
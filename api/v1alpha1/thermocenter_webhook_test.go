@@ -0,0 +1,327 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+// validThermoCenter returns an instance passing validation
+func validThermoCenter() *ThermoCenter {
+	return &ThermoCenter{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "tc",
+		},
+		Spec: ThermoCenterSpec{
+			Ingress: Ingress{
+				HostNames: []string{"thermo.example.com"},
+			},
+			Version: stringPtr("4.1.0"),
+			Database: &Database{
+				Host: "postgres",
+				Name: "thermo",
+				User: "thermo",
+			},
+			Replicas: 1,
+		},
+	}
+}
+
+func TestDefault(t *testing.T) {
+	tc := validThermoCenter()
+	tc.Spec.ExternalMemcached = &ExternalMemcached{Hostname: "memcached"}
+	tc.Spec.ExternalMQTT = &ExternalMQTT{Hostname: "mqtt", TLS: &MQTTTLS{}}
+	tc.Spec.Cache = &Cache{Type: CacheTypeRedis, ExternalRedis: &ExternalRedis{Hostname: "redis"}}
+
+	tc.Default()
+
+	if tc.Spec.ExternalMemcached.Port != DefaultMemcachedPort {
+		t.Errorf("memcached port = %d, want %d", tc.Spec.ExternalMemcached.Port, DefaultMemcachedPort)
+	}
+	if tc.Spec.ExternalMQTT.Port != DefaultMQTTTLSPort {
+		t.Errorf("mqtt port = %d, want %d", tc.Spec.ExternalMQTT.Port, DefaultMQTTTLSPort)
+	}
+	if tc.Spec.Database.Port != DefaultDatabasePort {
+		t.Errorf("database port = %d, want %d", tc.Spec.Database.Port, DefaultDatabasePort)
+	}
+	if tc.Spec.Cache.ExternalRedis.Port != DefaultRedisPort {
+		t.Errorf("redis port = %d, want %d", tc.Spec.Cache.ExternalRedis.Port, DefaultRedisPort)
+	}
+
+	// Explicit ports are kept
+	tc = validThermoCenter()
+	tc.Spec.ExternalMQTT = &ExternalMQTT{Hostname: "mqtt", Port: 1884}
+
+	tc.Default()
+
+	if tc.Spec.ExternalMQTT.Port != 1884 {
+		t.Errorf("mqtt port = %d, want 1884", tc.Spec.ExternalMQTT.Port)
+	}
+}
+
+func TestDefaultComponents(t *testing.T) {
+	int64Ptr := func(i int64) *int64 { return &i }
+
+	tests := []struct {
+		name   string
+		modify func(*ThermoCenter)
+		check  func(*testing.T, *ThermoCenter)
+	}{
+		{"absent components are not added", func(*ThermoCenter) {}, func(t *testing.T, tc *ThermoCenter) {
+			if tc.Spec.Pooler != nil || tc.Spec.Memcached != nil || tc.Spec.Redis != nil || tc.Spec.MQTT != nil || tc.Spec.HomeAssistant != nil {
+				t.Error("components added to the spec")
+			}
+			if tc.Spec.UpdatePolicy != nil || tc.Spec.UpgradeVerification != nil || tc.Spec.Backup != nil {
+				t.Error("optional settings added to the spec")
+			}
+		}},
+		{"thermo-center components follow the instance", func(tc *ThermoCenter) {
+			tc.Spec.API = &Deployment{}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if tc.Spec.API.Replicas != nil || tc.Spec.API.Image != "" {
+				t.Errorf("api = %+v, want replicas and image left to follow the instance", tc.Spec.API)
+			}
+		}},
+		{"pooler", func(tc *ThermoCenter) {
+			tc.Spec.Pooler = &Pooler{}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if p := tc.Spec.Pooler; p.Replicas == nil || *p.Replicas != 1 || p.PoolMode != PoolModeSession || p.PoolSize == nil || *p.PoolSize != DefaultPoolSize {
+				t.Errorf("pooler = %+v", p)
+			}
+		}},
+		{"explicit pooler settings are kept", func(tc *ThermoCenter) {
+			tc.Spec.Pooler = &Pooler{Deployment: Deployment{Replicas: int32Ptr(2)}, PoolMode: PoolModeTransaction, PoolSize: int32Ptr(20)}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if p := tc.Spec.Pooler; *p.Replicas != 2 || p.PoolMode != PoolModeTransaction || *p.PoolSize != 20 {
+				t.Errorf("pooler = %+v", p)
+			}
+		}},
+		{"caches and broker", func(tc *ThermoCenter) {
+			tc.Spec.Memcached = &CacheServer{}
+			tc.Spec.Redis = &CacheServer{Deployment: Deployment{Replicas: int32Ptr(0)}}
+			tc.Spec.MQTT = &MQTT{}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if r := tc.Spec.Memcached.Replicas; r == nil || *r != 1 {
+				t.Errorf("memcached replicas = %v, want 1", r)
+			}
+			if r := tc.Spec.Redis.Replicas; r == nil || *r != 0 {
+				t.Errorf("redis replicas = %v, want 0 kept", r)
+			}
+			if r := tc.Spec.MQTT.Replicas; r == nil || *r != 1 {
+				t.Errorf("mqtt replicas = %v, want 1", r)
+			}
+		}},
+		{"home assistant", func(tc *ThermoCenter) {
+			tc.Spec.HomeAssistant = &HomeAssistant{}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if h := tc.Spec.HomeAssistant; h.Replicas == nil || *h.Replicas != 1 || h.DiscoveryPrefix != DefaultDiscoveryPrefix || h.Interval == nil || *h.Interval != DefaultDiscoveryInterval {
+				t.Errorf("home assistant = %+v", h)
+			}
+		}},
+		{"explicit home assistant settings are kept", func(tc *ThermoCenter) {
+			tc.Spec.HomeAssistant = &HomeAssistant{DiscoveryPrefix: "ha", Interval: int32Ptr(60)}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if h := tc.Spec.HomeAssistant; h.DiscoveryPrefix != "ha" || *h.Interval != 60 {
+				t.Errorf("home assistant = %+v", h)
+			}
+		}},
+		{"update policy", func(tc *ThermoCenter) {
+			tc.Spec.UpdatePolicy = &UpdatePolicy{Channel: UpdateChannelStable}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if i := tc.Spec.UpdatePolicy.Interval; i == nil || i.Duration != DefaultUpdateInterval {
+				t.Errorf("update interval = %v, want %v", i, DefaultUpdateInterval)
+			}
+		}},
+		{"upgrade verification", func(tc *ThermoCenter) {
+			tc.Spec.UpgradeVerification = &UpgradeVerification{}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if d := tc.Spec.UpgradeVerification.ActiveDeadlineSeconds; d == nil || *d != DefaultVerificationDeadlineSeconds {
+				t.Errorf("verification deadline = %v, want %d", d, DefaultVerificationDeadlineSeconds)
+			}
+		}},
+		{"explicit verification deadline is kept", func(tc *ThermoCenter) {
+			tc.Spec.UpgradeVerification = &UpgradeVerification{ActiveDeadlineSeconds: int64Ptr(60)}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if d := tc.Spec.UpgradeVerification.ActiveDeadlineSeconds; *d != 60 {
+				t.Errorf("verification deadline = %d, want 60", *d)
+			}
+		}},
+		{"backup", func(tc *ThermoCenter) {
+			tc.Spec.Backup = &Backup{Schedule: "0 3 * * *", Storage: BackupStorage{PersistentVolumeClaim: &v1.LocalObjectReference{Name: "backups"}}}
+		}, func(t *testing.T, tc *ThermoCenter) {
+			if r := tc.Spec.Backup.Retention; r == nil || *r != DefaultBackupRetention {
+				t.Errorf("backup retention = %v, want %d", r, DefaultBackupRetention)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tc := validThermoCenter()
+			test.modify(tc)
+
+			tc.Default()
+
+			test.check(t, tc)
+
+			// Defaulted instances remain valid
+			if err := tc.ValidateCreate(); err != nil {
+				t.Errorf("defaulted instance is invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*ThermoCenter)
+		valid  bool
+	}{
+		{"valid", func(*ThermoCenter) {}, true},
+		{"built-in database", func(tc *ThermoCenter) { tc.Spec.Database = nil }, true},
+		{"no host names", func(tc *ThermoCenter) { tc.Spec.Ingress.HostNames = nil }, false},
+		{"invalid host name", func(tc *ThermoCenter) { tc.Spec.Ingress.HostNames = []string{"Thermo_Center"} }, false},
+		{"wildcard host name", func(tc *ThermoCenter) { tc.Spec.Ingress.HostNames = []string{"*.example.com"} }, true},
+		{"non-semver version", func(tc *ThermoCenter) { tc.Spec.Version = stringPtr("latest") }, false},
+		{"patch channel without version", func(tc *ThermoCenter) {
+			tc.Spec.Version = nil
+			tc.Spec.UpdatePolicy = &UpdatePolicy{Channel: UpdateChannelPatch}
+		}, false},
+		{"missing database user", func(tc *ThermoCenter) { tc.Spec.Database.User = "" }, false},
+		{"cluster reference with host", func(tc *ThermoCenter) {
+			tc.Spec.Database.ClusterRef = &ClusterRef{Kind: ClusterKindCloudNativePG, Name: "pg"}
+		}, false},
+		{"cluster reference", func(tc *ThermoCenter) {
+			tc.Spec.Database = &Database{ClusterRef: &ClusterRef{Kind: ClusterKindCloudNativePG, Name: "pg"}}
+		}, true},
+		{"bootstrap without admin secret", func(tc *ThermoCenter) { tc.Spec.Database.Bootstrap = &DatabaseBootstrap{} }, false},
+		{"negative replicas", func(tc *ThermoCenter) { tc.Spec.Replicas = -1 }, false},
+		{"negative component replicas", func(tc *ThermoCenter) { tc.Spec.API = &Deployment{Replicas: int32Ptr(-1)} }, false},
+		{"backup without storage", func(tc *ThermoCenter) { tc.Spec.Backup = &Backup{Schedule: "0 3 * * *"} }, false},
+		{"backup deletion policy without backups", func(tc *ThermoCenter) { tc.Spec.DeletionPolicy = DeletionPolicyBackup }, false},
+		{"drop deletion policy without bootstrap", func(tc *ThermoCenter) { tc.Spec.DeletionPolicy = DeletionPolicyDrop }, false},
+		{"drop deletion policy of built-in database", func(tc *ThermoCenter) {
+			tc.Spec.Database = nil
+			tc.Spec.DeletionPolicy = DeletionPolicyDrop
+		}, true},
+		{"persistent mqtt with replicas", func(tc *ThermoCenter) {
+			tc.Spec.MQTT = &MQTT{Deployment: Deployment{Replicas: int32Ptr(2)}, Persistence: &MQTTPersistence{}}
+		}, false},
+		{"exposed mqtt with invalid cidr", func(tc *ThermoCenter) {
+			tc.Spec.MQTT = &MQTT{Expose: &MQTTExpose{ServiceType: v1.ServiceTypeNodePort, AllowedCIDRs: []string{"192.168.1.1"}}}
+		}, false},
+		{"exposed mqtt without cidrs", func(tc *ThermoCenter) {
+			tc.Spec.MQTT = &MQTT{Expose: &MQTTExpose{ServiceType: v1.ServiceTypeLoadBalancer}}
+		}, false},
+		{"exposed mqtt", func(tc *ThermoCenter) {
+			tc.Spec.MQTT = &MQTT{Expose: &MQTTExpose{ServiceType: v1.ServiceTypeNodePort, AllowedCIDRs: []string{"192.168.1.0/24"}}}
+		}, true},
+		{"bridge without port", func(tc *ThermoCenter) {
+			tc.Spec.MQTT = &MQTT{Bridge: &MQTTBridge{Address: "mqtt.example.com", Topics: []MQTTBridgeTopic{{Pattern: "#"}}}}
+		}, false},
+		{"bridge with whitespace in pattern", func(tc *ThermoCenter) {
+			tc.Spec.MQTT = &MQTT{Bridge: &MQTTBridge{Address: "mqtt.example.com:1883", Topics: []MQTTBridgeTopic{{Pattern: "a b"}}}}
+		}, false},
+		{"external redis with memcached", func(tc *ThermoCenter) {
+			tc.Spec.Cache = &Cache{ExternalRedis: &ExternalRedis{Hostname: "redis"}}
+		}, false},
+		{"redis with replicas", func(tc *ThermoCenter) {
+			tc.Spec.Redis = &CacheServer{Deployment: Deployment{Replicas: int32Ptr(2)}}
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tc := validThermoCenter()
+			test.modify(tc)
+
+			err := tc.ValidateCreate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(old *ThermoCenter, tc *ThermoCenter)
+		valid  bool
+	}{
+		{"unchanged", func(old *ThermoCenter, tc *ThermoCenter) {}, true},
+		{"database host change", func(old *ThermoCenter, tc *ThermoCenter) { tc.Spec.Database.Host = "other" }, false},
+		{"database name change", func(old *ThermoCenter, tc *ThermoCenter) { tc.Spec.Database.Name = "other" }, false},
+		{"switching to built-in database", func(old *ThermoCenter, tc *ThermoCenter) { tc.Spec.Database = nil }, false},
+		{"version upgrade", func(old *ThermoCenter, tc *ThermoCenter) { tc.Spec.Version = stringPtr("4.2.0") }, true},
+		{"version below database", func(old *ThermoCenter, tc *ThermoCenter) { tc.Spec.Version = stringPtr("4.0.0") }, false},
+		{"automatically updated beyond version", func(old *ThermoCenter, tc *ThermoCenter) {
+			old.Status.DatabaseVersion = "4.3.0"
+			tc.Spec.Replicas = 2
+		}, true},
+		{"finalizer added after automatic update", func(old *ThermoCenter, tc *ThermoCenter) {
+			old.Status.DatabaseVersion = "4.3.0"
+			tc.Finalizers = []string{"kojedz.in/finalizer"}
+		}, true},
+		{"deleted instance", func(old *ThermoCenter, tc *ThermoCenter) {
+			now := metav1.Now()
+			tc.DeletionTimestamp = &now
+			tc.Spec.Database.Host = "other"
+		}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old := validThermoCenter()
+			old.Status.DatabaseVersion = "4.1.0"
+			tc := validThermoCenter()
+			test.modify(old, tc)
+
+			err := tc.ValidateUpdate(old)
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
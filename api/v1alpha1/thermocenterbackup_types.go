@@ -0,0 +1,111 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// BackupPending is the phase of backups not yet started
+	BackupPending = "Pending"
+
+	// BackupRunning is the phase of backups in progress
+	BackupRunning = "Running"
+
+	// BackupSucceeded is the phase of completed backups
+	BackupSucceeded = "Succeeded"
+
+	// BackupFailed is the phase of failed backups
+	BackupFailed = "Failed"
+)
+
+// ThermoCenterBackupSpec defines the desired state of ThermoCenterBackup
+type ThermoCenterBackupSpec struct {
+	// ThermoCenter is the name of the backed up instance in the same namespace
+	ThermoCenter string `json:"thermoCenter"`
+}
+
+// ThermoCenterBackupStatus defines the observed state of ThermoCenterBackup
+type ThermoCenterBackupStatus struct {
+	// Phase is one of Pending, Running, Succeeded or Failed
+	Phase string `json:"phase,omitempty"`
+
+	// JobName is the name of the backup job
+	JobName string `json:"jobName,omitempty"`
+
+	// Storage the backup is stored in
+	Storage *BackupStorage `json:"storage,omitempty"`
+
+	// File is the name of the backup in its storage
+	File string `json:"file,omitempty"`
+
+	// Location describes the backup as an URL
+	Location string `json:"location,omitempty"`
+
+	// Size of the backup in bytes
+	Size int64 `json:"size,omitempty"`
+
+	// DatabaseVersion of the instance when the backup was taken
+	DatabaseVersion string `json:"databaseVersion,omitempty"`
+
+	// StartTime is the time the backup job started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is the time the backup job finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message describes failures
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:JSONPath=.spec.thermoCenter,description="ThermoCenter instance",name=ThermoCenter,type=string
+// +kubebuilder:printcolumn:JSONPath=.status.phase,description="Backup phase",name=Phase,type=string
+// +kubebuilder:printcolumn:JSONPath=.status.size,description="Backup size in bytes",name=Size,type=integer
+// +kubebuilder:printcolumn:JSONPath=.status.completionTime,description="Completion time",name=Completed,type=date
+
+// ThermoCenterBackup is the Schema for the thermocenterbackups API
+type ThermoCenterBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ThermoCenterBackupSpec   `json:"spec,omitempty"`
+	Status ThermoCenterBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ThermoCenterBackupList contains a list of ThermoCenterBackup
+type ThermoCenterBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ThermoCenterBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ThermoCenterBackup{}, &ThermoCenterBackupList{})
+}
@@ -0,0 +1,127 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RestorePending is the phase of restores not yet started
+	RestorePending = "Pending"
+
+	// RestorePausing is the phase while components of the instance are stopped
+	RestorePausing = "Pausing"
+
+	// RestoreRestoring is the phase while the backup is being restored
+	RestoreRestoring = "Restoring"
+
+	// RestoreMigrating is the phase while the restored database is migrated and components are started
+	RestoreMigrating = "Migrating"
+
+	// RestoreSucceeded is the phase of completed restores
+	RestoreSucceeded = "Succeeded"
+
+	// RestoreFailed is the phase of failed restores
+	RestoreFailed = "Failed"
+)
+
+// BackupLocation specifies a backup file
+type BackupLocation struct {
+	// Storage the backup is stored in
+	Storage BackupStorage `json:"storage"`
+
+	// File is the name of the backup in its storage
+	File string `json:"file"`
+
+	// DatabaseVersion the backup was taken at, used if the dump does not record it
+	DatabaseVersion string `json:"databaseVersion"`
+}
+
+// BackupSource references a backup, exactly one of the fields must be set
+type BackupSource struct {
+	// Backup is the name of a succeeded ThermoCenterBackup in the same namespace
+	Backup string `json:"backup,omitempty"`
+
+	// Location specifies a backup not recorded as a ThermoCenterBackup
+	Location *BackupLocation `json:"location,omitempty"`
+}
+
+// ThermoCenterRestoreSpec defines the desired state of ThermoCenterRestore
+type ThermoCenterRestoreSpec struct {
+	// ThermoCenter is the name of the restored instance in the same namespace
+	ThermoCenter string `json:"thermoCenter"`
+
+	BackupSource `json:",inline"`
+}
+
+// ThermoCenterRestoreStatus defines the observed state of ThermoCenterRestore
+type ThermoCenterRestoreStatus struct {
+	// Phase is one of Pending, Pausing, Restoring, Migrating, Succeeded or Failed
+	Phase string `json:"phase,omitempty"`
+
+	// JobName is the name of the restore job
+	JobName string `json:"jobName,omitempty"`
+
+	// DatabaseVersion of the restored backup
+	DatabaseVersion string `json:"databaseVersion,omitempty"`
+
+	// StartTime is the time the restore started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is the time the restore finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message describes failures
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:JSONPath=.spec.thermoCenter,description="ThermoCenter instance",name=ThermoCenter,type=string
+// +kubebuilder:printcolumn:JSONPath=.status.phase,description="Restore phase",name=Phase,type=string
+// +kubebuilder:printcolumn:JSONPath=.status.completionTime,description="Completion time",name=Completed,type=date
+
+// ThermoCenterRestore is the Schema for the thermocenterrestores API
+type ThermoCenterRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ThermoCenterRestoreSpec   `json:"spec,omitempty"`
+	Status ThermoCenterRestoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ThermoCenterRestoreList contains a list of ThermoCenterRestore
+type ThermoCenterRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ThermoCenterRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ThermoCenterRestore{}, &ThermoCenterRestoreList{})
+}
@@ -0,0 +1,133 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1beta1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+)
+
+var _ conversion.Convertible = &ThermoCenter{}
+
+// ConvertTo converts this ThermoCenter to the hub version
+func (src *ThermoCenter) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.ThermoCenter)
+
+	dst.ObjectMeta = src.ObjectMeta
+
+	in := src.Spec.DeepCopy()
+	dst.Spec = v1alpha1.ThermoCenterSpec{
+		Ingress:             in.Ingress,
+		ExternalMemcached:   in.Dependencies.Cache,
+		ExternalMQTT:        in.Dependencies.Broker,
+		Version:             in.Version,
+		UpdatePolicy:        in.UpdatePolicy,
+		Replicas:            in.Replicas,
+		Database:            in.Dependencies.Database,
+		PostgreSQL:          in.Dependencies.PostgreSQL,
+		UI:                  in.Components.UI,
+		API:                 in.Components.API,
+		WS:                  in.Components.WS,
+		GRPC:                in.Components.GRPCServer,
+		Receiver:            in.Components.Receiver,
+		MQTT:                in.Components.MQTT,
+		Memcached:           in.Components.Memcached,
+		Redis:               in.Components.Redis,
+		Pooler:              in.Components.Pooler,
+		HomeAssistant:       in.Components.HomeAssistant,
+		Graphite:            in.Graphite,
+		Maintenance:         in.Maintenance,
+		UpgradeVerification: in.UpgradeVerification,
+		Backup:              in.Backup,
+		InitFrom:            in.InitFrom,
+		EnableReceiver:      in.EnableReceiver,
+		DeletionPolicy:      in.DeletionPolicy,
+		ImageRegistry:       in.ImageRegistry,
+		PinDigests:          in.PinDigests,
+	}
+
+	if in.Dependencies.CacheType != "" || in.Dependencies.Redis != nil {
+		dst.Spec.Cache = &v1alpha1.Cache{
+			Type:          in.Dependencies.CacheType,
+			ExternalRedis: in.Dependencies.Redis,
+		}
+	}
+
+	src.Status.DeepCopyInto(&dst.Status)
+
+	return nil
+}
+
+// ConvertFrom converts from the hub version to this version
+func (dst *ThermoCenter) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.ThermoCenter)
+
+	dst.ObjectMeta = src.ObjectMeta
+
+	in := src.Spec.DeepCopy()
+	dst.Spec = ThermoCenterSpec{
+		Ingress:       in.Ingress,
+		Version:       in.Version,
+		UpdatePolicy:  in.UpdatePolicy,
+		ImageRegistry: in.ImageRegistry,
+		PinDigests:    in.PinDigests,
+		Replicas:      in.Replicas,
+		Components: Components{
+			UI:            in.UI,
+			API:           in.API,
+			WS:            in.WS,
+			GRPCServer:    in.GRPC,
+			Receiver:      in.Receiver,
+			MQTT:          in.MQTT,
+			Memcached:     in.Memcached,
+			Redis:         in.Redis,
+			Pooler:        in.Pooler,
+			HomeAssistant: in.HomeAssistant,
+		},
+		Dependencies: Dependencies{
+			Database:   in.Database,
+			PostgreSQL: in.PostgreSQL,
+			Cache:      in.ExternalMemcached,
+			Broker:     in.ExternalMQTT,
+		},
+		Graphite:            in.Graphite,
+		Maintenance:         in.Maintenance,
+		UpgradeVerification: in.UpgradeVerification,
+		Backup:              in.Backup,
+		InitFrom:            in.InitFrom,
+		EnableReceiver:      in.EnableReceiver,
+		DeletionPolicy:      in.DeletionPolicy,
+	}
+
+	if in.Cache != nil {
+		dst.Spec.Dependencies.CacheType = in.Cache.Type
+		dst.Spec.Dependencies.Redis = in.Cache.ExternalRedis
+	}
+
+	src.Status.DeepCopyInto(&dst.Status)
+
+	return nil
+}
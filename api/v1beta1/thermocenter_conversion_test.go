@@ -0,0 +1,230 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1beta1
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func deployment(image string) *v1alpha1.Deployment {
+	return &v1alpha1.Deployment{Image: image, Replicas: int32Ptr(2)}
+}
+
+// hubThermoCenter returns an instance with every spec field set, each to a distinct value
+func hubThermoCenter() *v1alpha1.ThermoCenter {
+	return &v1alpha1.ThermoCenter{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "tc",
+			Labels:      map[string]string{"app": "thermo-center"},
+			Annotations: map[string]string{"note": "converted"},
+		},
+		Spec: v1alpha1.ThermoCenterSpec{
+			Ingress:           v1alpha1.Ingress{HostNames: []string{"thermo.example.com"}},
+			ExternalMemcached: &v1alpha1.ExternalMemcached{Hostname: "memcached", Port: 11211},
+			ExternalMQTT:      &v1alpha1.ExternalMQTT{Hostname: "mqtt", Port: 8883, TLS: &v1alpha1.MQTTTLS{}},
+			Version:           stringPtr("4.1.0"),
+			ImageRegistry:     &v1alpha1.ImageRegistry{PullSecrets: []v1.LocalObjectReference{{Name: "pull"}}},
+			PinDigests:        true,
+			UpdatePolicy:      &v1alpha1.UpdatePolicy{Channel: v1alpha1.UpdateChannelPatch},
+			Replicas:          3,
+			Database:          &v1alpha1.Database{Host: "postgres", Port: 5432, Name: "thermo", User: "thermo"},
+			PostgreSQL:        &v1alpha1.PostgreSQL{Image: "postgres:13"},
+			Pooler:            &v1alpha1.Pooler{Deployment: *deployment("pooler"), PoolMode: "transaction"},
+			UI:                deployment("ui"),
+			API:               deployment("api"),
+			WS:                deployment("ws"),
+			GRPC:              deployment("grpc"),
+			Receiver:          deployment("receiver"),
+			Cache: &v1alpha1.Cache{
+				Type:          v1alpha1.CacheTypeRedis,
+				ExternalRedis: &v1alpha1.ExternalRedis{Hostname: "redis", Port: 6379},
+			},
+			Memcached:           &v1alpha1.CacheServer{Deployment: *deployment("memcached")},
+			Redis:               &v1alpha1.CacheServer{Deployment: *deployment("redis")},
+			MQTT:                &v1alpha1.MQTT{Deployment: *deployment("mosquitto")},
+			HomeAssistant:       &v1alpha1.HomeAssistant{Deployment: *deployment("homeassistant"), DiscoveryPrefix: "ha"},
+			Graphite:            v1alpha1.Graphite{Hostname: "graphite", Port: 2003},
+			Maintenance:         &v1alpha1.Maintenance{Mode: v1alpha1.MaintenanceModePage},
+			UpgradeVerification: &v1alpha1.UpgradeVerification{Image: "curl"},
+			Backup:              &v1alpha1.Backup{Schedule: "0 3 * * *"},
+			InitFrom:            &v1alpha1.BackupSource{Backup: "seed"},
+			EnableReceiver:      true,
+			DeletionPolicy:      v1alpha1.DeletionPolicyBackup,
+		},
+		Status: v1alpha1.ThermoCenterStatus{
+			DatabaseVersion: "4.0.0",
+			Status:          "ready",
+		},
+	}
+}
+
+// assertAllFieldsSet fails for zero valued fields of a struct, so that
+// fixtures cover fields added later
+func assertAllFieldsSet(t *testing.T, name string, v interface{}) {
+	t.Helper()
+
+	value := reflect.ValueOf(v)
+	for idx := 0; idx < value.NumField(); idx++ {
+		if value.Field(idx).IsZero() {
+			t.Errorf("%s.%s is not set in the fixture", name, value.Type().Field(idx).Name)
+		}
+	}
+}
+
+func TestConvertFrom(t *testing.T) {
+	hub := hubThermoCenter()
+	assertAllFieldsSet(t, "v1alpha1.ThermoCenterSpec", hub.Spec)
+
+	tc := &ThermoCenter{}
+	if err := tc.ConvertFrom(hub); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Every field of this version receives a value
+	assertAllFieldsSet(t, "v1beta1.ThermoCenterSpec", tc.Spec)
+	assertAllFieldsSet(t, "v1beta1.Components", tc.Spec.Components)
+	assertAllFieldsSet(t, "v1beta1.Dependencies", tc.Spec.Dependencies)
+
+	spec := tc.Spec
+	checks := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"ingress", spec.Ingress, hub.Spec.Ingress},
+		{"version", spec.Version, hub.Spec.Version},
+		{"updatePolicy", spec.UpdatePolicy, hub.Spec.UpdatePolicy},
+		{"imageRegistry", spec.ImageRegistry, hub.Spec.ImageRegistry},
+		{"pinDigests", spec.PinDigests, hub.Spec.PinDigests},
+		{"replicas", spec.Replicas, hub.Spec.Replicas},
+		{"components.ui", spec.Components.UI, hub.Spec.UI},
+		{"components.api", spec.Components.API, hub.Spec.API},
+		{"components.ws", spec.Components.WS, hub.Spec.WS},
+		{"components.grpcserver", spec.Components.GRPCServer, hub.Spec.GRPC},
+		{"components.receiver", spec.Components.Receiver, hub.Spec.Receiver},
+		{"components.memcached", spec.Components.Memcached, hub.Spec.Memcached},
+		{"components.redis", spec.Components.Redis, hub.Spec.Redis},
+		{"components.mqtt", spec.Components.MQTT, hub.Spec.MQTT},
+		{"components.homeAssistant", spec.Components.HomeAssistant, hub.Spec.HomeAssistant},
+		{"components.pooler", spec.Components.Pooler, hub.Spec.Pooler},
+		{"dependencies.database", spec.Dependencies.Database, hub.Spec.Database},
+		{"dependencies.postgresql", spec.Dependencies.PostgreSQL, hub.Spec.PostgreSQL},
+		{"dependencies.cache", spec.Dependencies.Cache, hub.Spec.ExternalMemcached},
+		{"dependencies.cacheType", spec.Dependencies.CacheType, hub.Spec.Cache.Type},
+		{"dependencies.redis", spec.Dependencies.Redis, hub.Spec.Cache.ExternalRedis},
+		{"dependencies.broker", spec.Dependencies.Broker, hub.Spec.ExternalMQTT},
+		{"graphite", spec.Graphite, hub.Spec.Graphite},
+		{"maintenance", spec.Maintenance, hub.Spec.Maintenance},
+		{"upgradeVerification", spec.UpgradeVerification, hub.Spec.UpgradeVerification},
+		{"backup", spec.Backup, hub.Spec.Backup},
+		{"initFrom", spec.InitFrom, hub.Spec.InitFrom},
+		{"enableReceiver", spec.EnableReceiver, hub.Spec.EnableReceiver},
+		{"deletionPolicy", spec.DeletionPolicy, hub.Spec.DeletionPolicy},
+		{"metadata", tc.ObjectMeta, hub.ObjectMeta},
+		{"status", tc.Status, hub.Status},
+	}
+
+	for _, check := range checks {
+		if !equality.Semantic.DeepEqual(check.got, check.want) {
+			t.Errorf("%s = %+v, want %+v", check.name, check.got, check.want)
+		}
+	}
+
+	// The converted object does not share memory with the hub
+	tc.Spec.Components.API.Image = "changed"
+	if hub.Spec.API.Image == "changed" {
+		t.Error("converted spec aliases the hub")
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	hub := hubThermoCenter()
+
+	tc := &ThermoCenter{}
+	if err := tc.ConvertFrom(hub); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	back := &v1alpha1.ThermoCenter{}
+	if err := tc.ConvertTo(back); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !equality.Semantic.DeepEqual(back, hub) {
+		t.Errorf("round trip through v1beta1 changed the instance:\n%+v\nwant\n%+v", back.Spec, hub.Spec)
+	}
+
+	// And the other way around
+	again := &ThermoCenter{}
+	if err := again.ConvertFrom(back); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !equality.Semantic.DeepEqual(again, tc) {
+		t.Errorf("round trip through v1alpha1 changed the instance:\n%+v\nwant\n%+v", again.Spec, tc.Spec)
+	}
+}
+
+func TestConvertCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies Dependencies
+		want         *v1alpha1.Cache
+	}{
+		{"no cache settings", Dependencies{}, nil},
+		{"cache type only", Dependencies{CacheType: v1alpha1.CacheTypeRedis}, &v1alpha1.Cache{Type: v1alpha1.CacheTypeRedis}},
+		{"external redis only", Dependencies{Redis: &v1alpha1.ExternalRedis{Hostname: "redis"}}, &v1alpha1.Cache{ExternalRedis: &v1alpha1.ExternalRedis{Hostname: "redis"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tc := &ThermoCenter{Spec: ThermoCenterSpec{Dependencies: test.dependencies}}
+
+			hub := &v1alpha1.ThermoCenter{}
+			if err := tc.ConvertTo(hub); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(hub.Spec.Cache, test.want) {
+				t.Errorf("cache = %+v, want %+v", hub.Spec.Cache, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+)
+
+// Components specifies deployment parameters of each component
+type Components struct {
+	UI         *v1alpha1.Deployment `json:"ui,omitempty"`
+	API        *v1alpha1.Deployment `json:"api,omitempty"`
+	WS         *v1alpha1.Deployment `json:"ws,omitempty"`
+	GRPCServer *v1alpha1.Deployment `json:"grpcserver,omitempty"`
+	Receiver   *v1alpha1.Deployment `json:"receiver,omitempty"`
+
+	// Memcached specifies the built-in memcached
+	Memcached *v1alpha1.CacheServer `json:"memcached,omitempty"`
+
+	// Redis specifies the built-in Redis, used with the redis cache type
+	Redis *v1alpha1.CacheServer `json:"redis,omitempty"`
+
+	// MQTT specifies the built-in MQTT broker
+	MQTT *v1alpha1.MQTT `json:"mqtt,omitempty"`
+
+	// HomeAssistant enables publishing sensors to Home Assistant through MQTT discovery
+	HomeAssistant *v1alpha1.HomeAssistant `json:"homeAssistant,omitempty"`
+
+	// Pooler enables connection pooling for api and grpcserver
+	Pooler *v1alpha1.Pooler `json:"pooler,omitempty"`
+}
+
+// Dependencies specifies services ThermoCenter depends on
+type Dependencies struct {
+	// Database specifies PostgreSQL access configuration. If not specified,
+	// a built-in PostgreSQL instance is provisioned.
+	Database *v1alpha1.Database `json:"database,omitempty"`
+
+	// PostgreSQL specifies the built-in PostgreSQL instance, used when no database is specified
+	PostgreSQL *v1alpha1.PostgreSQL `json:"postgresql,omitempty"`
+
+	// Cache points to an external memcached instance, instead of the built-in one
+	Cache *v1alpha1.ExternalMemcached `json:"cache,omitempty"`
+
+	// CacheType selects the cache, either memcached or redis, defaults to memcached
+	// +kubebuilder:validation:Enum=memcached;redis
+	CacheType string `json:"cacheType,omitempty"`
+
+	// Redis points to an external Redis instance, instead of the built-in one
+	Redis *v1alpha1.ExternalRedis `json:"redis,omitempty"`
+
+	// Broker points to an external MQTT broker, instead of the built-in one
+	Broker *v1alpha1.ExternalMQTT `json:"broker,omitempty"`
+}
+
+// ThermoCenterSpec defines the desired state of ThermoCenter
+type ThermoCenterSpec struct {
+	// Ingress represents Ingress parameters
+	Ingress v1alpha1.Ingress `json:"ingress"`
+
+	// Version defines the desired version. If empty, uses 'latest' tag for all images,
+	// and migrates the database whenever the digest of the api image changes
+	Version *string `json:"version,omitempty"`
+
+	// UpdatePolicy enables automatic updates to versions newer than Version
+	UpdatePolicy *v1alpha1.UpdatePolicy `json:"updatePolicy,omitempty"`
+
+	// ImageRegistry specifies image rewrites and pull options
+	ImageRegistry *v1alpha1.ImageRegistry `json:"imageRegistry,omitempty"`
+
+	// PinDigests pins component images by digest. Digests are resolved
+	// once per version, and recorded in status.
+	PinDigests bool `json:"pinDigests,omitempty"`
+
+	// Desired replicas of all components, defaults to 1
+	Replicas int32 `json:"replicas"`
+
+	// Components specifies deployment parameters of each component,
+	// on production deployments these are typically not specified
+	Components Components `json:"components,omitempty"`
+
+	// Dependencies specifies the database, external cache and broker
+	Dependencies Dependencies `json:"dependencies,omitempty"`
+
+	// Graphite parameter specification
+	Graphite v1alpha1.Graphite `json:"graphite,omitempty"`
+
+	// Maintenance enables maintenance mode during database migrations
+	Maintenance *v1alpha1.Maintenance `json:"maintenance,omitempty"`
+
+	// UpgradeVerification enables checking components after an upgrade
+	UpgradeVerification *v1alpha1.UpgradeVerification `json:"upgradeVerification,omitempty"`
+
+	// Backup enables scheduled database backups
+	Backup *v1alpha1.Backup `json:"backup,omitempty"`
+
+	// InitFrom initializes the empty database of a new instance from a backup
+	InitFrom *v1alpha1.BackupSource `json:"initFrom,omitempty"`
+
+	// EnableReceiver runs the receiver of instances initialized from a backup,
+	// which is disabled by default so that clones do not compete for the radio
+	EnableReceiver bool `json:"enableReceiver,omitempty"`
+
+	// DeletionPolicy specifies what happens to the database when the instance is deleted,
+	// one of Retain, Backup or Drop, defaults to Retain
+	// +kubebuilder:validation:Enum=Retain;Backup;Drop
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:unservedversion
+// +kubebuilder:printcolumn:JSONPath=.status.databaseVersion,description="Database version",name=DBVer,type=string
+// +kubebuilder:printcolumn:JSONPath=.status.status,description="ThermoCenter status",name=Status,type=string
+// +kubebuilder:printcolumn:JSONPath=.status.lastMigrationTime,description="Last migration time",name=LastMigration,type=date
+
+// ThermoCenter is the Schema for the thermocenters API
+type ThermoCenter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ThermoCenterSpec            `json:"spec,omitempty"`
+	Status v1alpha1.ThermoCenterStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ThermoCenterList contains a list of ThermoCenter
+type ThermoCenterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ThermoCenter `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ThermoCenter{}, &ThermoCenterList{})
+}
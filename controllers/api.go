@@ -58,6 +58,8 @@ func (api *apiReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzi
 		},
 	)
 
+	r.pooler.setDatabaseEnvironment(r, i, ps)
+
 	ps.Containers[0].Env = append(ps.Containers[0].Env,
 		v1.EnvVar{
 			Name:  "ALLOWED_HOSTS",
@@ -69,7 +71,7 @@ func (api *apiReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzi
 		},
 	)
 
-	r.memcached.setEnvironment(r, i, &ps.Containers[0].Env)
+	r.setCacheEnvironment(i, &ps.Containers[0].Env)
 
 	ps.Containers[0].ReadinessProbe = &v1.Probe{
 		Handler: v1.Handler{
@@ -0,0 +1,411 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;delete
+
+const (
+	backupComponent        = "backup"
+	backupDefaultRetention = kojedzinv1alpha1.DefaultBackupRetention
+	backupMCImage          = "minio/mc:RELEASE.2020-12-18T10-53-53Z"
+	backupDir              = "/backup"
+)
+
+// Records the database version in the database, so that it is part of the dump
+const backupVersionScript = `psql -q -v ON_ERROR_STOP=1 -c "CREATE TABLE IF NOT EXISTS thermo_center_controller_version (version text NOT NULL); DELETE FROM thermo_center_controller_version; INSERT INTO thermo_center_controller_version VALUES ('${DATABASE_VERSION}')"
+`
+
+// Dumps the database into backupDir, and prunes old backups from there
+const backupPVCScript = `set -e
+` + backupVersionScript + `file="${BACKUP_NAME}-$(date -u +%Y%m%d%H%M%S).dump"
+pg_dump -Fc -f "/backup/${file}.tmp"
+mv "/backup/${file}.tmp" "/backup/${file}"
+ls -1 /backup | grep -E "^${BACKUP_NAME}-[0-9]{14}\.dump$" | sort -r | tail -n +$((RETENTION + 1)) | while read f; do rm -f "/backup/${f}"; done
+printf '{"file":"%s","size":%s}' "${file}" "$(stat -c %s "/backup/${file}")" > /dev/termination-log
+`
+
+// Dumps the database into backupDir, for upload to S3
+const backupDumpScript = `set -e
+` + backupVersionScript + `file="${BACKUP_NAME}-$(date -u +%Y%m%d%H%M%S).dump"
+pg_dump -Fc -f "/backup/${file}"
+echo "${file}" > /backup/name
+`
+
+// Uploads the dump to S3, and prunes old backups from there
+const backupUploadScript = `set -e
+file="$(cat /backup/name)"
+mc alias set target "${S3_ENDPOINT}" "${S3_ACCESS_KEY}" "${S3_SECRET_KEY}" > /dev/null
+mc cp "/backup/${file}" "target/${S3_BUCKET}/${S3_PREFIX}${file}"
+mc ls "target/${S3_BUCKET}/${S3_PREFIX}" | awk '{print $NF}' | grep -E "^${BACKUP_NAME}-[0-9]{14}\.dump$" | sort -r | tail -n +$((RETENTION + 1)) | while read f; do mc rm "target/${S3_BUCKET}/${S3_PREFIX}${f}"; done
+printf '{"file":"%s","size":%s}' "${file}" "$(wc -c < "/backup/${file}")" > /dev/termination-log
+`
+
+// backupResult is reported by backup pods in their termination message
+type backupResult struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+func thermoCenterBackupCronJobName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-" + backupComponent
+}
+
+// backupRetention returns the number of backups kept
+func backupRetention(i *kojedzinv1alpha1.ThermoCenter) int32 {
+	if i.Spec.Backup.Retention != nil {
+		return *i.Spec.Backup.Retention
+	}
+
+	return backupDefaultRetention
+}
+
+// backupLocation describes a backup file as an URL
+func backupLocation(storage *kojedzinv1alpha1.BackupStorage, file string) string {
+	if storage.S3 != nil {
+		return fmt.Sprintf("s3://%s/%s%s", storage.S3.Bucket, storage.S3.Prefix, file)
+	}
+
+	return fmt.Sprintf("pvc://%s/%s", storage.PersistentVolumeClaim.Name, file)
+}
+
+// parseBackupResult extracts the result from the termination message of a backup pod
+func parseBackupResult(pods []v1.Pod) (*backupResult, error) {
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Terminated == nil || cs.State.Terminated.ExitCode != 0 || cs.State.Terminated.Message == "" {
+				continue
+			}
+
+			result := &backupResult{}
+			if err := json.Unmarshal([]byte(cs.State.Terminated.Message), result); err != nil {
+				return nil, err
+			}
+
+			return result, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no backup pod reported its result")
+}
+
+// databaseEnv returns libpq environment variables to connect directly to the instance's database
+func databaseEnv(i *kojedzinv1alpha1.ThermoCenter) []v1.EnvVar {
+	return []v1.EnvVar{
+		secretKeyEnv("PGHOST", thermoCenterSecretName(i), sDBHOST),
+		secretKeyEnv("PGPORT", thermoCenterSecretName(i), sDBPORT),
+		secretKeyEnv("PGDATABASE", thermoCenterSecretName(i), sDBNAME),
+		secretKeyEnv("PGUSER", thermoCenterSecretName(i), sDBUSER),
+		secretKeyEnv("PGPASSWORD", thermoCenterSecretName(i), sDBPASSWORD),
+	}
+}
+
+// s3Env returns environment variables describing an S3 bucket for mc
+func s3Env(s3 *kojedzinv1alpha1.S3Storage) []v1.EnvVar {
+	return []v1.EnvVar{
+		{
+			Name:  "HOME",
+			Value: backupDir,
+		},
+		{
+			Name:  "S3_ENDPOINT",
+			Value: s3.Endpoint,
+		},
+		{
+			Name:  "S3_BUCKET",
+			Value: s3.Bucket,
+		},
+		{
+			Name:  "S3_PREFIX",
+			Value: s3.Prefix,
+		},
+		secretKeyEnv("S3_ACCESS_KEY", s3.CredentialsSecretRef.Name, "accessKey"),
+		secretKeyEnv("S3_SECRET_KEY", s3.CredentialsSecretRef.Name, "secretKey"),
+	}
+}
+
+// cronJobGroupKind identifies CronJobs, served as batch/v1 from Kubernetes 1.21,
+// and as batch/v1beta1 until 1.25
+var cronJobGroupKind = schema.GroupKind{Group: "batch", Kind: "CronJob"}
+
+// cronJobGVK returns the preferred CronJob version served by the cluster
+func (r *ThermoCenterReconciler) cronJobGVK() (schema.GroupVersionKind, error) {
+	mapping, err := r.RESTMapper().RESTMapping(cronJobGroupKind, "v1", "v1beta1")
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+
+	return mapping.GroupVersionKind, nil
+}
+
+// reconcileBackupCronJob maintains the CronJob running scheduled backups. The
+// CronJob is built with the batch/v1beta1 types, which are identical to those
+// of batch/v1, and is managed as an unstructured object of the served version.
+func (r *ThermoCenterReconciler) reconcileBackupCronJob(i *kojedzinv1alpha1.ThermoCenter) error {
+	if i.Spec.Backup == nil {
+		return r.deleteBackupCronJob(i)
+	}
+
+	gvk, err := r.cronJobGVK()
+	if err != nil {
+		return err
+	}
+
+	cronJobName := thermoCenterBackupCronJobName(i)
+	cronJob := &unstructured.Unstructured{}
+	cronJob.SetGroupVersionKind(gvk)
+	found := true
+
+	err = r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: cronJobName}, cronJob)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		found = false
+	}
+
+	if !found {
+		cronJob.SetNamespace(i.Namespace)
+		cronJob.SetName(cronJobName)
+
+		if err = controllerutil.SetControllerReference(i, cronJob, r.Scheme); err != nil {
+			return err
+		}
+	}
+
+	historyLimit := int32(1)
+
+	// No backups of a database being restored
+	suspend := restorePaused(i)
+
+	spec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&batchv1beta1.CronJobSpec{
+		Schedule:                   i.Spec.Backup.Schedule,
+		ConcurrencyPolicy:          batchv1beta1.ForbidConcurrent,
+		Suspend:                    &suspend,
+		SuccessfulJobsHistoryLimit: &historyLimit,
+		FailedJobsHistoryLimit:     &historyLimit,
+		JobTemplate: batchv1beta1.JobTemplateSpec{
+			ObjectMeta: r.backupJobMeta(i),
+			Spec:       r.backupJobSpec(i),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = unstructured.SetNestedField(cronJob.Object, spec, "spec"); err != nil {
+		return err
+	}
+
+	if found {
+		err = r.Update(context.TODO(), cronJob)
+	} else {
+		err = r.Create(context.TODO(), cronJob)
+	}
+	if err != nil || meta.IsStatusConditionTrue(i.Status.Conditions, kojedzinv1alpha1.ConditionBackupScheduled) {
+		return err
+	}
+
+	meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+		Type:               kojedzinv1alpha1.ConditionBackupScheduled,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: i.Generation,
+		Reason:             "CronJobCreated",
+		Message:            fmt.Sprintf("Backups are run by CronJob %s", cronJobName),
+	})
+
+	return r.Status().Update(context.TODO(), i)
+}
+
+// deleteBackupCronJob removes the CronJob of disabled backups. The CronJob
+// version is only looked up while the BackupScheduled condition is present.
+func (r *ThermoCenterReconciler) deleteBackupCronJob(i *kojedzinv1alpha1.ThermoCenter) error {
+	if meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionBackupScheduled) == nil {
+		return nil
+	}
+
+	gvk, err := r.cronJobGVK()
+	if err != nil {
+		return err
+	}
+
+	cronJob := &unstructured.Unstructured{}
+	cronJob.SetGroupVersionKind(gvk)
+	cronJob.SetNamespace(i.Namespace)
+	cronJob.SetName(thermoCenterBackupCronJobName(i))
+
+	if err = r.Delete(context.TODO(), cronJob); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	meta.RemoveStatusCondition(&i.Status.Conditions, kojedzinv1alpha1.ConditionBackupScheduled)
+
+	return r.Status().Update(context.TODO(), i)
+}
+
+// backupJobMeta returns labels and annotations of backup jobs
+func (r *ThermoCenterReconciler) backupJobMeta(i *kojedzinv1alpha1.ThermoCenter) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Labels: labelsForComponent(i, backupComponent),
+		Annotations: map[string]string{
+			thermoCenterDBVersionAnnotation: i.Status.DatabaseVersion,
+		},
+	}
+}
+
+// backupJobSpec returns the job dumping the database to the backup storage
+func (r *ThermoCenterReconciler) backupJobSpec(i *kojedzinv1alpha1.ThermoCenter) batchv1.JobSpec {
+	backup := i.Spec.Backup
+
+	image := backup.Image
+	if image == "" {
+		image = postgresqlDefaultImage
+	}
+
+	activeDeadlineSeconds := int64(3600)
+	backoffLimit := int32(0)
+	enableServiceLinks := false
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+	uid := int64(70)
+
+	dump := v1.Container{
+		Name:  backupComponent,
+		Image: r.rewriteImage(i, image),
+		Env: append(databaseEnv(i),
+			v1.EnvVar{
+				Name:  "BACKUP_NAME",
+				Value: i.Name,
+			},
+			v1.EnvVar{
+				Name:  "RETENTION",
+				Value: fmt.Sprintf("%d", backupRetention(i)),
+			},
+			v1.EnvVar{
+				Name:  "DATABASE_VERSION",
+				Value: i.Status.DatabaseVersion,
+			},
+		),
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("10m"),
+				v1.ResourceMemory: resource.MustParse("16Mi"),
+			},
+		},
+		SecurityContext: &v1.SecurityContext{
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		},
+		VolumeMounts: []v1.VolumeMount{{
+			Name:      backupComponent,
+			MountPath: backupDir,
+		}},
+	}
+
+	ps := v1.PodSpec{
+		EnableServiceLinks: &enableServiceLinks,
+		RestartPolicy:      v1.RestartPolicyNever,
+		SecurityContext: &v1.PodSecurityContext{
+			RunAsNonRoot: &runAsNonRoot,
+			RunAsUser:    &uid,
+			RunAsGroup:   &uid,
+			FSGroup:      &uid,
+		},
+	}
+
+	if s3 := backup.Storage.S3; s3 != nil {
+		dump.Command = []string{"sh", "-c", backupDumpScript}
+
+		upload := *dump.DeepCopy()
+		upload.Name = "upload"
+		upload.Image = r.rewriteImage(i, backupMCImage)
+		upload.Command = []string{"sh", "-c", backupUploadScript}
+		upload.Env = append(s3Env(s3),
+			v1.EnvVar{
+				Name:  "BACKUP_NAME",
+				Value: i.Name,
+			},
+			v1.EnvVar{
+				Name:  "RETENTION",
+				Value: fmt.Sprintf("%d", backupRetention(i)),
+			},
+		)
+
+		ps.Volumes = []v1.Volume{{
+			Name: backupComponent,
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		}}
+		setDatabaseTLS(i, &ps, &dump)
+		ps.InitContainers = []v1.Container{dump}
+		ps.Containers = []v1.Container{upload}
+	} else {
+		dump.Command = []string{"sh", "-c", backupPVCScript}
+
+		ps.Volumes = []v1.Volume{{
+			Name: backupComponent,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: backup.Storage.PersistentVolumeClaim.Name,
+				},
+			},
+		}}
+		setDatabaseTLS(i, &ps, &dump)
+		ps.Containers = []v1.Container{dump}
+	}
+
+	setImagePullOptions(i, &ps)
+
+	return batchv1.JobSpec{
+		ActiveDeadlineSeconds: &activeDeadlineSeconds,
+		BackoffLimit:          &backoffLimit,
+		Template: v1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: labelsForComponent(i, backupComponent),
+			},
+			Spec: ps,
+		},
+	}
+}
@@ -0,0 +1,289 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// mappedClient serves a fixed RESTMapper, which the fake client lacks
+type mappedClient struct {
+	client.Client
+	mapper meta.RESTMapper
+}
+
+func (c mappedClient) RESTMapper() meta.RESTMapper {
+	return c.mapper
+}
+
+// withCronJobVersions makes the reconciler's cluster serve CronJobs in the given versions
+func withCronJobVersions(r *ThermoCenterReconciler, versions ...string) {
+	var groupVersions []schema.GroupVersion
+	for _, version := range versions {
+		groupVersions = append(groupVersions, schema.GroupVersion{Group: "batch", Version: version})
+	}
+
+	mapper := meta.NewDefaultRESTMapper(groupVersions)
+	for _, gv := range groupVersions {
+		mapper.Add(gv.WithKind("CronJob"), meta.RESTScopeNamespace)
+	}
+
+	r.Client = mappedClient{Client: r.Client, mapper: mapper}
+}
+
+func newBackupThermoCenter() *kojedzinv1alpha1.ThermoCenter {
+	i := newTestThermoCenter()
+	i.Spec.Backup = &kojedzinv1alpha1.Backup{
+		Schedule: "0 3 * * *",
+		Storage: kojedzinv1alpha1.BackupStorage{
+			S3: &kojedzinv1alpha1.S3Storage{
+				Endpoint:             "http://minio:9000",
+				Bucket:               "backups",
+				Prefix:               "thermo/",
+				CredentialsSecretRef: v1.LocalObjectReference{Name: "minio"},
+			},
+		},
+	}
+
+	return i
+}
+
+func TestReconcileBackupCronJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		want     string
+	}{
+		{"kubernetes 1.20", []string{"v1beta1"}, "batch/v1beta1"},
+		{"kubernetes 1.21", []string{"v1", "v1beta1"}, "batch/v1"},
+		{"kubernetes 1.25", []string{"v1"}, "batch/v1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newBackupThermoCenter()
+			r := newTestReconciler(nil, i)
+			withCronJobVersions(r, test.versions...)
+
+			get := func() (*unstructured.Unstructured, error) {
+				cronJob := &unstructured.Unstructured{}
+				cronJob.SetAPIVersion(test.want)
+				cronJob.SetKind("CronJob")
+
+				return cronJob, r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterBackupCronJobName(i)}, cronJob)
+			}
+
+			if err := r.reconcileBackupCronJob(i); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cronJob, err := get()
+			if err != nil {
+				t.Fatalf("CronJob not created as %s: %v", test.want, err)
+			}
+
+			if schedule, _, _ := unstructured.NestedString(cronJob.Object, "spec", "schedule"); schedule != "0 3 * * *" {
+				t.Errorf("schedule = %q", schedule)
+			}
+			if policy, _, _ := unstructured.NestedString(cronJob.Object, "spec", "concurrencyPolicy"); policy != "Forbid" {
+				t.Errorf("concurrencyPolicy = %q", policy)
+			}
+			if !meta.IsStatusConditionTrue(i.Status.Conditions, kojedzinv1alpha1.ConditionBackupScheduled) {
+				t.Errorf("conditions = %v", i.Status.Conditions)
+			}
+			if owners := cronJob.GetOwnerReferences(); len(owners) != 1 || owners[0].Kind != "ThermoCenter" {
+				t.Errorf("owner references = %v", owners)
+			}
+			containers, _, _ := unstructured.NestedSlice(cronJob.Object, "spec", "jobTemplate", "spec", "template", "spec", "containers")
+			if len(containers) != 1 {
+				t.Errorf("job template has %d containers", len(containers))
+			}
+
+			// Updates apply
+			i.Spec.Backup.Schedule = "0 4 * * *"
+			if err = r.reconcileBackupCronJob(i); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cronJob, err = get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schedule, _, _ := unstructured.NestedString(cronJob.Object, "spec", "schedule"); schedule != "0 4 * * *" {
+				t.Errorf("schedule not updated: %q", schedule)
+			}
+
+			// Disabling backups removes the CronJob
+			i.Spec.Backup = nil
+			if err = r.reconcileBackupCronJob(i); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err = get(); !errors.IsNotFound(err) {
+				t.Errorf("CronJob not deleted: %v", err)
+			}
+			if meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionBackupScheduled) != nil {
+				t.Errorf("conditions = %v", i.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestReconcileBackupCronJobDisabled(t *testing.T) {
+	tests := []struct {
+		name      string
+		scheduled bool
+		wantErr   bool
+	}{
+		{"never scheduled", false, false},
+		{"scheduled", true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			if test.scheduled {
+				meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+					Type:   kojedzinv1alpha1.ConditionBackupScheduled,
+					Status: metav1.ConditionTrue,
+					Reason: "CronJobCreated",
+				})
+			}
+			r := newTestReconciler(nil, i)
+
+			// The cluster serves no CronJobs, so any version lookup fails
+			withCronJobVersions(r)
+
+			if err := r.reconcileBackupCronJob(i); (err != nil) != test.wantErr {
+				t.Errorf("error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackupJobSpec(t *testing.T) {
+	i := newBackupThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	env := func(c v1.Container) map[string]v1.EnvVar {
+		vars := make(map[string]v1.EnvVar)
+		for _, e := range c.Env {
+			vars[e.Name] = e
+		}
+
+		return vars
+	}
+
+	// S3 backups are dumped by an init container, and uploaded by mc
+	ps := r.backupJobSpec(i).Template.Spec
+	if len(ps.InitContainers) != 1 || len(ps.Containers) != 1 {
+		t.Fatalf("S3 backup job has %d init containers and %d containers", len(ps.InitContainers), len(ps.Containers))
+	}
+	if ps.Containers[0].Image != backupMCImage {
+		t.Errorf("upload image = %s", ps.Containers[0].Image)
+	}
+	if ps.Volumes[0].EmptyDir == nil {
+		t.Error("S3 backups are not staged in an emptyDir")
+	}
+
+	upload := env(ps.Containers[0])
+	for name, value := range map[string]string{"S3_ENDPOINT": "http://minio:9000", "S3_BUCKET": "backups", "S3_PREFIX": "thermo/", "BACKUP_NAME": "tc", "RETENTION": "7"} {
+		if upload[name].Value != value {
+			t.Errorf("upload %s = %q, want %q", name, upload[name].Value, value)
+		}
+	}
+	if ref := upload["S3_SECRET_KEY"].ValueFrom; ref == nil || ref.SecretKeyRef.Name != "minio" || ref.SecretKeyRef.Key != "secretKey" {
+		t.Errorf("S3_SECRET_KEY not taken from the credentials secret: %+v", ref)
+	}
+	if ref := env(ps.InitContainers[0])["PGPASSWORD"].ValueFrom; ref == nil || ref.SecretKeyRef.Name != thermoCenterSecretName(i) {
+		t.Errorf("PGPASSWORD not taken from the instance secret: %+v", ref)
+	}
+
+	// PVC backups are dumped and pruned by a single container
+	retention := int32(3)
+	i.Spec.Backup.Retention = &retention
+	i.Spec.Backup.Storage = kojedzinv1alpha1.BackupStorage{PersistentVolumeClaim: &v1.LocalObjectReference{Name: "backups"}}
+
+	ps = r.backupJobSpec(i).Template.Spec
+	if len(ps.InitContainers) != 0 || len(ps.Containers) != 1 {
+		t.Fatalf("PVC backup job has %d init containers and %d containers", len(ps.InitContainers), len(ps.Containers))
+	}
+	if claim := ps.Volumes[0].PersistentVolumeClaim; claim == nil || claim.ClaimName != "backups" {
+		t.Errorf("backup volume = %+v", ps.Volumes[0])
+	}
+	if got := env(ps.Containers[0])["RETENTION"].Value; got != "3" {
+		t.Errorf("RETENTION = %q, want 3", got)
+	}
+}
+
+func TestParseBackupResult(t *testing.T) {
+	terminated := func(exitCode int32, message string) v1.Pod {
+		return v1.Pod{Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+			State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: exitCode, Message: message}},
+		}}}}
+	}
+
+	result, err := parseBackupResult([]v1.Pod{
+		terminated(1, `{"file":"failed.dump","size":1}`),
+		terminated(0, `{"file":"tc-20210310030000.dump","size":4096}`),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.File != "tc-20210310030000.dump" || result.Size != 4096 {
+		t.Errorf("result = %+v", result)
+	}
+
+	if _, err = parseBackupResult([]v1.Pod{terminated(0, "not json")}); err == nil {
+		t.Error("expected an error for an invalid message")
+	}
+	if _, err = parseBackupResult([]v1.Pod{terminated(1, "")}); err == nil {
+		t.Error("expected an error without results")
+	}
+}
+
+func TestBackupLocation(t *testing.T) {
+	i := newBackupThermoCenter()
+
+	if location := backupLocation(&i.Spec.Backup.Storage, "tc.dump"); location != "s3://backups/thermo/tc.dump" {
+		t.Errorf("S3 location = %s", location)
+	}
+
+	pvc := &kojedzinv1alpha1.BackupStorage{PersistentVolumeClaim: &v1.LocalObjectReference{Name: "backups"}}
+	if location := backupLocation(pvc, "tc.dump"); location != "pvc://backups/tc.dump" {
+		t.Errorf("PVC location = %s", location)
+	}
+}
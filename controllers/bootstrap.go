@@ -0,0 +1,196 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Creates role and database unless they exist
+const bootstrapScript = `set -e
+psql -v ON_ERROR_STOP=1 -v dbname="${DBNAME}" -v dbuser="${DBUSER}" -v dbpassword="${DBPASSWORD}" <<'SQL'
+SELECT format('CREATE ROLE %I LOGIN PASSWORD %L', :'dbuser', :'dbpassword') WHERE NOT EXISTS (SELECT FROM pg_roles WHERE rolname = :'dbuser')\gexec
+SELECT format('CREATE DATABASE %I OWNER %I', :'dbname', :'dbuser') WHERE NOT EXISTS (SELECT FROM pg_database WHERE datname = :'dbname')\gexec
+SQL
+`
+
+// needsDatabaseBootstrap reports whether the database is still to be created.
+// Databases of already migrated instances are never bootstrapped.
+func needsDatabaseBootstrap(i *kojedzinv1alpha1.ThermoCenter) bool {
+	if i.Spec.Database == nil || i.Spec.Database.Bootstrap == nil {
+		return false
+	}
+
+	return i.Status.DatabaseVersion == "" && !meta.IsStatusConditionTrue(i.Status.Conditions, kojedzinv1alpha1.ConditionDatabaseBootstrapped)
+}
+
+// reconcileDatabaseBootstrap runs the bootstrap Job and records its outcome
+func (r *ThermoCenterReconciler) reconcileDatabaseBootstrap(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (ctrl.Result, error) {
+	job := &batchv1.Job{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterBootstrapJobName(i)}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		if err = r.createBootstrapJob(i, l); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		i.Status.Status = "bootstrapping database"
+
+		return ctrl.Result{}, r.Status().Update(context.TODO(), i)
+	}
+
+	if job.Status.Succeeded > 0 {
+		l.Info("Database bootstrap succeeded")
+
+		meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+			Type:               kojedzinv1alpha1.ConditionDatabaseBootstrapped,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: i.Generation,
+			Reason:             "Created",
+			Message:            fmt.Sprintf("Database %s and role %s are present", i.Spec.Database.Name, i.Spec.Database.User),
+		})
+	} else if job.Status.Failed > 0 {
+		l.Info("Database bootstrap failed, retrying")
+
+		meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+			Type:               kojedzinv1alpha1.ConditionDatabaseBootstrapped,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: i.Generation,
+			Reason:             "JobFailed",
+			Message:            fmt.Sprintf("Bootstrap job %s failed", job.Name),
+		})
+	} else {
+		return ctrl.Result{}, nil
+	}
+
+	if err = r.Status().Update(context.TODO(), i); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Delete job. This will trigger new reconcile cycle.
+	return ctrl.Result{}, r.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+}
+
+func (r *ThermoCenterReconciler) createBootstrapJob(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) error {
+	l.Info("Creating database bootstrap job")
+
+	job := r.adminJob(i, thermoCenterBootstrapJobName(i), "bootstrap", bootstrapScript)
+
+	if err := controllerutil.SetControllerReference(i, job, r.Scheme); err != nil {
+		return err
+	}
+
+	return r.Create(context.TODO(), job)
+}
+
+// adminJob returns a job running a psql script with admin credentials of the database server
+func (r *ThermoCenterReconciler) adminJob(i *kojedzinv1alpha1.ThermoCenter, name string, component string, script string) *batchv1.Job {
+	bootstrap := i.Spec.Database.Bootstrap
+
+	adminDatabase := bootstrap.AdminDatabase
+	if adminDatabase == "" {
+		adminDatabase = "postgres"
+	}
+
+	activeDeadlineSeconds := int64(300)
+	backoffLimit := int32(0)
+	enableServiceLinks := false
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+	runAsUser := int64(70)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      name,
+		},
+		Spec: batchv1.JobSpec{
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			BackoffLimit:          &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labelsForComponent(i, component),
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{
+						Name:    component,
+						Image:   r.rewriteImage(i, postgresqlDefaultImage),
+						Command: []string{"sh", "-c", script},
+						Env: []v1.EnvVar{
+							secretKeyEnv("PGHOST", thermoCenterSecretName(i), sDBHOST),
+							secretKeyEnv("PGPORT", thermoCenterSecretName(i), sDBPORT),
+							{
+								Name:  "PGDATABASE",
+								Value: adminDatabase,
+							},
+							secretKeyEnv("PGUSER", bootstrap.AdminSecretRef.Name, "username"),
+							secretKeyEnv("PGPASSWORD", bootstrap.AdminSecretRef.Name, "password"),
+							secretKeyEnv("DBNAME", thermoCenterSecretName(i), sDBNAME),
+							secretKeyEnv("DBUSER", thermoCenterSecretName(i), sDBUSER),
+							secretKeyEnv("DBPASSWORD", thermoCenterSecretName(i), sDBPASSWORD),
+						},
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								v1.ResourceCPU:    resource.MustParse("1m"),
+								v1.ResourceMemory: resource.MustParse("8Mi"),
+							},
+						},
+						SecurityContext: &v1.SecurityContext{
+							AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+						},
+					}},
+					EnableServiceLinks: &enableServiceLinks,
+					RestartPolicy:      v1.RestartPolicyNever,
+					SecurityContext: &v1.PodSecurityContext{
+						RunAsNonRoot: &runAsNonRoot,
+						RunAsUser:    &runAsUser,
+					},
+				},
+			},
+		},
+	}
+
+	setDatabaseTLS(i, &job.Spec.Template.Spec, &job.Spec.Template.Spec.Containers[0])
+	setImagePullOptions(i, &job.Spec.Template.Spec)
+
+	return job
+}
@@ -0,0 +1,242 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newBootstrapThermoCenter() *kojedzinv1alpha1.ThermoCenter {
+	i := newTestThermoCenter()
+	i.Spec.Database = &kojedzinv1alpha1.Database{
+		Host: "postgres",
+		Name: "thermo",
+		User: "thermo",
+		Bootstrap: &kojedzinv1alpha1.DatabaseBootstrap{
+			AdminSecretRef: v1.LocalObjectReference{Name: "postgres-admin"},
+		},
+	}
+
+	return i
+}
+
+func TestBootstrapScript(t *testing.T) {
+	// Names and the password are passed as psql variables, and quoted by format()
+	for _, want := range []string{
+		"set -e\n",
+		"psql -v ON_ERROR_STOP=1",
+		`-v dbname="${DBNAME}"`,
+		`-v dbuser="${DBUSER}"`,
+		`-v dbpassword="${DBPASSWORD}"`,
+		"<<'SQL'\n",
+		"format('CREATE ROLE %I LOGIN PASSWORD %L', :'dbuser', :'dbpassword')",
+		"WHERE NOT EXISTS (SELECT FROM pg_roles WHERE rolname = :'dbuser')",
+		"format('CREATE DATABASE %I OWNER %I', :'dbname', :'dbuser')",
+		"WHERE NOT EXISTS (SELECT FROM pg_database WHERE datname = :'dbname')",
+	} {
+		if !strings.Contains(bootstrapScript, want) {
+			t.Errorf("bootstrap script lacks %q", want)
+		}
+	}
+
+	// The role must exist before the database owned by it
+	if strings.Index(bootstrapScript, "CREATE ROLE") > strings.Index(bootstrapScript, "CREATE DATABASE") {
+		t.Error("database is created before its owner role")
+	}
+
+	// Values are never interpolated by the shell into SQL
+	sql := bootstrapScript[strings.Index(bootstrapScript, "<<'SQL'"):]
+	if strings.Contains(sql, "${") {
+		t.Error("SQL contains shell expansions")
+	}
+}
+
+func TestNeedsDatabaseBootstrap(t *testing.T) {
+	tests := []struct {
+		name       string
+		modify     func(*kojedzinv1alpha1.ThermoCenter)
+		conditions []metav1.Condition
+		want       bool
+	}{
+		{"new instance", func(*kojedzinv1alpha1.ThermoCenter) {}, nil, true},
+		{"built-in database", func(i *kojedzinv1alpha1.ThermoCenter) { i.Spec.Database = nil }, nil, false},
+		{"no bootstrap", func(i *kojedzinv1alpha1.ThermoCenter) { i.Spec.Database.Bootstrap = nil }, nil, false},
+		{"migrated database", func(i *kojedzinv1alpha1.ThermoCenter) { i.Status.DatabaseVersion = "4.0.0" }, nil, false},
+		{"bootstrapped", func(*kojedzinv1alpha1.ThermoCenter) {}, []metav1.Condition{{Type: kojedzinv1alpha1.ConditionDatabaseBootstrapped, Status: metav1.ConditionTrue}}, false},
+		{"failed bootstrap is retried", func(*kojedzinv1alpha1.ThermoCenter) {}, []metav1.Condition{{Type: kojedzinv1alpha1.ConditionDatabaseBootstrapped, Status: metav1.ConditionFalse}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newBootstrapThermoCenter()
+			test.modify(i)
+			i.Status.Conditions = test.conditions
+
+			if got := needsDatabaseBootstrap(i); got != test.want {
+				t.Errorf("needsDatabaseBootstrap() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBootstrapJob(t *testing.T) {
+	tests := []struct {
+		name          string
+		adminDatabase string
+		tls           bool
+		wantDatabase  string
+	}{
+		{"defaults", "", false, "postgres"},
+		{"admin database", "template1", false, "template1"},
+		{"tls", "", true, "postgres"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newBootstrapThermoCenter()
+			i.Spec.Database.Bootstrap.AdminDatabase = test.adminDatabase
+			if test.tls {
+				i.Spec.Database.SSLMode = "verify-full"
+				i.Spec.Database.CASecretRef = &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "ca"}, Key: "ca.crt"}
+			}
+
+			r := newTestReconciler(nil)
+			job := r.adminJob(i, thermoCenterBootstrapJobName(i), "bootstrap", bootstrapScript)
+
+			if job.Name != "tc-bootstrap" || *job.Spec.BackoffLimit != 0 {
+				t.Errorf("job %s, backoff limit %d", job.Name, *job.Spec.BackoffLimit)
+			}
+
+			c := job.Spec.Template.Spec.Containers[0]
+			if c.Command[len(c.Command)-1] != bootstrapScript {
+				t.Error("job does not run the bootstrap script")
+			}
+
+			env := containerEnv(c)
+			if env["PGDATABASE"].Value != test.wantDatabase {
+				t.Errorf("PGDATABASE = %q, want %q", env["PGDATABASE"].Value, test.wantDatabase)
+			}
+
+			// Admin credentials connect, the instance's parameters are created
+			secretKeys := map[string][2]string{
+				"PGHOST":     {thermoCenterSecretName(i), sDBHOST},
+				"PGPORT":     {thermoCenterSecretName(i), sDBPORT},
+				"PGUSER":     {"postgres-admin", "username"},
+				"PGPASSWORD": {"postgres-admin", "password"},
+				"DBNAME":     {thermoCenterSecretName(i), sDBNAME},
+				"DBUSER":     {thermoCenterSecretName(i), sDBUSER},
+				"DBPASSWORD": {thermoCenterSecretName(i), sDBPASSWORD},
+			}
+			for name, ref := range secretKeys {
+				from := env[name].ValueFrom
+				if from == nil || from.SecretKeyRef == nil || from.SecretKeyRef.Name != ref[0] || from.SecretKeyRef.Key != ref[1] {
+					t.Errorf("%s = %v, want key %s of secret %s", name, from, ref[1], ref[0])
+				}
+			}
+
+			_, hasCA := env["PGSSLROOTCERT"]
+			if hasCA != test.tls || (env["PGSSLMODE"].Value == "verify-full") != test.tls {
+				t.Errorf("tls environment = %v, want tls %v", env, test.tls)
+			}
+		})
+	}
+}
+
+func TestReconcileDatabaseBootstrap(t *testing.T) {
+	i := newBootstrapThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	key := types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterBootstrapJobName(i)}
+
+	if _, err := r.reconcileDatabaseBootstrap(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Get(context.TODO(), key, job); err != nil {
+		t.Fatalf("bootstrap job not created: %v", err)
+	}
+	if i = getThermoCenter(t, r.Client); i.Status.Status != "bootstrapping database" {
+		t.Errorf("status = %q", i.Status.Status)
+	}
+
+	// Failures are recorded, and the job is retried
+	job.Status.Failed = 1
+	if err := r.Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reconcileDatabaseBootstrap(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	i = getThermoCenter(t, r.Client)
+	if cond := meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionDatabaseBootstrapped); cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "JobFailed" {
+		t.Errorf("DatabaseBootstrapped condition = %+v", cond)
+	}
+	if err := r.Get(context.TODO(), key, &batchv1.Job{}); !errors.IsNotFound(err) {
+		t.Fatalf("failed job not deleted: %v", err)
+	}
+	if !needsDatabaseBootstrap(i) {
+		t.Fatal("failed bootstrap not retried")
+	}
+
+	if _, err := r.reconcileDatabaseBootstrap(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	job = &batchv1.Job{}
+	if err := r.Get(context.TODO(), key, job); err != nil {
+		t.Fatalf("bootstrap job not recreated: %v", err)
+	}
+
+	job.Status.Succeeded = 1
+	if err := r.Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	i = getThermoCenter(t, r.Client)
+	if _, err := r.reconcileDatabaseBootstrap(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	i = getThermoCenter(t, r.Client)
+	cond := meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionDatabaseBootstrapped)
+	if cond == nil || cond.Status != metav1.ConditionTrue || !strings.Contains(cond.Message, "thermo") {
+		t.Errorf("DatabaseBootstrapped condition = %+v", cond)
+	}
+	if needsDatabaseBootstrap(i) {
+		t.Error("bootstrapped database still needs bootstrap")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var cacheDefaultMemorySize = resource.MustParse("16Mi")
+
+// cacheType returns the type of cache used by api and grpcserver
+func cacheType(i *kojedzinv1alpha1.ThermoCenter) string {
+	if i.Spec.Cache == nil || i.Spec.Cache.Type == "" {
+		return kojedzinv1alpha1.CacheTypeMemcached
+	}
+
+	return i.Spec.Cache.Type
+}
+
+// cacheMemorySize returns the memory limit of cached items of a built-in cache
+func cacheMemorySize(server *kojedzinv1alpha1.CacheServer) resource.Quantity {
+	if server == nil || server.MemorySize == nil {
+		return cacheDefaultMemorySize
+	}
+
+	return *server.MemorySize
+}
+
+// cacheResources returns resource requirements of a built-in cache, requesting memory for cached items by default
+func cacheResources(server *kojedzinv1alpha1.CacheServer, memorySize resource.Quantity) v1.ResourceRequirements {
+	if server != nil && server.Resources != nil {
+		return *server.Resources.DeepCopy()
+	}
+
+	return v1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("10m"),
+			v1.ResourceMemory: memorySize,
+		},
+	}
+}
+
+// memoryMegabytes converts a quantity to megabytes, as accepted by memcached, at least 1
+func memoryMegabytes(q resource.Quantity) int64 {
+	mb := q.Value() >> 20
+	if mb < 1 {
+		mb = 1
+	}
+
+	return mb
+}
+
+// setCacheEnvironment points api and grpcserver to the selected cache
+func (r *ThermoCenterReconciler) setCacheEnvironment(i *kojedzinv1alpha1.ThermoCenter, env *[]v1.EnvVar) {
+	if cacheType(i) == kojedzinv1alpha1.CacheTypeRedis {
+		r.redis.setEnvironment(r, i, env)
+	} else {
+		r.memcached.setEnvironment(r, i, env)
+	}
+}
@@ -0,0 +1,272 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCacheSelection(t *testing.T) {
+	password := &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "redis"}, Key: "password"}
+
+	tests := []struct {
+		name      string
+		modify    func(*kojedzinv1alpha1.ThermoCenter)
+		memcached bool
+		redis     bool
+		env       map[string]string
+	}{
+		{
+			name:      "built-in memcached by default",
+			modify:    func(*kojedzinv1alpha1.ThermoCenter) {},
+			memcached: true,
+			env:       map[string]string{"MEMCACHED_HOST": "tc-memcached", "MEMCACHED_PORT": "11211"},
+		},
+		{
+			name: "external memcached",
+			modify: func(i *kojedzinv1alpha1.ThermoCenter) {
+				i.Spec.ExternalMemcached = &kojedzinv1alpha1.ExternalMemcached{Hostname: "memcached.example.com"}
+			},
+			env: map[string]string{"MEMCACHED_HOST": "memcached.example.com", "MEMCACHED_PORT": "11211"},
+		},
+		{
+			name: "built-in redis",
+			modify: func(i *kojedzinv1alpha1.ThermoCenter) {
+				i.Spec.Cache = &kojedzinv1alpha1.Cache{Type: kojedzinv1alpha1.CacheTypeRedis}
+			},
+			redis: true,
+			env:   map[string]string{"CACHE_TYPE": "redis", "REDIS_HOST": "tc-redis", "REDIS_PORT": "6379", "REDIS_DB": "0"},
+		},
+		{
+			name: "external redis",
+			modify: func(i *kojedzinv1alpha1.ThermoCenter) {
+				i.Spec.Cache = &kojedzinv1alpha1.Cache{
+					Type:          kojedzinv1alpha1.CacheTypeRedis,
+					ExternalRedis: &kojedzinv1alpha1.ExternalRedis{Hostname: "redis.example.com", Port: 6380, Database: 2, PasswordSecretRef: password},
+				}
+			},
+			env: map[string]string{"CACHE_TYPE": "redis", "REDIS_HOST": "redis.example.com", "REDIS_PORT": "6380", "REDIS_DB": "2"},
+		},
+		{
+			name: "external memcached is unused with redis",
+			modify: func(i *kojedzinv1alpha1.ThermoCenter) {
+				i.Spec.ExternalMemcached = &kojedzinv1alpha1.ExternalMemcached{Hostname: "memcached.example.com"}
+				i.Spec.Cache = &kojedzinv1alpha1.Cache{Type: kojedzinv1alpha1.CacheTypeRedis}
+			},
+			redis: true,
+			env:   map[string]string{"CACHE_TYPE": "redis", "REDIS_HOST": "tc-redis", "REDIS_PORT": "6379", "REDIS_DB": "0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			test.modify(i)
+			r := newTestReconciler(nil, i)
+
+			for _, rec := range []deploymentReconciler{r.memcached, r.redis} {
+				if err := r.reconcile(i, rec); err != nil {
+					t.Fatalf("reconciling %s: %v", rec.component(), err)
+				}
+			}
+
+			exists := func(rec deploymentReconciler) (bool, bool) {
+				key := types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterDeploymentName(i, rec)}
+
+				deployment := r.Get(context.TODO(), key, &appsv1.Deployment{})
+				service := r.Get(context.TODO(), key, &v1.Service{})
+				for _, err := range []error{deployment, service} {
+					if err != nil && !errors.IsNotFound(err) {
+						t.Fatal(err)
+					}
+				}
+
+				return deployment == nil, service == nil
+			}
+
+			if deployment, service := exists(r.memcached); deployment != test.memcached || service != test.memcached {
+				t.Errorf("memcached deployment %v, service %v, want %v", deployment, service, test.memcached)
+			}
+			if deployment, service := exists(r.redis); deployment != test.redis || service != test.redis {
+				t.Errorf("redis deployment %v, service %v, want %v", deployment, service, test.redis)
+			}
+
+			var env []v1.EnvVar
+			r.setCacheEnvironment(i, &env)
+
+			got := containerEnv(v1.Container{Env: env})
+			for name, value := range test.env {
+				if got[name].Value != value {
+					t.Errorf("%s = %q, want %q", name, got[name].Value, value)
+				}
+			}
+
+			_, hasPassword := got["REDIS_PASSWORD"]
+			if wantPassword := i.Spec.Cache != nil && i.Spec.Cache.ExternalRedis != nil; hasPassword != wantPassword {
+				t.Errorf("REDIS_PASSWORD set = %v, want %v", hasPassword, wantPassword)
+			}
+			if _, ok := got["CACHE_TYPE"]; ok != (cacheType(i) == kojedzinv1alpha1.CacheTypeRedis) {
+				t.Errorf("CACHE_TYPE set = %v for %s", ok, cacheType(i))
+			}
+		})
+	}
+}
+
+func TestCacheSwitch(t *testing.T) {
+	i := newTestThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	reconcileCaches := func() {
+		t.Helper()
+
+		for _, rec := range []deploymentReconciler{r.memcached, r.redis} {
+			if err := r.reconcile(i, rec); err != nil {
+				t.Fatalf("reconciling %s: %v", rec.component(), err)
+			}
+		}
+	}
+
+	reconcileCaches()
+
+	i.Spec.Cache = &kojedzinv1alpha1.Cache{Type: kojedzinv1alpha1.CacheTypeRedis}
+	reconcileCaches()
+
+	key := types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterDeploymentName(i, r.memcached)}
+	if err := r.Get(context.TODO(), key, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("unused memcached deployment not removed: %v", err)
+	}
+	if err := r.Get(context.TODO(), key, &v1.Service{}); !errors.IsNotFound(err) {
+		t.Errorf("unused memcached service not removed: %v", err)
+	}
+
+	key.Name = thermoCenterDeploymentName(i, r.redis)
+	if err := r.Get(context.TODO(), key, &appsv1.Deployment{}); err != nil {
+		t.Errorf("redis deployment not created: %v", err)
+	}
+}
+
+func TestMemoryMegabytes(t *testing.T) {
+	tests := []struct {
+		quantity string
+		want     int64
+	}{
+		{"16Mi", 16},
+		{"1Gi", 1024},
+		{"1536Ki", 1},
+		{"2047Ki", 1},
+		{"100M", 95},
+		{"1Ki", 1},
+		{"0", 1},
+	}
+
+	for _, test := range tests {
+		if got := memoryMegabytes(resource.MustParse(test.quantity)); got != test.want {
+			t.Errorf("memoryMegabytes(%s) = %d, want %d", test.quantity, got, test.want)
+		}
+	}
+}
+
+func TestCacheMemory(t *testing.T) {
+	memorySize := resource.MustParse("64Mi")
+	limits := &v1.ResourceRequirements{
+		Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("128Mi")},
+	}
+
+	tests := []struct {
+		name    string
+		server  *kojedzinv1alpha1.CacheServer
+		memory  string
+		request string
+	}{
+		{"defaults", nil, "16Mi", "16Mi"},
+		{"memory size", &kojedzinv1alpha1.CacheServer{MemorySize: &memorySize}, "64Mi", "64Mi"},
+		{"explicit resources", &kojedzinv1alpha1.CacheServer{MemorySize: &memorySize, Resources: limits}, "64Mi", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memory := cacheMemorySize(test.server)
+			if memory.String() != test.memory {
+				t.Errorf("memory size = %s, want %s", memory.String(), test.memory)
+			}
+
+			resources := cacheResources(test.server, memory)
+			request, ok := resources.Requests[v1.ResourceMemory]
+			if test.request == "" {
+				if ok || resources.Limits.Memory().String() != "128Mi" {
+					t.Errorf("resources = %+v, want the explicit ones", resources)
+				}
+
+				return
+			}
+			if request.String() != test.request {
+				t.Errorf("memory request = %s, want %s", request.String(), test.request)
+			}
+		})
+	}
+}
+
+func TestCacheCommands(t *testing.T) {
+	memorySize := resource.MustParse("32Mi")
+
+	i := newTestThermoCenter()
+	i.Spec.Memcached = &kojedzinv1alpha1.CacheServer{MemorySize: &memorySize}
+	i.Spec.Redis = &kojedzinv1alpha1.CacheServer{MemorySize: &memorySize}
+	r := newTestReconciler(nil)
+
+	ps := r.getPodSpec(i, r.memcached)
+	if ps == nil {
+		t.Fatal("memcached not deployed")
+	}
+	if got := ps.Containers[0].Command; len(got) != 3 || got[2] != "32" {
+		t.Errorf("memcached command = %v, want -m 32", got)
+	}
+
+	i.Spec.Cache = &kojedzinv1alpha1.Cache{Type: kojedzinv1alpha1.CacheTypeRedis}
+	ps = r.getPodSpec(i, r.redis)
+	if ps == nil {
+		t.Fatal("redis not deployed")
+	}
+
+	command := ps.Containers[0].Command
+	for idx, arg := range command {
+		if arg == "--maxmemory" {
+			if idx+1 >= len(command) || command[idx+1] != "33554432" {
+				t.Errorf("redis command = %v, want --maxmemory 33554432", command)
+			}
+
+			return
+		}
+	}
+	t.Errorf("redis command = %v lacks --maxmemory", command)
+}
@@ -0,0 +1,246 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// +kubebuilder:rbac:groups=acid.zalan.do,resources=postgresqls,verbs=get
+
+// CloudNativePG defaults for application databases
+const (
+	cnpgDefaultDatabase = "app"
+	cnpgDefaultUser     = "app"
+)
+
+var zalandoPostgresqlGVK = schema.GroupVersionKind{Group: "acid.zalan.do", Version: "v1", Kind: "postgresql"}
+
+// databaseParams returns effective database access parameters
+func (r *ThermoCenterReconciler) databaseParams(i *kojedzinv1alpha1.ThermoCenter) (*kojedzinv1alpha1.Database, error) {
+	if usesBuiltinPostgreSQL(i) {
+		secret := &v1.Secret{}
+		if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterPostgreSQLName(i)}, secret); err != nil {
+			return nil, err
+		}
+
+		return &kojedzinv1alpha1.Database{
+			Host:     thermoCenterPostgreSQLName(i),
+			Port:     kojedzinv1alpha1.DefaultDatabasePort,
+			Name:     string(secret.Data[sPOSTGRESDB]),
+			User:     string(secret.Data[sPOSTGRESUSER]),
+			Password: string(secret.Data[sPOSTGRESPASSWORD]),
+		}, nil
+	}
+
+	db := i.Spec.Database.DeepCopy()
+	if db.Port == 0 {
+		db.Port = kojedzinv1alpha1.DefaultDatabasePort
+	}
+
+	if db.ClusterRef == nil {
+		return db, nil
+	}
+
+	switch db.ClusterRef.Kind {
+	case kojedzinv1alpha1.ClusterKindCloudNativePG:
+		return r.cnpgDatabaseParams(i, db)
+	case kojedzinv1alpha1.ClusterKindZalando:
+		return r.zalandoDatabaseParams(i, db)
+	}
+
+	return nil, fmt.Errorf("unsupported cluster kind: %s", db.ClusterRef.Kind)
+}
+
+// cnpgDatabaseParams reads access parameters of a CloudNativePG application database
+func (r *ThermoCenterReconciler) cnpgDatabaseParams(i *kojedzinv1alpha1.ThermoCenter, db *kojedzinv1alpha1.Database) (*kojedzinv1alpha1.Database, error) {
+	secret := &v1.Secret{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: clusterCredentialsSecretName(i, db)}, secret); err != nil {
+		return nil, err
+	}
+
+	db.Host = db.ClusterRef.Name + "-rw"
+	db.User = string(secret.Data["username"])
+	db.Password = string(secret.Data["password"])
+
+	if name := string(secret.Data["dbname"]); name != "" {
+		db.Name = name
+	} else if db.Name == "" {
+		db.Name = cnpgDefaultDatabase
+	}
+
+	return db, nil
+}
+
+// zalandoDatabaseParams reads access parameters of a Zalando postgresql database.
+// Unless specified, the database and its owner are taken from the cluster when it has exactly one database.
+func (r *ThermoCenterReconciler) zalandoDatabaseParams(i *kojedzinv1alpha1.ThermoCenter, db *kojedzinv1alpha1.Database) (*kojedzinv1alpha1.Database, error) {
+	if db.Name == "" || db.User == "" {
+		cluster := &unstructured.Unstructured{}
+		cluster.SetGroupVersionKind(zalandoPostgresqlGVK)
+		if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: db.ClusterRef.Name}, cluster); err != nil {
+			return nil, err
+		}
+
+		databases, _, err := unstructured.NestedStringMap(cluster.Object, "spec", "databases")
+		if err != nil {
+			return nil, err
+		}
+
+		if db.Name == "" {
+			if len(databases) != 1 {
+				return nil, fmt.Errorf("database name must be specified for cluster %s with %d databases", db.ClusterRef.Name, len(databases))
+			}
+			for name := range databases {
+				db.Name = name
+			}
+		}
+
+		if db.User == "" {
+			db.User = databases[db.Name]
+			if db.User == "" {
+				return nil, fmt.Errorf("database %s not found in cluster %s", db.Name, db.ClusterRef.Name)
+			}
+		}
+	}
+
+	secret := &v1.Secret{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: clusterCredentialsSecretName(i, db)}, secret); err != nil {
+		return nil, err
+	}
+
+	db.Host = db.ClusterRef.Name
+	db.Password = string(secret.Data["password"])
+
+	return db, nil
+}
+
+// Mount path of the database CA bundle
+const databaseCAPath = "/etc/thermo-center/database-ca"
+
+// setDatabaseTLS configures TLS options of database connections made by a container of a pod
+func setDatabaseTLS(i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec, c *v1.Container) {
+	db := i.Spec.Database
+	if db == nil {
+		return
+	}
+
+	if db.SSLMode != "" {
+		c.Env = append(c.Env, v1.EnvVar{
+			Name:  "PGSSLMODE",
+			Value: db.SSLMode,
+		})
+	}
+
+	if db.CASecretRef != nil {
+		ps.Volumes = append(ps.Volumes, v1.Volume{
+			Name: "database-ca",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: db.CASecretRef.Name,
+					Items: []v1.KeyToPath{{
+						Key:  db.CASecretRef.Key,
+						Path: "ca.crt",
+					}},
+				},
+			},
+		})
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+			Name:      "database-ca",
+			MountPath: databaseCAPath,
+			ReadOnly:  true,
+		})
+		c.Env = append(c.Env, v1.EnvVar{
+			Name:  "PGSSLROOTCERT",
+			Value: databaseCAPath + "/ca.crt",
+		})
+	}
+}
+
+// clusterCredentialsSecretName returns the operator generated credentials Secret name
+func clusterCredentialsSecretName(i *kojedzinv1alpha1.ThermoCenter, db *kojedzinv1alpha1.Database) string {
+	if db.ClusterRef.Kind == kojedzinv1alpha1.ClusterKindZalando {
+		return strings.ReplaceAll(db.User, "_", "-") + "." + db.ClusterRef.Name + ".credentials.postgresql.acid.zalan.do"
+	}
+
+	return db.ClusterRef.Name + "-" + cnpgDefaultUser
+}
+
+// referencesSecret reports whether the instance takes configuration from the named Secret
+func referencesSecret(i *kojedzinv1alpha1.ThermoCenter, name string) bool {
+	if i.Spec.ImageRegistry != nil {
+		for _, ref := range i.Spec.ImageRegistry.PullSecrets {
+			if ref.Name == name {
+				return true
+			}
+		}
+	}
+
+	if bridge := mqttBridge(i); bridge != nil && bridge.CredentialsSecretRef != nil && bridge.CredentialsSecretRef.Name == name {
+		return true
+	}
+
+	if i.Spec.Database == nil || i.Spec.Database.ClusterRef == nil {
+		return false
+	}
+
+	ref := i.Spec.Database.ClusterRef
+	if ref.Kind == kojedzinv1alpha1.ClusterKindZalando {
+		return strings.HasSuffix(name, "."+ref.Name+".credentials.postgresql.acid.zalan.do")
+	}
+
+	return name == clusterCredentialsSecretName(i, i.Spec.Database)
+}
+
+// secretToThermoCenters maps Secrets to the instances referencing them
+func (r *ThermoCenterReconciler) secretToThermoCenters(obj client.Object) []reconcile.Request {
+	list := &kojedzinv1alpha1.ThermoCenterList{}
+	if err := r.List(context.TODO(), list, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Log.Error(err, "Listing ThermoCenters failed", "namespace", obj.GetNamespace())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for idx := range list.Items {
+		if referencesSecret(&list.Items[idx], obj.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: list.Items[idx].Namespace,
+				Name:      list.Items[idx].Name,
+			}})
+		}
+	}
+
+	return requests
+}
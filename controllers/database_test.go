@@ -0,0 +1,201 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestSecret(name string, data map[string]string) *v1.Secret {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Data:       make(map[string][]byte),
+	}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+
+	return secret
+}
+
+// newZalandoCluster returns a Zalando postgresql cluster with the given databases and owners
+func newZalandoCluster(name string, databases map[string]interface{}) *unstructured.Unstructured {
+	cluster := &unstructured.Unstructured{}
+	cluster.SetGroupVersionKind(zalandoPostgresqlGVK)
+	cluster.SetNamespace("default")
+	cluster.SetName(name)
+	_ = unstructured.SetNestedMap(cluster.Object, databases, "spec", "databases")
+
+	return cluster
+}
+
+func TestDatabaseParams(t *testing.T) {
+	objects := []client.Object{
+		newTestSecret("tc-postgresql", map[string]string{sPOSTGRESDB: "thermo", sPOSTGRESUSER: "thermo", sPOSTGRESPASSWORD: "builtin"}),
+		newTestSecret("pg-app", map[string]string{"username": "app", "password": "cnpg", "dbname": "thermo"}),
+		newTestSecret("pg-default-app", map[string]string{"username": "app", "password": "cnpg"}),
+		newTestSecret("thermo-owner.acid.credentials.postgresql.acid.zalan.do", map[string]string{"username": "thermo_owner", "password": "zalando"}),
+		newZalandoCluster("acid", map[string]interface{}{"thermo": "thermo_owner"}),
+		newZalandoCluster("multi", map[string]interface{}{"thermo": "thermo_owner", "other": "other_owner"}),
+	}
+
+	tests := []struct {
+		name     string
+		database *kojedzinv1alpha1.Database
+		want     *kojedzinv1alpha1.Database
+		err      bool
+	}{
+		{
+			name: "built-in",
+			want: &kojedzinv1alpha1.Database{Host: "tc-postgresql", Port: kojedzinv1alpha1.DefaultDatabasePort, Name: "thermo", User: "thermo", Password: "builtin"},
+		},
+		{
+			name:     "external",
+			database: &kojedzinv1alpha1.Database{Host: "postgres", Name: "thermo", User: "thermo", Password: "external"},
+			want:     &kojedzinv1alpha1.Database{Host: "postgres", Port: kojedzinv1alpha1.DefaultDatabasePort, Name: "thermo", User: "thermo", Password: "external"},
+		},
+		{
+			name:     "cloudnativepg",
+			database: &kojedzinv1alpha1.Database{ClusterRef: &kojedzinv1alpha1.ClusterRef{Kind: kojedzinv1alpha1.ClusterKindCloudNativePG, Name: "pg"}},
+			want:     &kojedzinv1alpha1.Database{Host: "pg-rw", Port: kojedzinv1alpha1.DefaultDatabasePort, Name: "thermo", User: "app", Password: "cnpg"},
+		},
+		{
+			name:     "cloudnativepg default database",
+			database: &kojedzinv1alpha1.Database{ClusterRef: &kojedzinv1alpha1.ClusterRef{Kind: kojedzinv1alpha1.ClusterKindCloudNativePG, Name: "pg-default"}},
+			want:     &kojedzinv1alpha1.Database{Host: "pg-default-rw", Port: kojedzinv1alpha1.DefaultDatabasePort, Name: cnpgDefaultDatabase, User: "app", Password: "cnpg"},
+		},
+		{
+			name:     "cloudnativepg missing secret",
+			database: &kojedzinv1alpha1.Database{ClusterRef: &kojedzinv1alpha1.ClusterRef{Kind: kojedzinv1alpha1.ClusterKindCloudNativePG, Name: "missing"}},
+			err:      true,
+		},
+		{
+			name:     "zalando single database",
+			database: &kojedzinv1alpha1.Database{ClusterRef: &kojedzinv1alpha1.ClusterRef{Kind: kojedzinv1alpha1.ClusterKindZalando, Name: "acid"}},
+			want:     &kojedzinv1alpha1.Database{Host: "acid", Port: kojedzinv1alpha1.DefaultDatabasePort, Name: "thermo", User: "thermo_owner", Password: "zalando"},
+		},
+		{
+			name:     "zalando ambiguous database",
+			database: &kojedzinv1alpha1.Database{ClusterRef: &kojedzinv1alpha1.ClusterRef{Kind: kojedzinv1alpha1.ClusterKindZalando, Name: "multi"}},
+			err:      true,
+		},
+		{
+			name:     "zalando unknown database",
+			database: &kojedzinv1alpha1.Database{Name: "unknown", ClusterRef: &kojedzinv1alpha1.ClusterRef{Kind: kojedzinv1alpha1.ClusterKindZalando, Name: "acid"}},
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			i.Spec.Database = test.database
+			r := newTestReconciler(nil, append([]client.Object{i}, objects...)...)
+
+			db, err := r.databaseParams(i)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", db)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Only access parameters are compared
+			db.ClusterRef = nil
+			if !reflect.DeepEqual(db, test.want) {
+				t.Errorf("databaseParams() = %+v, want %+v", db, test.want)
+			}
+		})
+	}
+}
+
+func TestSecretToThermoCenters(t *testing.T) {
+	cnpg := newTestThermoCenter()
+	cnpg.Name = "cnpg"
+	cnpg.Spec.Database = &kojedzinv1alpha1.Database{ClusterRef: &kojedzinv1alpha1.ClusterRef{Kind: kojedzinv1alpha1.ClusterKindCloudNativePG, Name: "pg"}}
+
+	zalando := newTestThermoCenter()
+	zalando.Name = "zalando"
+	zalando.Spec.Database = &kojedzinv1alpha1.Database{ClusterRef: &kojedzinv1alpha1.ClusterRef{Kind: kojedzinv1alpha1.ClusterKindZalando, Name: "acid"}}
+	zalando.Spec.ImageRegistry = &kojedzinv1alpha1.ImageRegistry{PullSecrets: []v1.LocalObjectReference{{Name: "pull"}}}
+
+	bridged := newTestThermoCenter()
+	bridged.Name = "bridged"
+	bridged.Spec.MQTT = &kojedzinv1alpha1.MQTT{Bridge: &kojedzinv1alpha1.MQTTBridge{
+		Address:              "mqtt.example.com:8883",
+		CredentialsSecretRef: &v1.LocalObjectReference{Name: "bridge"},
+	}}
+	bridged.Spec.ImageRegistry = &kojedzinv1alpha1.ImageRegistry{PullSecrets: []v1.LocalObjectReference{{Name: "pull"}}}
+
+	other := newTestThermoCenter()
+	other.Namespace = "other"
+	other.Spec.Database = cnpg.Spec.Database.DeepCopy()
+
+	r := newTestReconciler(nil, cnpg, zalando, bridged, other)
+
+	tests := []struct {
+		namespace string
+		secret    string
+		want      []string
+	}{
+		{"default", "pg-app", []string{"cnpg"}},
+		{"default", "thermo-owner.acid.credentials.postgresql.acid.zalan.do", []string{"zalando"}},
+		{"default", "postgres.acid.credentials.postgresql.acid.zalan.do", []string{"zalando"}},
+		{"default", "bridge", []string{"bridged"}},
+		{"default", "pull", []string{"bridged", "zalando"}},
+		{"default", "unrelated", nil},
+		{"other", "pg-app", []string{"tc"}},
+	}
+
+	for _, test := range tests {
+		secret := newTestSecret(test.secret, nil)
+		secret.Namespace = test.namespace
+
+		var got []string
+		for _, req := range r.secretToThermoCenters(secret) {
+			if req.Namespace != test.namespace {
+				t.Errorf("%s/%s: request for %s", test.namespace, test.secret, req.NamespacedName)
+			}
+			got = append(got, req.Name)
+		}
+		sort.Strings(got)
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s/%s maps to %v, want %v", test.namespace, test.secret, got, test.want)
+		}
+	}
+}
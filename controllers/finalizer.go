@@ -0,0 +1,254 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=delete
+
+// Delay before a failed final backup is retried
+const finalBackupRetryDelay = time.Minute
+
+// thermoCenterFinalizer holds deletion of instances until the deletion policy is carried out
+const thermoCenterFinalizer = "kojedz.in/finalizer"
+
+// Drops database and role, disconnecting remaining clients
+const dropScript = `set -e
+psql -v ON_ERROR_STOP=1 -v dbname="${DBNAME}" -v dbuser="${DBUSER}" <<'SQL'
+SELECT format('ALTER DATABASE %I ALLOW_CONNECTIONS false', :'dbname') WHERE EXISTS (SELECT FROM pg_database WHERE datname = :'dbname')\gexec
+SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = :'dbname' AND pid <> pg_backend_pid();
+SELECT format('DROP DATABASE IF EXISTS %I', :'dbname')\gexec
+SELECT format('DROP ROLE IF EXISTS %I', :'dbuser')\gexec
+SQL
+`
+
+func thermoCenterFinalBackupName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return fmt.Sprintf("%s-final-%d", i.Name, i.DeletionTimestamp.Unix())
+}
+
+func thermoCenterDropJobName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-drop"
+}
+
+// needsFinalizer reports whether the deletion policy requires actions before removal
+func needsFinalizer(i *kojedzinv1alpha1.ThermoCenter) bool {
+	return i.Spec.DeletionPolicy == kojedzinv1alpha1.DeletionPolicyBackup || i.Spec.DeletionPolicy == kojedzinv1alpha1.DeletionPolicyDrop
+}
+
+// takesFinalBackup reports whether a final backup is taken on deletion
+func takesFinalBackup(i *kojedzinv1alpha1.ThermoCenter) bool {
+	return i.Spec.Backup != nil && needsFinalizer(i)
+}
+
+// reconcileFinalizer adds or removes the finalizer according to the deletion policy
+func (r *ThermoCenterReconciler) reconcileFinalizer(i *kojedzinv1alpha1.ThermoCenter) error {
+	if needsFinalizer(i) == controllerutil.ContainsFinalizer(i, thermoCenterFinalizer) {
+		return nil
+	}
+
+	if needsFinalizer(i) {
+		controllerutil.AddFinalizer(i, thermoCenterFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(i, thermoCenterFinalizer)
+	}
+
+	return r.Update(context.TODO(), i)
+}
+
+// finalize carries out the deletion policy of a deleted instance, then releases it
+func (r *ThermoCenterReconciler) finalize(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(i, thermoCenterFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if takesFinalBackup(i) {
+		done, err := r.finalBackup(i, l)
+		if err != nil || !done {
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, err
+		}
+	}
+
+	if i.Spec.DeletionPolicy == kojedzinv1alpha1.DeletionPolicyDrop {
+		done, err := r.dropDatabase(i, l)
+		if err != nil || !done {
+			return ctrl.Result{}, err
+		}
+	}
+
+	l.Info("Deletion policy carried out, removing finalizer")
+
+	controllerutil.RemoveFinalizer(i, thermoCenterFinalizer)
+
+	return ctrl.Result{}, r.Update(context.TODO(), i)
+}
+
+// finalBackup takes a last backup of the database, and reports whether it has succeeded.
+// The backup record is not owned by the instance, thus survives it. Failed
+// backups are deleted after a delay, so that they are taken again.
+func (r *ThermoCenterReconciler) finalBackup(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (bool, error) {
+	name := thermoCenterFinalBackupName(i)
+
+	backup := &kojedzinv1alpha1.ThermoCenterBackup{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: name}, backup)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+
+		l.Info("Creating final backup", "backup", name)
+
+		backup = &kojedzinv1alpha1.ThermoCenterBackup{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: i.Namespace,
+				Name:      name,
+				Labels: map[string]string{
+					ThermoCenterInstanceLabel: i.Name,
+				},
+			},
+			Spec: kojedzinv1alpha1.ThermoCenterBackupSpec{
+				ThermoCenter: i.Name,
+			},
+		}
+
+		if err = r.Create(context.TODO(), backup); err != nil {
+			return false, err
+		}
+
+		return false, r.setDeletionStatus(i, kojedzinv1alpha1.DeletionBackingUp, "")
+	}
+
+	switch backup.Status.Phase {
+	case kojedzinv1alpha1.BackupSucceeded:
+		return true, nil
+	case kojedzinv1alpha1.BackupFailed:
+		if err = r.setDeletionStatus(i, kojedzinv1alpha1.DeletionFailed, fmt.Sprintf("Final backup %s failed, retrying: %s", name, backup.Status.Message)); err != nil {
+			return false, err
+		}
+
+		// Wait for the previous attempt to be gone, along with its job of the same name
+		if backup.DeletionTimestamp != nil || (backup.Status.CompletionTime != nil && time.Since(backup.Status.CompletionTime.Time) < finalBackupRetryDelay) {
+			return false, nil
+		}
+
+		l.Info("Final backup failed, retrying", "backup", name)
+
+		return false, client.IgnoreNotFound(r.Delete(context.TODO(), backup, client.PropagationPolicy(metav1.DeletePropagationForeground)))
+	}
+
+	return false, nil
+}
+
+// dropDatabase removes the database, and reports whether it has been dropped.
+// The volume of the built-in database is deleted, while external databases
+// are dropped with the bootstrap admin credentials.
+func (r *ThermoCenterReconciler) dropDatabase(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (bool, error) {
+	if usesBuiltinPostgreSQL(i) {
+		l.Info("Deleting built-in database volume")
+
+		pvc := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: i.Namespace,
+				Name:      "data-" + thermoCenterPostgreSQLName(i) + "-0",
+			},
+		}
+
+		return true, client.IgnoreNotFound(r.Delete(context.TODO(), pvc))
+	}
+
+	if i.Spec.Database.Bootstrap == nil {
+		return false, r.setDeletionStatus(i, kojedzinv1alpha1.DeletionFailed, "Dropping the database requires bootstrap admin credentials")
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterDropJobName(i)}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+
+		l.Info("Creating database drop job")
+
+		job = r.adminJob(i, thermoCenterDropJobName(i), "drop", dropScript)
+		if err = controllerutil.SetControllerReference(i, job, r.Scheme); err != nil {
+			return false, err
+		}
+
+		if err = r.Create(context.TODO(), job); err != nil {
+			return false, err
+		}
+
+		return false, r.setDeletionStatus(i, kojedzinv1alpha1.DeletionDropping, "")
+	}
+
+	if job.Status.Succeeded > 0 {
+		l.Info("Database dropped")
+
+		return true, nil
+	} else if job.Status.Failed > 0 {
+		l.Info("Dropping database failed, retrying")
+
+		if err = r.setDeletionStatus(i, kojedzinv1alpha1.DeletionFailed, fmt.Sprintf("Drop job %s failed", job.Name)); err != nil {
+			return false, err
+		}
+
+		// Delete job. This will trigger new reconcile cycle.
+		return false, r.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	}
+
+	return false, nil
+}
+
+// setDeletionStatus records progress of the deletion policy
+func (r *ThermoCenterReconciler) setDeletionStatus(i *kojedzinv1alpha1.ThermoCenter, phase string, message string) error {
+	if i.Status.Deletion != nil && i.Status.Deletion.Phase == phase && i.Status.Deletion.Message == message {
+		return nil
+	}
+
+	i.Status.Status = "deleting"
+	i.Status.Deletion = &kojedzinv1alpha1.DeletionStatus{
+		Phase:   phase,
+		Message: message,
+	}
+	if takesFinalBackup(i) {
+		i.Status.Deletion.Backup = thermoCenterFinalBackupName(i)
+	}
+
+	return r.Status().Update(context.TODO(), i)
+}
@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// newDeletedThermoCenter returns a deleted instance held by the finalizer
+func newDeletedThermoCenter(policy string) *kojedzinv1alpha1.ThermoCenter {
+	i := newTestThermoCenter()
+	i.Spec.DeletionPolicy = policy
+	i.Spec.Backup = &kojedzinv1alpha1.Backup{}
+	i.Finalizers = []string{thermoCenterFinalizer}
+
+	now := metav1.Now()
+	i.DeletionTimestamp = &now
+
+	return i
+}
+
+func TestFinalizeRetriesFailedBackup(t *testing.T) {
+	i := newDeletedThermoCenter(kojedzinv1alpha1.DeletionPolicyBackup)
+	r := newTestReconciler(nil, i)
+
+	key := types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterFinalBackupName(i)}
+	backup := &kojedzinv1alpha1.ThermoCenterBackup{}
+
+	finalize := func() {
+		t.Helper()
+
+		result, err := r.finalize(i, r.Log)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.RequeueAfter == 0 {
+			t.Error("finalize did not requeue while backing up")
+		}
+	}
+
+	setPhase := func(phase string, completed time.Time) {
+		t.Helper()
+
+		if err := r.Get(context.TODO(), key, backup); err != nil {
+			t.Fatal(err)
+		}
+
+		backup.Status.Phase = phase
+		backup.Status.CompletionTime = &metav1.Time{Time: completed}
+		if err := r.Status().Update(context.TODO(), backup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The final backup is requested
+	finalize()
+	if err := r.Get(context.TODO(), key, backup); err != nil {
+		t.Fatalf("final backup not created: %v", err)
+	}
+	if i.Status.Deletion == nil || i.Status.Deletion.Phase != kojedzinv1alpha1.DeletionBackingUp {
+		t.Errorf("deletion status = %+v, want %s", i.Status.Deletion, kojedzinv1alpha1.DeletionBackingUp)
+	}
+
+	// A recent failure is reported, and kept for a while
+	setPhase(kojedzinv1alpha1.BackupFailed, time.Now())
+	finalize()
+	if i.Status.Deletion.Phase != kojedzinv1alpha1.DeletionFailed {
+		t.Errorf("deletion phase = %s, want %s", i.Status.Deletion.Phase, kojedzinv1alpha1.DeletionFailed)
+	}
+	if err := r.Get(context.TODO(), key, backup); err != nil {
+		t.Errorf("failed backup deleted before the retry delay: %v", err)
+	}
+
+	// Then it is deleted, and taken again
+	setPhase(kojedzinv1alpha1.BackupFailed, time.Now().Add(-2*finalBackupRetryDelay))
+	finalize()
+	if err := r.Get(context.TODO(), key, backup); !errors.IsNotFound(err) {
+		t.Errorf("failed backup not deleted: %v", err)
+	}
+
+	finalize()
+	backup = &kojedzinv1alpha1.ThermoCenterBackup{}
+	if err := r.Get(context.TODO(), key, backup); err != nil {
+		t.Fatalf("final backup not recreated: %v", err)
+	}
+	if backup.Status.Phase != "" {
+		t.Errorf("recreated backup has phase %s", backup.Status.Phase)
+	}
+
+	// Success releases the instance
+	setPhase(kojedzinv1alpha1.BackupSucceeded, time.Now())
+	if _, err := r.finalize(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if controllerutil.ContainsFinalizer(i, thermoCenterFinalizer) {
+		t.Error("finalizer not removed after a successful backup")
+	}
+}
+
+func TestFinalizeRetain(t *testing.T) {
+	i := newDeletedThermoCenter(kojedzinv1alpha1.DeletionPolicyBackup)
+	i.Spec.DeletionPolicy = kojedzinv1alpha1.DeletionPolicyRetain
+	r := newTestReconciler(nil, i)
+
+	if _, err := r.finalize(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if controllerutil.ContainsFinalizer(i, thermoCenterFinalizer) {
+		t.Error("finalizer not removed with Retain policy")
+	}
+
+	list := &kojedzinv1alpha1.ThermoCenterBackupList{}
+	if err := r.List(context.TODO(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Errorf("backups taken with Retain policy: %d", len(list.Items))
+	}
+}
@@ -59,6 +59,8 @@ func (grpc *grpcReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojed
 		},
 	)
 
+	r.pooler.setDatabaseEnvironment(r, i, ps)
+
 	if i.Spec.Graphite.Hostname != "" {
 		ps.Containers[0].Env = append(ps.Containers[0].Env,
 			v1.EnvVar{
@@ -86,8 +88,8 @@ func (grpc *grpcReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojed
 		)
 	}
 
-	r.memcached.setEnvironment(r, i, &ps.Containers[0].Env)
-	r.mqtt.setEnvironment(r, i, &ps.Containers[0].Env)
+	r.setCacheEnvironment(i, &ps.Containers[0].Env)
+	r.mqtt.setEnvironment(r, i, ps)
 
 	return ps
 }
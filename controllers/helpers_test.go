@@ -0,0 +1,148 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/go-logr/logr"
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"github.com/rkojedzinszky/thermo-center-controller/registry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeRegistry serves digests and tags from memory
+type fakeRegistry struct {
+	// digests by image reference
+	digests map[string]string
+
+	// tags by image name
+	tags map[string][]string
+
+	// err is returned from all calls when set
+	err error
+
+	// keychains received
+	keychains []registry.Keychain
+}
+
+func (f *fakeRegistry) Digest(ctx context.Context, image string, keychain registry.Keychain) (string, error) {
+	f.keychains = append(f.keychains, keychain)
+
+	if f.err != nil {
+		return "", f.err
+	}
+
+	digest, ok := f.digests[image]
+	if !ok {
+		return "", fmt.Errorf("%s not found", image)
+	}
+
+	return digest, nil
+}
+
+func (f *fakeRegistry) Tags(ctx context.Context, image string, keychain registry.Keychain) ([]string, error) {
+	f.keychains = append(f.keychains, keychain)
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	tags, ok := f.tags[image]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", image)
+	}
+
+	return tags, nil
+}
+
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = kojedzinv1alpha1.AddToScheme(scheme)
+
+	return scheme
+}
+
+// newTestReconciler returns a reconciler working on a fake client holding objs
+func newTestReconciler(reg registry.Client, objs ...client.Object) *ThermoCenterReconciler {
+	scheme := newTestScheme()
+
+	return &ThermoCenterReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Log:    logr.Discard(),
+		Scheme: scheme,
+
+		registry: reg,
+
+		rand:     rand.New(rand.NewSource(1)),
+		randLock: &sync.Mutex{},
+
+		mqtt:      &mqttReconciler{},
+		memcached: &memcachedReconciler{},
+		redis:     &redisReconciler{},
+		ui:        &uiReconciler{},
+		grpc:      &grpcReconciler{},
+		receiver:  &receiverReconciler{},
+		api:       &apiReconciler{},
+		ws:        &wsReconciler{},
+
+		pooler:        &poolerReconciler{},
+		maintenance:   &maintenanceReconciler{},
+		homeAssistant: &homeAssistantReconciler{},
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+// newTestThermoCenter returns an instance with a built-in database
+func newTestThermoCenter() *kojedzinv1alpha1.ThermoCenter {
+	return &kojedzinv1alpha1.ThermoCenter{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "tc",
+		},
+		Spec: kojedzinv1alpha1.ThermoCenterSpec{
+			Ingress: kojedzinv1alpha1.Ingress{
+				HostNames: []string{"thermo.example.com"},
+			},
+			Version:  stringPtr("4.1.0"),
+			Replicas: 1,
+		},
+	}
+}
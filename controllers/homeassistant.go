@@ -0,0 +1,104 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"strconv"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var homeAssistantDeployment = &kojedzinv1alpha1.Deployment{
+	Replicas: replicas(1),
+}
+
+// homeAssistantReconciler runs the Home Assistant MQTT discovery publisher. It
+// reads sensors from the api, and publishes their discovery configurations
+// to the broker used by the instance.
+type homeAssistantReconciler struct {
+	defaultDeploymentReconciler
+}
+
+func (ha *homeAssistantReconciler) component() string {
+	return "homeassistant"
+}
+
+func (ha *homeAssistantReconciler) getDeployment(i *kojedzinv1alpha1.ThermoCenter) *kojedzinv1alpha1.Deployment {
+	if i.Spec.HomeAssistant == nil {
+		return nil
+	}
+
+	return deploymentWithDefaults(&i.Spec.HomeAssistant.Deployment, homeAssistantDeployment)
+}
+
+func (ha *homeAssistantReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) *v1.PodSpec {
+	if i.Spec.HomeAssistant == nil {
+		return nil
+	}
+
+	// Resource requirements
+	ps.Containers[0].Resources = v1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("1m"),
+			v1.ResourceMemory: resource.MustParse("16Mi"),
+		},
+	}
+
+	discoveryPrefix := i.Spec.HomeAssistant.DiscoveryPrefix
+	if discoveryPrefix == "" {
+		discoveryPrefix = kojedzinv1alpha1.DefaultDiscoveryPrefix
+	}
+
+	interval := int32(kojedzinv1alpha1.DefaultDiscoveryInterval)
+	if i.Spec.HomeAssistant.Interval != nil {
+		interval = *i.Spec.HomeAssistant.Interval
+	}
+
+	ps.Containers[0].Env = append(ps.Containers[0].Env,
+		v1.EnvVar{
+			Name:  "THERMO_CENTER_API_HOST",
+			Value: thermoCenterServiceName(i, r.api),
+		},
+		v1.EnvVar{
+			Name:  "HA_DISCOVERY_PREFIX",
+			Value: discoveryPrefix,
+		},
+		v1.EnvVar{
+			Name:  "HA_DISCOVERY_INTERVAL",
+			Value: strconv.Itoa(int(interval)),
+		},
+	)
+
+	r.mqtt.setEnvironment(r, i, ps)
+
+	return ps
+}
+
+// customizeService omits the Service, as the publisher serves no clients
+func (ha *homeAssistantReconciler) customizeService(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, service *v1.Service) *v1.Service {
+	return nil
+}
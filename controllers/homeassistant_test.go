@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// containerEnv returns environment variables of a container by name
+func containerEnv(c v1.Container) map[string]v1.EnvVar {
+	env := make(map[string]v1.EnvVar)
+	for _, e := range c.Env {
+		env[e.Name] = e
+	}
+
+	return env
+}
+
+func TestHomeAssistantPodSpec(t *testing.T) {
+	i := newTestThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	if ps := r.getPodSpec(i, r.homeAssistant); ps != nil {
+		t.Fatal("publisher enabled without homeAssistant")
+	}
+
+	// Built-in broker
+	interval := int32(60)
+	i.Spec.HomeAssistant = &kojedzinv1alpha1.HomeAssistant{Interval: &interval}
+
+	ps := r.getPodSpec(i, r.homeAssistant)
+	if ps == nil {
+		t.Fatal("publisher disabled with homeAssistant")
+	}
+
+	env := containerEnv(ps.Containers[0])
+	for name, value := range map[string]string{
+		"THERMO_CENTER_API_HOST": "tc-api",
+		"HA_DISCOVERY_PREFIX":    "homeassistant",
+		"HA_DISCOVERY_INTERVAL":  "60",
+		"MQTT_HOST":              "tc-mqtt",
+		"MQTT_PORT":              "1883",
+	} {
+		if env[name].Value != value {
+			t.Errorf("%s = %q, want %q", name, env[name].Value, value)
+		}
+	}
+	if ref := env["MQTT_PASSWORD"].ValueFrom; ref == nil || ref.SecretKeyRef.Name != thermoCenterMQTTName(i) {
+		t.Errorf("MQTT_PASSWORD not taken from the broker secret: %+v", ref)
+	}
+	if image := ps.Containers[0].Image; image != "ghcr.io/rkojedzinszky/thermo-center-homeassistant:4.1.0" {
+		t.Errorf("image = %s", image)
+	}
+
+	// External broker with TLS
+	i.Spec.HomeAssistant.DiscoveryPrefix = "ha"
+	i.Spec.ExternalMQTT = &kojedzinv1alpha1.ExternalMQTT{
+		Hostname:          "mqtt.example.com",
+		Port:              kojedzinv1alpha1.DefaultMQTTTLSPort,
+		PasswordSecretRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "mqtt-credentials"}, Key: "password"},
+		TLS: &kojedzinv1alpha1.MQTTTLS{
+			CASecretRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "mqtt-ca"}, Key: "ca.crt"},
+		},
+	}
+
+	ps = r.getPodSpec(i, r.homeAssistant)
+	env = containerEnv(ps.Containers[0])
+	for name, value := range map[string]string{
+		"HA_DISCOVERY_PREFIX": "ha",
+		"MQTT_HOST":           "mqtt.example.com",
+		"MQTT_PORT":           "8883",
+		"MQTT_TLS":            "1",
+	} {
+		if env[name].Value != value {
+			t.Errorf("%s = %q, want %q", name, env[name].Value, value)
+		}
+	}
+	if env["MQTT_TLS_CA"].Value == "" || len(ps.Volumes) == 0 {
+		t.Error("broker CA not mounted")
+	}
+	if ref := env["MQTT_PASSWORD"].ValueFrom; ref == nil || ref.SecretKeyRef.Name != "mqtt-credentials" {
+		t.Errorf("MQTT_PASSWORD not taken from the given secret: %+v", ref)
+	}
+	if _, ok := env["MQTT_USERNAME"]; ok {
+		t.Error("MQTT_USERNAME set without usernameSecretRef")
+	}
+}
+
+func TestReconcileHomeAssistant(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Spec.HomeAssistant = &kojedzinv1alpha1.HomeAssistant{}
+	r := newTestReconciler(nil, i)
+
+	key := types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterDeploymentName(i, r.homeAssistant)}
+
+	if err := r.reconcile(i, r.homeAssistant); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(context.TODO(), key, deployment); err != nil {
+		t.Fatalf("deployment not created: %v", err)
+	}
+	if *deployment.Spec.Replicas != 1 {
+		t.Errorf("replicas = %d, want 1", *deployment.Spec.Replicas)
+	}
+
+	// The publisher serves no clients
+	if err := r.Get(context.TODO(), key, &v1.Service{}); !errors.IsNotFound(err) {
+		t.Errorf("service created: %v", err)
+	}
+
+	// Disabling removes the deployment
+	i.Spec.HomeAssistant = nil
+	if err := r.reconcile(i, r.homeAssistant); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Get(context.TODO(), key, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("deployment not deleted: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"github.com/rkojedzinszky/thermo-center-controller/registry"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// deploymentReconcilers lists all components
+func (r *ThermoCenterReconciler) deploymentReconcilers() []deploymentReconciler {
+	return []deploymentReconciler{r.mqtt, r.memcached, r.redis, r.pooler, r.ui, r.grpc, r.receiver, r.api, r.ws, r.maintenance, r.homeAssistant}
+}
+
+// registryKeychain collects registry credentials from the pull secrets of an instance
+func (r *ThermoCenterReconciler) registryKeychain(i *kojedzinv1alpha1.ThermoCenter) (registry.Keychain, error) {
+	keychain := registry.Keychain{}
+	if i.Spec.ImageRegistry == nil {
+		return keychain, nil
+	}
+
+	for _, ref := range i.Spec.ImageRegistry.PullSecrets {
+		secret := &v1.Secret{}
+		if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: ref.Name}, secret); err != nil {
+			return nil, err
+		}
+
+		for _, key := range []string{v1.DockerConfigJsonKey, v1.DockerConfigKey} {
+			data, ok := secret.Data[key]
+			if !ok {
+				continue
+			}
+
+			if err := keychain.ParseDockerConfig(data); err != nil {
+				return nil, fmt.Errorf("invalid pull secret %s: %w", ref.Name, err)
+			}
+		}
+	}
+
+	return keychain, nil
+}
+
+// pinKey identifies what pinned digests were resolved for. When tracking
+// latest images, this is the api image digest recorded as database version.
+func pinKey(i *kojedzinv1alpha1.ThermoCenter) string {
+	if version := imageVersion(i); version != "" {
+		return version
+	}
+
+	return i.Status.DatabaseVersion
+}
+
+// pinImage replaces the tag of an image with the digest pinned for the component
+func pinImage(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler, image string) string {
+	if !i.Spec.PinDigests || i.Status.PinnedVersion != pinKey(i) || strings.IndexByte(image, '@') != -1 {
+		return image
+	}
+
+	digest, ok := i.Status.PinnedDigests[rec.component()]
+	if !ok {
+		return image
+	}
+
+	return registry.Name(image) + "@" + digest
+}
+
+// Interval of retries when image digests could not be resolved
+const pinRetryInterval = 5 * time.Minute
+
+// reconcilePinnedDigests resolves component image digests once per version.
+// Registry failures do not block reconciling: the last pinned digests are
+// kept in status, images of the new version are deployed by tag meanwhile,
+// and the DigestsPinned condition reports the failure until a retry succeeds.
+func (r *ThermoCenterReconciler) reconcilePinnedDigests(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) error {
+	if !i.Spec.PinDigests {
+		if i.Status.PinnedDigests == nil && meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionDigestsPinned) == nil {
+			return nil
+		}
+
+		i.Status.PinnedVersion = ""
+		i.Status.PinnedDigests = nil
+		meta.RemoveStatusCondition(&i.Status.Conditions, kojedzinv1alpha1.ConditionDigestsPinned)
+
+		return r.Status().Update(context.TODO(), i)
+	}
+
+	key := pinKey(i)
+	if i.Status.PinnedVersion == key {
+		return nil
+	}
+
+	digests, err := r.resolveDigests(i, l)
+	if err != nil {
+		l.Error(err, "Unable to resolve image digests, keeping last pinned digests")
+
+		meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+			Type:               kojedzinv1alpha1.ConditionDigestsPinned,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: i.Generation,
+			Reason:             "ResolveFailed",
+			Message:            err.Error(),
+		})
+
+		return r.Status().Update(context.TODO(), i)
+	}
+
+	i.Status.PinnedVersion = key
+	i.Status.PinnedDigests = digests
+
+	meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+		Type:               kojedzinv1alpha1.ConditionDigestsPinned,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: i.Generation,
+		Reason:             "Resolved",
+		Message:            fmt.Sprintf("Image digests of %s are pinned", key),
+	})
+
+	return r.Status().Update(context.TODO(), i)
+}
+
+// resolveDigests resolves the image digests of all enabled components
+func (r *ThermoCenterReconciler) resolveDigests(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (map[string]string, error) {
+	keychain, err := r.registryKeychain(i)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string)
+
+	for _, rec := range r.deploymentReconcilers() {
+		// Skip disabled components
+		if r.getPodSpec(i, rec) == nil {
+			continue
+		}
+
+		image := r.componentImage(i, rec)
+		if strings.IndexByte(image, '@') != -1 {
+			continue
+		}
+
+		digest, err := r.registry.Digest(context.TODO(), image, keychain)
+		if err != nil {
+			return nil, err
+		}
+
+		l.Info("Pinning image", "component", rec.component(), "image", image, "digest", digest)
+
+		digests[rec.component()] = digest
+	}
+
+	return digests, nil
+}
+
+// requeueForPinning schedules a retry of resolving image digests after a failure
+func requeueForPinning(i *kojedzinv1alpha1.ThermoCenter, result ctrl.Result) ctrl.Result {
+	if !i.Spec.PinDigests || !meta.IsStatusConditionFalse(i.Status.Conditions, kojedzinv1alpha1.ConditionDigestsPinned) || result.Requeue {
+		return result
+	}
+
+	if result.RequeueAfter == 0 || result.RequeueAfter > pinRetryInterval {
+		result.RequeueAfter = pinRetryInterval
+	}
+
+	return result
+}
+
+// reconcileComponentStatus records effective replicas, configured and running images of components in status
+func (r *ThermoCenterReconciler) reconcileComponentStatus(i *kojedzinv1alpha1.ThermoCenter) error {
+	pods := &v1.PodList{}
+	if err := r.List(context.TODO(), pods, client.InNamespace(i.Namespace), client.MatchingLabels{ThermoCenterInstanceLabel: i.Name}); err != nil {
+		return err
+	}
+
+	var components []kojedzinv1alpha1.ComponentStatus
+
+	for _, rec := range r.deploymentReconcilers() {
+		ps := r.getPodSpec(i, rec)
+		if ps == nil {
+			continue
+		}
+
+		component := kojedzinv1alpha1.ComponentStatus{
+			Name:     rec.component(),
+			Image:    ps.Containers[0].Image,
+			Replicas: componentReplicas(i, rec),
+		}
+
+		if r.maintenanceScalesDown(i, rec) || r.restoreScalesDown(i, rec) {
+			component.Replicas = 0
+		}
+
+		seen := make(map[string]bool)
+		for _, pod := range pods.Items {
+			if pod.Labels["thermo-center-component"] != rec.component() {
+				continue
+			}
+
+			for _, cs := range pod.Status.ContainerStatuses {
+				at := strings.LastIndexByte(cs.ImageID, '@')
+				if cs.Name != rec.component() || at == -1 || seen[cs.ImageID[at+1:]] {
+					continue
+				}
+
+				seen[cs.ImageID[at+1:]] = true
+				component.Digests = append(component.Digests, cs.ImageID[at+1:])
+			}
+		}
+		sort.Strings(component.Digests)
+
+		components = append(components, component)
+	}
+
+	if equality.Semantic.DeepEqual(components, i.Status.Components) {
+		return nil
+	}
+
+	i.Status.Components = components
+
+	return r.Status().Update(context.TODO(), i)
+}
@@ -0,0 +1,130 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"github.com/rkojedzinszky/thermo-center-controller/registry"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestReconcilePinnedDigests(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Spec.PinDigests = true
+	i.Spec.ImageRegistry = &kojedzinv1alpha1.ImageRegistry{
+		PullSecrets: []v1.LocalObjectReference{{Name: "pull"}},
+	}
+	i.Status.PinnedVersion = "4.0.0"
+	i.Status.PinnedDigests = map[string]string{"api": "sha256:old"}
+
+	pull := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pull"},
+		Type:       v1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			v1.DockerConfigJsonKey: []byte(`{"auths":{"ghcr.io":{"username":"user","password":"secret"}}}`),
+		},
+	}
+
+	reg := &fakeRegistry{err: errors.New("registry unreachable"), digests: map[string]string{}}
+	r := newTestReconciler(reg, i, pull)
+
+	// Registry failures are reported in a condition, the last pins are kept
+	if err := r.reconcilePinnedDigests(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !meta.IsStatusConditionFalse(i.Status.Conditions, kojedzinv1alpha1.ConditionDigestsPinned) {
+		t.Errorf("condition %s is not false: %v", kojedzinv1alpha1.ConditionDigestsPinned, i.Status.Conditions)
+	}
+	if i.Status.PinnedVersion != "4.0.0" || i.Status.PinnedDigests["api"] != "sha256:old" {
+		t.Errorf("last pins not kept: %s %v", i.Status.PinnedVersion, i.Status.PinnedDigests)
+	}
+	if result := requeueForPinning(i, ctrl.Result{RequeueAfter: time.Hour}); result.RequeueAfter != pinRetryInterval {
+		t.Errorf("requeue after %v, want %v", result.RequeueAfter, pinRetryInterval)
+	}
+
+	creds := registry.Credentials{Username: "user", Password: "secret"}
+	if len(reg.keychains) == 0 || reg.keychains[0]["ghcr.io"] != creds {
+		t.Errorf("pull secret credentials not passed to registry: %v", reg.keychains)
+	}
+
+	// Images of the new version are deployed by tag meanwhile
+	api := r.componentImage(i, r.api)
+	if image := pinImage(i, r.api, api); image != api {
+		t.Errorf("image = %s, want %s", image, api)
+	}
+
+	// A retry succeeds once the registry is reachable
+	reg.err = nil
+	for _, rec := range r.deploymentReconcilers() {
+		if r.getPodSpec(i, rec) != nil {
+			reg.digests[r.componentImage(i, rec)] = "sha256:" + rec.component()
+		}
+	}
+
+	if err := r.reconcilePinnedDigests(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !meta.IsStatusConditionTrue(i.Status.Conditions, kojedzinv1alpha1.ConditionDigestsPinned) {
+		t.Errorf("condition %s is not true: %v", kojedzinv1alpha1.ConditionDigestsPinned, i.Status.Conditions)
+	}
+	if i.Status.PinnedVersion != "4.1.0" || len(i.Status.PinnedDigests) != len(reg.digests) {
+		t.Errorf("digests not pinned: %s %v", i.Status.PinnedVersion, i.Status.PinnedDigests)
+	}
+	if image := pinImage(i, r.api, api); image != registry.Name(api)+"@sha256:api" {
+		t.Errorf("image = %s, want pinned", image)
+	}
+	if result := requeueForPinning(i, ctrl.Result{}); !result.IsZero() {
+		t.Errorf("unexpected requeue: %v", result)
+	}
+
+	// Status is persisted
+	stored := &kojedzinv1alpha1.ThermoCenter{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "tc"}, stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.PinnedVersion != "4.1.0" {
+		t.Errorf("stored pinned version = %s", stored.Status.PinnedVersion)
+	}
+
+	// Disabling pinning clears status
+	i.Spec.PinDigests = false
+	if err := r.reconcilePinnedDigests(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if i.Status.PinnedDigests != nil || meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionDigestsPinned) != nil {
+		t.Errorf("pinning status not cleared: %v %v", i.Status.PinnedDigests, i.Status.Conditions)
+	}
+}
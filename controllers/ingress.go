@@ -104,6 +104,17 @@ func (r *ThermoCenterReconciler) reconcileIngress(i *kojedzinv1alpha1.ThermoCent
 		}
 	}
 
+	apiServiceName := thermoCenterServiceName(i, r.api)
+	wsServiceName := thermoCenterServiceName(i, r.ws)
+	uiServiceName := thermoCenterServiceName(i, r.ui)
+
+	// Route everything to the maintenance page
+	if maintenancePageActive(i) {
+		apiServiceName = thermoCenterServiceName(i, r.maintenance)
+		wsServiceName = apiServiceName
+		uiServiceName = apiServiceName
+	}
+
 	ingress.Spec.IngressClassName = i.Spec.Ingress.ClassName
 	ingress.Spec.Rules = nil
 	pathType := networking.PathTypePrefix
@@ -119,7 +130,7 @@ func (r *ThermoCenterReconciler) reconcileIngress(i *kojedzinv1alpha1.ThermoCent
 							PathType: &pathType,
 							Backend: networking.IngressBackend{
 								Service: &networking.IngressServiceBackend{
-									Name: thermoCenterServiceName(i, r.api),
+									Name: apiServiceName,
 									Port: networking.ServiceBackendPort{
 										Name: "http",
 									},
@@ -131,7 +142,7 @@ func (r *ThermoCenterReconciler) reconcileIngress(i *kojedzinv1alpha1.ThermoCent
 							PathType: &pathType,
 							Backend: networking.IngressBackend{
 								Service: &networking.IngressServiceBackend{
-									Name: thermoCenterServiceName(i, r.api),
+									Name: apiServiceName,
 									Port: networking.ServiceBackendPort{
 										Name: "http",
 									},
@@ -143,7 +154,7 @@ func (r *ThermoCenterReconciler) reconcileIngress(i *kojedzinv1alpha1.ThermoCent
 							PathType: &pathType,
 							Backend: networking.IngressBackend{
 								Service: &networking.IngressServiceBackend{
-									Name: thermoCenterServiceName(i, r.ws),
+									Name: wsServiceName,
 									Port: networking.ServiceBackendPort{
 										Name: "http",
 									},
@@ -155,7 +166,7 @@ func (r *ThermoCenterReconciler) reconcileIngress(i *kojedzinv1alpha1.ThermoCent
 							PathType: &pathType,
 							Backend: networking.IngressBackend{
 								Service: &networking.IngressServiceBackend{
-									Name: thermoCenterServiceName(i, r.ui),
+									Name: uiServiceName,
 									Port: networking.ServiceBackendPort{
 										Name: "http",
 									},
@@ -168,6 +179,24 @@ func (r *ThermoCenterReconciler) reconcileIngress(i *kojedzinv1alpha1.ThermoCent
 		})
 	}
 
+	// MQTT over websockets, not affected by maintenance
+	if mqttWebSockets(i) {
+		for idx := range ingress.Spec.Rules {
+			ingress.Spec.Rules[idx].HTTP.Paths = append(ingress.Spec.Rules[idx].HTTP.Paths, networking.HTTPIngressPath{
+				Path:     "/mqtt",
+				PathType: &pathType,
+				Backend: networking.IngressBackend{
+					Service: &networking.IngressServiceBackend{
+						Name: thermoCenterServiceName(i, r.mqtt),
+						Port: networking.ServiceBackendPort{
+							Name: mqttWebSocketsPortName,
+						},
+					},
+				},
+			})
+		}
+	}
+
 	if i.Spec.Ingress.TLS {
 		ingress.Spec.TLS = []networking.IngressTLS{
 			{
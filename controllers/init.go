@@ -0,0 +1,126 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// needsInit reports whether the database of a new instance is still to be initialized from a backup
+func needsInit(i *kojedzinv1alpha1.ThermoCenter) bool {
+	if i.Spec.InitFrom == nil {
+		return false
+	}
+
+	return i.Status.DatabaseVersion == "" && !meta.IsStatusConditionTrue(i.Status.Conditions, kojedzinv1alpha1.ConditionInitialized)
+}
+
+// reconcileInit restores the referenced backup into the empty database, and
+// continues with the database version of the backup
+func (r *ThermoCenterReconciler) reconcileInit(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (ctrl.Result, error) {
+	loc, err := r.resolveBackupSource(i.Namespace, i.Spec.InitFrom)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterInitJobName(i)}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		l.Info("Creating init job", "backup", loc.File)
+
+		job = &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: i.Namespace,
+				Name:      thermoCenterInitJobName(i),
+			},
+			Spec: r.restoreJobSpec(i, loc),
+		}
+
+		if err = controllerutil.SetControllerReference(i, job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err = r.Create(context.TODO(), job); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		i.Status.Status = "initializing"
+
+		return ctrl.Result{}, r.Status().Update(context.TODO(), i)
+	}
+
+	if job.Status.Succeeded > 0 {
+		version, err := r.restoredDatabaseVersion(job, loc.DatabaseVersion)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		l.Info("Database initialized from backup", "databaseVersion", version)
+
+		i.Status.DatabaseVersion = version
+		meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+			Type:               kojedzinv1alpha1.ConditionInitialized,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: i.Generation,
+			Reason:             "Restored",
+			Message:            fmt.Sprintf("Restored backup %s", loc.File),
+		})
+	} else if job.Status.Failed > 0 {
+		l.Info("Init job failed, retrying")
+
+		meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+			Type:               kojedzinv1alpha1.ConditionInitialized,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: i.Generation,
+			Reason:             "JobFailed",
+			Message:            fmt.Sprintf("Init job %s failed", job.Name),
+		})
+	} else {
+		return ctrl.Result{}, nil
+	}
+
+	if err = r.Status().Update(context.TODO(), i); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Delete job. This will trigger new reconcile cycle.
+	return ctrl.Result{}, r.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+}
@@ -0,0 +1,235 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newInitThermoCenter() *kojedzinv1alpha1.ThermoCenter {
+	i := newTestThermoCenter()
+	i.Name = "staging"
+	i.Spec.InitFrom = &kojedzinv1alpha1.BackupSource{Backup: "nightly"}
+
+	return i
+}
+
+func TestNeedsInit(t *testing.T) {
+	initialized := metav1.Condition{Type: kojedzinv1alpha1.ConditionInitialized, Status: metav1.ConditionTrue}
+	failed := metav1.Condition{Type: kojedzinv1alpha1.ConditionInitialized, Status: metav1.ConditionFalse}
+
+	tests := []struct {
+		name       string
+		initFrom   bool
+		version    string
+		conditions []metav1.Condition
+		want       bool
+	}{
+		{"no backup to initialize from", false, "", nil, false},
+		{"new instance", true, "", nil, true},
+		{"failed init is retried", true, "", []metav1.Condition{failed}, true},
+		{"initialized", true, "", []metav1.Condition{initialized}, false},
+		{"database already migrated", true, "4.1.0", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newInitThermoCenter()
+			if !test.initFrom {
+				i.Spec.InitFrom = nil
+			}
+			i.Status.DatabaseVersion = test.version
+			i.Status.Conditions = test.conditions
+
+			if got := needsInit(i); got != test.want {
+				t.Errorf("needsInit() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// reconcileInit runs an init step of the stored instance, and returns its updated state
+func reconcileInit(t *testing.T, r *ThermoCenterReconciler) *kojedzinv1alpha1.ThermoCenter {
+	t.Helper()
+
+	i := &kojedzinv1alpha1.ThermoCenter{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "staging"}, i); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.reconcileInit(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	i = &kojedzinv1alpha1.ThermoCenter{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "staging"}, i); err != nil {
+		t.Fatal(err)
+	}
+
+	return i
+}
+
+func getInitJob(t *testing.T, r *ThermoCenterReconciler) (*batchv1.Job, error) {
+	t.Helper()
+
+	job := &batchv1.Job{}
+
+	return job, r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "staging-init"}, job)
+}
+
+func TestReconcileInit(t *testing.T) {
+	i := newInitThermoCenter()
+	r := newTestReconciler(nil, i, newSucceededBackup(newTestThermoCenter(), "nightly", "3.3.1"))
+
+	i = reconcileInit(t, r)
+	if i.Status.Status != "initializing" {
+		t.Errorf("status = %q, want initializing", i.Status.Status)
+	}
+
+	job, err := getInitJob(t, r)
+	if err != nil {
+		t.Fatalf("init job not created: %v", err)
+	}
+	if owners := job.GetOwnerReferences(); len(owners) != 1 || owners[0].Kind != "ThermoCenter" || owners[0].Name != "staging" {
+		t.Errorf("owner references = %v", owners)
+	}
+	if env := containerEnv(job.Spec.Template.Spec.Containers[0]); env["FILE"].Value != "nightly.dump" {
+		t.Errorf("restored file = %q, want nightly.dump", env["FILE"].Value)
+	}
+	if init := job.Spec.Template.Spec.InitContainers; len(init) != 1 || init[0].Name != "download" {
+		t.Errorf("init containers = %v, want the S3 download", init)
+	}
+
+	// Running job
+	i = reconcileInit(t, r)
+	if i.Status.DatabaseVersion != "" || meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionInitialized) != nil {
+		t.Fatalf("instance initialized while the job is running: %+v", i.Status)
+	}
+
+	job.Status.Succeeded = 1
+	if err = r.Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Create(context.TODO(), newJobPod(job.Name, `{"databaseVersion":"3.4.0"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	i = reconcileInit(t, r)
+	if i.Status.DatabaseVersion != "3.4.0" {
+		t.Errorf("database version = %q, want 3.4.0 recorded in the dump", i.Status.DatabaseVersion)
+	}
+
+	cond := meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionInitialized)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.Reason != "Restored" {
+		t.Errorf("Initialized condition = %+v", cond)
+	}
+	if needsInit(i) {
+		t.Error("instance still needs init")
+	}
+
+	if _, err = getInitJob(t, r); !errors.IsNotFound(err) {
+		t.Errorf("init job not deleted: %v", err)
+	}
+}
+
+func TestReconcileInitFailure(t *testing.T) {
+	i := newInitThermoCenter()
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "staging-init"},
+		Status:     batchv1.JobStatus{Failed: 1},
+	}
+
+	r := newTestReconciler(nil, i, job, newSucceededBackup(newTestThermoCenter(), "nightly", "3.3.1"))
+
+	i = reconcileInit(t, r)
+	if i.Status.DatabaseVersion != "" {
+		t.Errorf("database version = %q, want it unset", i.Status.DatabaseVersion)
+	}
+
+	cond := meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionInitialized)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "JobFailed" {
+		t.Errorf("Initialized condition = %+v", cond)
+	}
+
+	// The job is deleted to be retried
+	if _, err := getInitJob(t, r); !errors.IsNotFound(err) {
+		t.Errorf("failed init job not deleted: %v", err)
+	}
+	if !needsInit(i) {
+		t.Error("failed init is not retried")
+	}
+}
+
+func TestReconcileInitUnknownBackup(t *testing.T) {
+	i := newInitThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	if _, err := r.reconcileInit(i, r.Log); err == nil {
+		t.Error("expected error for a missing backup")
+	}
+
+	if _, err := getInitJob(t, r); !errors.IsNotFound(err) {
+		t.Errorf("init job created without a backup: %v", err)
+	}
+}
+
+func TestReceiverOfClones(t *testing.T) {
+	tests := []struct {
+		name           string
+		initFrom       bool
+		enableReceiver bool
+		want           bool
+	}{
+		{"regular instance", false, false, true},
+		{"clone", true, false, false},
+		{"clone with receiver enabled", true, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newInitThermoCenter()
+			if !test.initFrom {
+				i.Spec.InitFrom = nil
+			}
+			i.Spec.EnableReceiver = test.enableReceiver
+
+			r := newTestReconciler(nil)
+			ps := &v1.PodSpec{Containers: []v1.Container{{Name: "receiver"}}}
+
+			if got := r.receiver.customizePodSpec(r, i, ps) != nil; got != test.want {
+				t.Errorf("receiver deployed = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const maintenanceNginxConf = `server {
+    listen 8080;
+    root /usr/share/nginx/html;
+
+    error_page 503 /maintenance.html;
+
+    location / {
+        return 503;
+    }
+
+    location = /maintenance.html {
+        internal;
+    }
+}
+`
+
+const maintenanceHTML = `<!DOCTYPE html>
+<html>
+<head><title>Thermo-Center maintenance</title></head>
+<body>
+<h1>Thermo-Center is being upgraded</h1>
+<p>Please check back in a few minutes.</p>
+</body>
+</html>
+`
+
+var maintenanceDeployment = &kojedzinv1alpha1.Deployment{
+	Image:    "nginxinc/nginx-unprivileged:1.20-alpine",
+	Replicas: replicas(1),
+}
+
+type maintenanceReconciler struct {
+	defaultDeploymentReconciler
+}
+
+func (m *maintenanceReconciler) component() string {
+	return "maintenance"
+}
+
+func (m *maintenanceReconciler) getDeployment(i *kojedzinv1alpha1.ThermoCenter) *kojedzinv1alpha1.Deployment {
+	if i.Spec.Maintenance == nil {
+		return maintenanceDeployment
+	}
+
+	return deploymentWithDefaults(i.Spec.Maintenance.Page, maintenanceDeployment)
+}
+
+func (m *maintenanceReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) *v1.PodSpec {
+	if !maintenancePageActive(i) {
+		return nil
+	}
+
+	// Resource requirements
+	ps.Containers[0].Resources = v1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("1m"),
+			v1.ResourceMemory: resource.MustParse("8Mi"),
+		},
+	}
+
+	// Override uid/gid
+	runAsUser := int64(101)
+	runAsGroup := int64(101)
+
+	ps.SecurityContext.RunAsUser = &runAsUser
+	ps.SecurityContext.RunAsGroup = &runAsGroup
+
+	ps.Volumes = append(ps.Volumes, v1.Volume{
+		Name: "maintenance",
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: thermoCenterMaintenanceConfigMapName(i)},
+			},
+		},
+	})
+
+	ps.Containers[0].VolumeMounts = append(ps.Containers[0].VolumeMounts,
+		v1.VolumeMount{
+			Name:      "maintenance",
+			MountPath: "/etc/nginx/conf.d/default.conf",
+			SubPath:   "default.conf",
+		},
+		v1.VolumeMount{
+			Name:      "maintenance",
+			MountPath: "/usr/share/nginx/html/maintenance.html",
+			SubPath:   "maintenance.html",
+		},
+	)
+
+	// Ports
+	ps.Containers[0].Ports = []v1.ContainerPort{{
+		Name:          "http",
+		ContainerPort: 8080,
+	}}
+
+	return ps
+}
+
+func (m *maintenanceReconciler) customizeService(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, service *v1.Service) *v1.Service {
+	if !maintenancePageActive(i) {
+		return nil
+	}
+
+	service.Spec.Ports = []v1.ServicePort{{
+		Name: "http",
+		Port: 8080,
+	}}
+
+	return service
+}
+
+func thermoCenterMaintenanceConfigMapName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-maintenance"
+}
+
+// maintenanceActive reports whether the instance is kept in maintenance,
+// which lasts until the database is migrated to the desired version
+func maintenanceActive(i *kojedzinv1alpha1.ThermoCenter) bool {
+	if i.Spec.Maintenance == nil {
+		return false
+	}
+
+	version := desiredVersion(i)
+	if version == "" {
+		return false
+	}
+
+	return i.Status.DatabaseVersion != version
+}
+
+// maintenancePageActive reports whether the maintenance page should be served
+func maintenancePageActive(i *kojedzinv1alpha1.ThermoCenter) bool {
+	return maintenanceActive(i) && i.Spec.Maintenance.Mode == kojedzinv1alpha1.MaintenanceModePage
+}
+
+// maintenanceScalesDown reports whether a component must be kept scaled down
+func (r *ThermoCenterReconciler) maintenanceScalesDown(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler) bool {
+	if !maintenanceActive(i) {
+		return false
+	}
+
+	for _, c := range r.maintenanceComponents() {
+		if c == rec {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maintenanceComponents lists components which are scaled down during maintenance
+func (r *ThermoCenterReconciler) maintenanceComponents() []deploymentReconciler {
+	return []deploymentReconciler{r.api, r.grpc, r.ws}
+}
+
+func (r *ThermoCenterReconciler) reconcileMaintenanceConfigMap(i *kojedzinv1alpha1.ThermoCenter) error {
+	configMapName := thermoCenterMaintenanceConfigMapName(i)
+	configMap := &v1.ConfigMap{}
+	found := true
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: configMapName}, configMap)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		found = false
+
+		configMap.ObjectMeta = metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      configMapName,
+		}
+
+		if err = controllerutil.SetControllerReference(i, configMap, r.Scheme); err != nil {
+			return err
+		}
+	}
+
+	configMap.Data = map[string]string{
+		"default.conf":     maintenanceNginxConf,
+		"maintenance.html": maintenanceHTML,
+	}
+
+	if found {
+		return r.Update(context.TODO(), configMap)
+	}
+
+	return r.Create(context.TODO(), configMap)
+}
+
+// enterMaintenance scales down components and optionally starts serving the
+// maintenance page. It returns true once no old component pods are running.
+func (r *ThermoCenterReconciler) enterMaintenance(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (bool, error) {
+	if maintenancePageActive(i) {
+		if err := r.reconcileMaintenanceConfigMap(i); err != nil {
+			return false, err
+		}
+
+		if err := r.reconcile(i, r.maintenance); err != nil {
+			return false, err
+		}
+
+		if err := r.reconcileIngress(i); err != nil {
+			return false, err
+		}
+	}
+
+	stopped := true
+	for _, rec := range r.maintenanceComponents() {
+		if err := r.reconcile(i, rec); err != nil {
+			return false, err
+		}
+
+		deployment := &appsv1.Deployment{}
+		err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterDeploymentName(i, rec)}, deployment)
+		if err != nil {
+			return false, err
+		}
+
+		if deployment.Status.Replicas > 0 {
+			l.Info("Waiting for component to scale down", "component", rec.component())
+			stopped = false
+		}
+	}
+
+	return stopped, nil
+}
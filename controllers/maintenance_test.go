@@ -0,0 +1,117 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestMaintenance(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Spec.Maintenance = &kojedzinv1alpha1.Maintenance{Mode: kojedzinv1alpha1.MaintenanceModePage}
+	i.Status.DatabaseVersion = "4.0.0"
+	r := newTestReconciler(nil, i)
+
+	reconcileAll := func() {
+		t.Helper()
+
+		for _, rec := range r.deploymentReconcilers() {
+			if err := r.reconcile(i, rec); err != nil {
+				t.Fatalf("reconciling %s: %v", rec.component(), err)
+			}
+		}
+	}
+
+	deployment := func(rec deploymentReconciler) (*appsv1.Deployment, error) {
+		d := &appsv1.Deployment{}
+
+		return d, r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterDeploymentName(i, rec)}, d)
+	}
+
+	service := func(rec deploymentReconciler) error {
+		return r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterServiceName(i, rec)}, &v1.Service{})
+	}
+
+	// Entering maintenance while migrating to 4.1.0
+	reconcileAll()
+
+	for _, rec := range r.maintenanceComponents() {
+		d, err := deployment(rec)
+		if err != nil {
+			t.Fatalf("%s deployment: %v", rec.component(), err)
+		}
+		if *d.Spec.Replicas != 0 {
+			t.Errorf("%s not scaled down during maintenance: %d replicas", rec.component(), *d.Spec.Replicas)
+		}
+	}
+	if d, err := deployment(r.ui); err != nil || *d.Spec.Replicas != 1 {
+		t.Errorf("ui scaled down during maintenance: %v", err)
+	}
+	if _, err := deployment(r.maintenance); err != nil {
+		t.Errorf("maintenance page not deployed: %v", err)
+	}
+	if err := service(r.maintenance); err != nil {
+		t.Errorf("maintenance service not created: %v", err)
+	}
+
+	// Restoring after the migration
+	i.Status.DatabaseVersion = "4.1.0"
+	reconcileAll()
+
+	for _, rec := range r.maintenanceComponents() {
+		d, err := deployment(rec)
+		if err != nil {
+			t.Fatalf("%s deployment: %v", rec.component(), err)
+		}
+		if *d.Spec.Replicas != 1 {
+			t.Errorf("%s not restored after maintenance: %d replicas", rec.component(), *d.Spec.Replicas)
+		}
+	}
+	if _, err := deployment(r.maintenance); !errors.IsNotFound(err) {
+		t.Errorf("maintenance page not removed: %v", err)
+	}
+	if err := service(r.maintenance); !errors.IsNotFound(err) {
+		t.Errorf("maintenance service not removed: %v", err)
+	}
+
+	// ScaleDown mode serves no page
+	i.Spec.Maintenance.Mode = kojedzinv1alpha1.MaintenanceModeScaleDown
+	i.Status.DatabaseVersion = "4.0.0"
+	reconcileAll()
+
+	if d, err := deployment(r.api); err != nil || *d.Spec.Replicas != 0 {
+		t.Errorf("api not scaled down in ScaleDown mode: %v", err)
+	}
+	if _, err := deployment(r.maintenance); !errors.IsNotFound(err) {
+		t.Errorf("maintenance page deployed in ScaleDown mode: %v", err)
+	}
+}
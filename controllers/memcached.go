@@ -29,7 +29,6 @@ import (
 
 	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 var memcachedDeployment = &kojedzinv1alpha1.Deployment{
@@ -46,21 +45,22 @@ func (m *memcachedReconciler) component() string {
 }
 
 func (m *memcachedReconciler) getDeployment(i *kojedzinv1alpha1.ThermoCenter) *kojedzinv1alpha1.Deployment {
-	return memcachedDeployment
+	if i.Spec.Memcached == nil {
+		return memcachedDeployment
+	}
+
+	return deploymentWithDefaults(&i.Spec.Memcached.Deployment, memcachedDeployment)
 }
 
 func (m *memcachedReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) *v1.PodSpec {
-	if i.Spec.ExternalMemcached != nil {
+	if i.Spec.ExternalMemcached != nil || cacheType(i) != kojedzinv1alpha1.CacheTypeMemcached {
 		return nil
 	}
 
+	memorySize := cacheMemorySize(i.Spec.Memcached)
+
 	// Resource requirements
-	ps.Containers[0].Resources = v1.ResourceRequirements{
-		Requests: v1.ResourceList{
-			v1.ResourceCPU:    resource.MustParse("10m"),
-			v1.ResourceMemory: resource.MustParse("16Mi"),
-		},
-	}
+	ps.Containers[0].Resources = cacheResources(i.Spec.Memcached, memorySize)
 
 	// Override uid/gid
 	runAsUser := int64(11211)
@@ -70,7 +70,7 @@ func (m *memcachedReconciler) customizePodSpec(r *ThermoCenterReconciler, i *koj
 	ps.SecurityContext.RunAsGroup = &runAsGroup
 
 	// Command
-	ps.Containers[0].Command = []string{"memcached", "-m", "16"}
+	ps.Containers[0].Command = []string{"memcached", "-m", strconv.FormatInt(memoryMegabytes(memorySize), 10)}
 
 	// Ports
 	ps.Containers[0].Ports = []v1.ContainerPort{{
@@ -82,6 +82,10 @@ func (m *memcachedReconciler) customizePodSpec(r *ThermoCenterReconciler, i *koj
 }
 
 func (m *memcachedReconciler) customizeService(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, service *v1.Service) *v1.Service {
+	if i.Spec.ExternalMemcached != nil || cacheType(i) != kojedzinv1alpha1.CacheTypeMemcached {
+		return nil
+	}
+
 	service.Spec.Ports = []v1.ServicePort{{
 		Name: m.component(),
 		Port: 11211,
@@ -100,12 +104,12 @@ func (m *memcachedReconciler) serviceHost(r *ThermoCenterReconciler, i *kojedzin
 
 func (m *memcachedReconciler) servicePort(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter) int {
 	if i.Spec.ExternalMemcached == nil {
-		return 11211
+		return kojedzinv1alpha1.DefaultMemcachedPort
 	}
 
 	port := i.Spec.ExternalMemcached.Port
 	if port == 0 {
-		port = 11211
+		port = kojedzinv1alpha1.DefaultMemcachedPort
 	}
 
 	return port
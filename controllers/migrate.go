@@ -26,9 +26,13 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"github.com/rkojedzinszky/thermo-center-controller/registry"
 	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -43,16 +47,73 @@ import (
 
 const thermoCenterDBVersionAnnotation = "thermo-center-db-version"
 
-func (r *ThermoCenterReconciler) needsMigration(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) bool {
-	// latest image not handled by operator
-	if i.Spec.Version == nil || *i.Spec.Version == "" {
-		l.Info("No version specified, not doing migration")
+// Number of migrations kept in status
+const migrationHistoryLimit = 10
+
+// migrationTarget returns the database version to migrate to. With no version
+// specified, the digest of the api image is used, so that instances tracking
+// latest images are migrated whenever a new image is published.
+func (r *ThermoCenterReconciler) migrationTarget(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) string {
+	if version := desiredVersion(i); version != "" {
+		return version
+	}
+
+	digest, err := r.resolveAPIDigest(i, l)
+	if err != nil {
+		l.Error(err, "Unable to resolve api image digest")
+
+		return ""
+	}
+
+	return digest
+}
+
+// resolveAPIDigest resolves the digest of the api image from its registry,
+// falling back to the image running in api pods
+func (r *ThermoCenterReconciler) resolveAPIDigest(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (string, error) {
+	image := r.getPodSpec(i, r.api).Containers[0].Image
+
+	keychain, err := r.registryKeychain(i)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := r.registry.Digest(context.TODO(), image, keychain)
+	if err == nil {
+		return digest, nil
+	}
+
+	l.Info("Unable to query registry, using digest of running api pods", "image", image, "error", err.Error())
+
+	pods := &v1.PodList{}
+	if err = r.List(context.TODO(), pods, client.InNamespace(i.Namespace), client.MatchingLabels(labelsForComponent(i, r.api.component()))); err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name != r.api.component() {
+				continue
+			}
+
+			if at := strings.LastIndexByte(cs.ImageID, '@'); at != -1 {
+				return cs.ImageID[at+1:], nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no running api pod reports an image digest for %s", image)
+}
+
+func (r *ThermoCenterReconciler) needsMigration(i *kojedzinv1alpha1.ThermoCenter, target string, l logr.Logger) bool {
+	if target == "" {
+		l.Info("Unable to determine target version, not doing migration")
 
 		return false
 	}
 
 	// No migration needed if annotation matches desired version
-	if i.Status.DatabaseVersion == *i.Spec.Version {
+	if i.Status.DatabaseVersion == target {
 		l.Info("Desired and current databae versions match, skipping migration")
 
 		return false
@@ -81,27 +142,83 @@ func (r *ThermoCenterReconciler) handleMigrationJob(i *kojedzinv1alpha1.ThermoCe
 	if job.Status.Succeeded > 0 {
 		l.Info("Migration job succeeded")
 
+		r.recordMigration(i, job, kojedzinv1alpha1.MigrationSucceeded)
+
 		// Update db version from job to annotation
 		i.Status.Status = "migration done"
+		i.Status.PreviousVersion = i.Status.DatabaseVersion
 		i.Status.DatabaseVersion = job.Annotations[thermoCenterDBVersionAnnotation]
-
-		// Update thermo-center instance status.
-		if err := r.Status().Update(context.TODO(), i); err != nil {
-			return ctrl.Result{}, err
-		}
 	} else if job.Status.Failed > 0 {
 		l.Info("Migration job failed, requeueing")
+
+		r.recordMigration(i, job, kojedzinv1alpha1.MigrationFailed)
 	} else {
 		return ctrl.Result{}, nil
 	}
 
+	// Update thermo-center instance status.
+	if err := r.Status().Update(context.TODO(), i); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Delete job. This will trigger new reconcile cycle.
 	return ctrl.Result{}, r.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
 }
 
-func (r *ThermoCenterReconciler) createMigrationJob(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (ctrl.Result, error) {
+// recordMigration appends a finished migration job to the bounded history in status
+func (r *ThermoCenterReconciler) recordMigration(i *kojedzinv1alpha1.ThermoCenter, job *batchv1.Job, outcome string) {
+	endTime := job.Status.CompletionTime
+	if endTime == nil {
+		now := metav1.Now()
+		endTime = &now
+	}
+
+	migration := kojedzinv1alpha1.Migration{
+		From:      i.Status.DatabaseVersion,
+		To:        job.Annotations[thermoCenterDBVersionAnnotation],
+		StartTime: job.Status.StartTime,
+		EndTime:   endTime,
+		Outcome:   outcome,
+		JobName:   job.Name,
+		Attempts:  1,
+	}
+
+	// Count consecutive attempts to reach the same version
+	if n := len(i.Status.MigrationHistory); n > 0 {
+		last := i.Status.MigrationHistory[n-1]
+		if last.Outcome == kojedzinv1alpha1.MigrationFailed && last.To == migration.To {
+			migration.Attempts = last.Attempts + 1
+		}
+	}
+
+	i.Status.MigrationHistory = append(i.Status.MigrationHistory, migration)
+	if n := len(i.Status.MigrationHistory); n > migrationHistoryLimit {
+		i.Status.MigrationHistory = i.Status.MigrationHistory[n-migrationHistoryLimit:]
+	}
+
+	i.Status.LastMigrationTime = endTime
+}
+
+func (r *ThermoCenterReconciler) createMigrationJob(i *kojedzinv1alpha1.ThermoCenter, target string, l logr.Logger) (ctrl.Result, error) {
+	// Enter maintenance, and wait for old components to stop
+	if maintenanceActive(i) {
+		stopped, err := r.enterMaintenance(i, l)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if !stopped {
+			i.Status.Status = "entering maintenance"
+			if err = r.Status().Update(context.TODO(), i); err != nil {
+				return ctrl.Result{}, err
+			}
+
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+	}
+
 	// Create migration job
-	l.Info("Creating migration job", "targetVersion", *i.Spec.Version)
+	l.Info("Creating migration job", "targetVersion", target)
 
 	var activeDeadlineSeconds int64 = 600
 
@@ -113,12 +230,20 @@ func (r *ThermoCenterReconciler) createMigrationJob(i *kojedzinv1alpha1.ThermoCe
 	ps.Containers[0].ReadinessProbe = nil
 	ps.RestartPolicy = v1.RestartPolicyNever
 
+	// Migrate through a direct database connection
+	r.pooler.unsetDatabaseEnvironment(r, i, ps)
+
+	// Migrate with exactly the resolved image
+	if isDigest(target) {
+		ps.Containers[0].Image = registry.Name(ps.Containers[0].Image) + "@" + target
+	}
+
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: i.Namespace,
 			Name:      thermoCenterMigrationJobName(i),
 			Annotations: map[string]string{
-				thermoCenterDBVersionAnnotation: *i.Spec.Version,
+				thermoCenterDBVersionAnnotation: target,
 			},
 		},
 		Spec: batchv1.JobSpec{
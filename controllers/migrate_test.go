@@ -0,0 +1,164 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newMigrationJob(i *kojedzinv1alpha1.ThermoCenter, version string, status batchv1.JobStatus) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   i.Namespace,
+			Name:        thermoCenterMigrationJobName(i),
+			Annotations: map[string]string{thermoCenterDBVersionAnnotation: version},
+		},
+		Status: status,
+	}
+}
+
+func TestRecordMigration(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Status.DatabaseVersion = "4.0.0"
+	r := newTestReconciler(nil)
+
+	start := metav1.NewTime(time.Date(2021, 8, 1, 3, 0, 0, 0, time.UTC))
+	end := metav1.NewTime(start.Add(time.Minute))
+
+	// Failures reaching the same version are counted
+	for attempt := int32(1); attempt <= 3; attempt++ {
+		r.recordMigration(i, newMigrationJob(i, "4.1.0", batchv1.JobStatus{StartTime: &start, Failed: 1}), kojedzinv1alpha1.MigrationFailed)
+
+		last := i.Status.MigrationHistory[len(i.Status.MigrationHistory)-1]
+		if last.Attempts != attempt {
+			t.Errorf("attempts = %d, want %d", last.Attempts, attempt)
+		}
+		if last.From != "4.0.0" || last.To != "4.1.0" || last.Outcome != kojedzinv1alpha1.MigrationFailed {
+			t.Errorf("migration = %+v", last)
+		}
+		if last.EndTime == nil || i.Status.LastMigrationTime != last.EndTime {
+			t.Errorf("end time = %v, last migration time = %v", last.EndTime, i.Status.LastMigrationTime)
+		}
+	}
+
+	// A failure towards another version starts counting again
+	r.recordMigration(i, newMigrationJob(i, "4.1.1", batchv1.JobStatus{Failed: 1}), kojedzinv1alpha1.MigrationFailed)
+	if last := i.Status.MigrationHistory[len(i.Status.MigrationHistory)-1]; last.Attempts != 1 {
+		t.Errorf("attempts towards another version = %d, want 1", last.Attempts)
+	}
+
+	// The successful attempt is counted
+	r.recordMigration(i, newMigrationJob(i, "4.1.1", batchv1.JobStatus{StartTime: &start, CompletionTime: &end, Succeeded: 1}), kojedzinv1alpha1.MigrationSucceeded)
+
+	last := i.Status.MigrationHistory[len(i.Status.MigrationHistory)-1]
+	if last.Attempts != 2 || last.Outcome != kojedzinv1alpha1.MigrationSucceeded {
+		t.Errorf("migration = %+v, want the second attempt succeeded", last)
+	}
+	if !last.EndTime.Equal(&end) || !last.StartTime.Equal(&start) {
+		t.Errorf("start %v, end %v, want %v and %v", last.StartTime, last.EndTime, start, end)
+	}
+	if last.JobName != thermoCenterMigrationJobName(i) {
+		t.Errorf("job name = %q", last.JobName)
+	}
+
+	// A failure after a success starts counting again
+	r.recordMigration(i, newMigrationJob(i, "4.1.1", batchv1.JobStatus{Failed: 1}), kojedzinv1alpha1.MigrationFailed)
+	if last := i.Status.MigrationHistory[len(i.Status.MigrationHistory)-1]; last.Attempts != 1 {
+		t.Errorf("attempts after a success = %d, want 1", last.Attempts)
+	}
+
+	if n := len(i.Status.MigrationHistory); n != 6 {
+		t.Errorf("history has %d entries, want 7", n)
+	}
+}
+
+func TestRecordMigrationLimit(t *testing.T) {
+	i := newTestThermoCenter()
+	r := newTestReconciler(nil)
+
+	for n := 0; n < migrationHistoryLimit+5; n++ {
+		i.Status.DatabaseVersion = fmt.Sprintf("4.0.%d", n)
+		r.recordMigration(i, newMigrationJob(i, fmt.Sprintf("4.0.%d", n+1), batchv1.JobStatus{Succeeded: 1}), kojedzinv1alpha1.MigrationSucceeded)
+
+		want := n + 1
+		if want > migrationHistoryLimit {
+			want = migrationHistoryLimit
+		}
+		if got := len(i.Status.MigrationHistory); got != want {
+			t.Fatalf("history has %d entries after %d migrations, want %d", got, n+1, want)
+		}
+	}
+
+	// The oldest entries are dropped
+	if first := i.Status.MigrationHistory[0]; first.From != "4.0.5" || first.To != "4.0.6" {
+		t.Errorf("oldest kept migration = %s -> %s, want 4.0.5 -> 4.0.6", first.From, first.To)
+	}
+	if last := i.Status.MigrationHistory[migrationHistoryLimit-1]; last.From != "4.0.14" || last.To != "4.0.15" {
+		t.Errorf("newest migration = %s -> %s, want 4.0.14 -> 4.0.15", last.From, last.To)
+	}
+}
+
+func TestHandleMigrationJob(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Status.DatabaseVersion = "4.0.0"
+	job := newMigrationJob(i, "4.1.0", batchv1.JobStatus{Failed: 1})
+	r := newTestReconciler(nil, i, job)
+
+	if _, err := r.handleMigrationJob(i, job, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	i = getThermoCenter(t, r.Client)
+	if i.Status.DatabaseVersion != "4.0.0" {
+		t.Errorf("database version = %q after a failed migration", i.Status.DatabaseVersion)
+	}
+	if n := len(i.Status.MigrationHistory); n != 1 {
+		t.Fatalf("history has %d entries, want 1", n)
+	}
+
+	job = newMigrationJob(i, "4.1.0", batchv1.JobStatus{Succeeded: 1})
+	if err := r.Create(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.handleMigrationJob(i, job, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	i = getThermoCenter(t, r.Client)
+	if i.Status.DatabaseVersion != "4.1.0" || i.Status.PreviousVersion != "4.0.0" {
+		t.Errorf("database version = %q, previous = %q", i.Status.DatabaseVersion, i.Status.PreviousVersion)
+	}
+	if last := i.Status.MigrationHistory[len(i.Status.MigrationHistory)-1]; last.Attempts != 2 || last.Outcome != kojedzinv1alpha1.MigrationSucceeded {
+		t.Errorf("migration = %+v", last)
+	}
+}
@@ -25,13 +25,47 @@ SOFTWARE.
 package controllers
 
 import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
 	"strconv"
+	"strings"
 
 	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;create
+
+// Annotation on mqtt pods, restarting them on configuration changes
+const mqttConfigVersionAnnotation = "thermo-center-mqtt-config-version"
+
+// Credentials of the built-in broker
+const (
+	mqttUsername = "thermo-center"
+
+	sMQTTUSERNAME = "username"
+	sMQTTPASSWORD = "password"
+	sMQTTPASSWD   = "passwd"
+	sMQTTBRIDGE   = "bridge.conf"
+)
+
+// Websockets listener of the built-in broker
+const (
+	mqttWebSocketsPortName = "websockets"
+	mqttWebSocketsPort     = 8080
+)
+
+var mqttDefaultStorageSize = resource.MustParse("100Mi")
+
 var mqttDeployment = &kojedzinv1alpha1.Deployment{
 	Image:    "eclipse-mosquitto:1.6.12",
 	Replicas: replicas(1),
@@ -46,7 +80,11 @@ func (m *mqttReconciler) component() string {
 }
 
 func (m *mqttReconciler) getDeployment(i *kojedzinv1alpha1.ThermoCenter) *kojedzinv1alpha1.Deployment {
-	return mqttDeployment
+	if i.Spec.MQTT == nil {
+		return mqttDeployment
+	}
+
+	return deploymentWithDefaults(&i.Spec.MQTT.Deployment, mqttDeployment)
 }
 
 func (m *mqttReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) *v1.PodSpec {
@@ -68,25 +106,157 @@ func (m *mqttReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzin
 
 	ps.SecurityContext.RunAsUser = &runAsUser
 	ps.SecurityContext.RunAsGroup = &runAsGroup
+	ps.SecurityContext.FSGroup = &runAsGroup
+
+	// Configuration, password file and bridge configuration
+	items := []v1.KeyToPath{{
+		Key:  sMQTTPASSWD,
+		Path: sMQTTPASSWD,
+	}}
+	if bridge := mqttBridge(i); bridge != nil {
+		items = append(items, v1.KeyToPath{
+			Key:  sMQTTBRIDGE,
+			Path: sMQTTBRIDGE,
+		})
+
+		if bridge.TLS != nil {
+			mountMQTTTLS(ps, &ps.Containers[0], "bridge", "/mosquitto", bridge.TLS)
+		}
+	}
+
+	ps.Volumes = append(ps.Volumes,
+		v1.Volume{
+			Name: "config",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: thermoCenterMQTTName(i)},
+				},
+			},
+		},
+		v1.Volume{
+			Name: "secret",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: thermoCenterMQTTName(i),
+					Items:      items,
+				},
+			},
+		},
+	)
+	ps.Containers[0].VolumeMounts = append(ps.Containers[0].VolumeMounts,
+		v1.VolumeMount{
+			Name:      "config",
+			MountPath: "/mosquitto/config",
+			ReadOnly:  true,
+		},
+		v1.VolumeMount{
+			Name:      "secret",
+			MountPath: "/mosquitto/secret",
+			ReadOnly:  true,
+		},
+	)
+
+	if mqttPersistent(i) {
+		ps.Volumes = append(ps.Volumes, v1.Volume{
+			Name: "data",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: thermoCenterMQTTDataName(i),
+				},
+			},
+		})
+		ps.Containers[0].VolumeMounts = append(ps.Containers[0].VolumeMounts, v1.VolumeMount{
+			Name:      "data",
+			MountPath: "/mosquitto/data",
+		})
+	}
 
 	// Ports
 	ps.Containers[0].Ports = []v1.ContainerPort{{
 		Name:          m.component(),
 		ContainerPort: 1883,
 	}}
+	if mqttWebSockets(i) {
+		ps.Containers[0].Ports = append(ps.Containers[0].Ports, v1.ContainerPort{
+			Name:          mqttWebSocketsPortName,
+			ContainerPort: mqttWebSocketsPort,
+		})
+	}
 
 	return ps
 }
 
 func (m *mqttReconciler) customizeService(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, service *v1.Service) *v1.Service {
+	// Keep allocated node ports
+	nodePorts := make(map[string]int32)
+	for _, port := range service.Spec.Ports {
+		nodePorts[port.Name] = port.NodePort
+	}
+
 	service.Spec.Ports = []v1.ServicePort{{
 		Name: m.component(),
 		Port: 1883,
 	}}
+	if mqttWebSockets(i) {
+		service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{
+			Name: mqttWebSocketsPortName,
+			Port: mqttWebSocketsPort,
+		})
+	}
+
+	expose := mqttExpose(i)
+	if expose == nil {
+		service.Spec.Type = v1.ServiceTypeClusterIP
+		service.Spec.ExternalTrafficPolicy = ""
+		service.Spec.HealthCheckNodePort = 0
+		service.Spec.LoadBalancerSourceRanges = nil
+
+		return service
+	}
+
+	for idx := range service.Spec.Ports {
+		service.Spec.Ports[idx].NodePort = nodePorts[service.Spec.Ports[idx].Name]
+	}
+
+	// Client addresses are preserved for the network policy
+	service.Spec.Type = expose.ServiceType
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+
+	if expose.ServiceType == v1.ServiceTypeLoadBalancer {
+		service.Spec.LoadBalancerSourceRanges = expose.AllowedCIDRs
+	} else {
+		service.Spec.HealthCheckNodePort = 0
+		service.Spec.LoadBalancerSourceRanges = nil
+	}
 
 	return service
 }
 
+func (m *mqttReconciler) customizeDeployment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, deployment *appsv1.Deployment) error {
+	// The data volume can not be shared between old and new pods
+	if mqttPersistent(i) {
+		deployment.Spec.Strategy = appsv1.DeploymentStrategy{
+			Type: appsv1.RecreateDeploymentStrategyType,
+		}
+	} else {
+		deployment.Spec.Strategy = appsv1.DeploymentStrategy{}
+	}
+
+	configMap := &v1.ConfigMap{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterMQTTName(i)}, configMap); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	secret := &v1.Secret{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterMQTTName(i)}, secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	deployment.Spec.Template.Annotations[mqttConfigVersionAnnotation] = configMap.ResourceVersion + "-" + secret.ResourceVersion
+
+	return nil
+}
+
 func (m *mqttReconciler) serviceHost(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter) string {
 	if i.Spec.ExternalMQTT == nil {
 		return thermoCenterServiceName(i, m)
@@ -97,19 +267,26 @@ func (m *mqttReconciler) serviceHost(r *ThermoCenterReconciler, i *kojedzinv1alp
 
 func (m *mqttReconciler) servicePort(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter) int {
 	if i.Spec.ExternalMQTT == nil {
-		return 1883
+		return kojedzinv1alpha1.DefaultMQTTPort
 	}
 
 	port := i.Spec.ExternalMQTT.Port
 	if port == 0 {
-		port = 1883
+		if i.Spec.ExternalMQTT.TLS != nil {
+			port = kojedzinv1alpha1.DefaultMQTTTLSPort
+		} else {
+			port = kojedzinv1alpha1.DefaultMQTTPort
+		}
 	}
 
 	return port
 }
 
-func (m *mqttReconciler) setEnvironment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, env *[]v1.EnvVar) {
-	*env = append(*env,
+// setEnvironment configures broker access of a pod, mounting TLS files when needed
+func (m *mqttReconciler) setEnvironment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) {
+	c := &ps.Containers[0]
+
+	c.Env = append(c.Env,
 		v1.EnvVar{
 			Name:  "MQTT_HOST",
 			Value: m.serviceHost(r, i),
@@ -119,4 +296,392 @@ func (m *mqttReconciler) setEnvironment(r *ThermoCenterReconciler, i *kojedzinv1
 			Value: strconv.Itoa(m.servicePort(r, i)),
 		},
 	)
+
+	ext := i.Spec.ExternalMQTT
+	if ext == nil {
+		c.Env = append(c.Env,
+			secretKeyEnv("MQTT_USERNAME", thermoCenterMQTTName(i), sMQTTUSERNAME),
+			secretKeyEnv("MQTT_PASSWORD", thermoCenterMQTTName(i), sMQTTPASSWORD),
+		)
+
+		return
+	}
+
+	if ext.UsernameSecretRef != nil {
+		c.Env = append(c.Env, secretKeyEnv("MQTT_USERNAME", ext.UsernameSecretRef.Name, ext.UsernameSecretRef.Key))
+	}
+	if ext.PasswordSecretRef != nil {
+		c.Env = append(c.Env, secretKeyEnv("MQTT_PASSWORD", ext.PasswordSecretRef.Name, ext.PasswordSecretRef.Key))
+	}
+
+	if ext.TLS == nil {
+		return
+	}
+
+	c.Env = append(c.Env, v1.EnvVar{
+		Name:  "MQTT_TLS",
+		Value: "1",
+	})
+
+	caFile, certFile, keyFile := mountMQTTTLS(ps, c, "mqtt", "/etc/thermo-center", ext.TLS)
+	if caFile != "" {
+		c.Env = append(c.Env, v1.EnvVar{
+			Name:  "MQTT_TLS_CA",
+			Value: caFile,
+		})
+	}
+	if certFile != "" {
+		c.Env = append(c.Env,
+			v1.EnvVar{
+				Name:  "MQTT_TLS_CERT",
+				Value: certFile,
+			},
+			v1.EnvVar{
+				Name:  "MQTT_TLS_KEY",
+				Value: keyFile,
+			},
+		)
+	}
+}
+
+// mountMQTTTLS mounts the CA bundle and client certificate of MQTT TLS options into
+// a container, under the given directory, returning paths of mounted files
+func mountMQTTTLS(ps *v1.PodSpec, c *v1.Container, prefix string, dir string, tls *kojedzinv1alpha1.MQTTTLS) (caFile string, certFile string, keyFile string) {
+	if tls.CASecretRef != nil {
+		ps.Volumes = append(ps.Volumes, v1.Volume{
+			Name: prefix + "-ca",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: tls.CASecretRef.Name,
+					Items: []v1.KeyToPath{{
+						Key:  tls.CASecretRef.Key,
+						Path: "ca.crt",
+					}},
+				},
+			},
+		})
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+			Name:      prefix + "-ca",
+			MountPath: dir + "/" + prefix + "-ca",
+			ReadOnly:  true,
+		})
+
+		caFile = dir + "/" + prefix + "-ca/ca.crt"
+	}
+
+	if tls.ClientCertificateSecretRef != nil {
+		ps.Volumes = append(ps.Volumes, v1.Volume{
+			Name: prefix + "-client",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: tls.ClientCertificateSecretRef.Name,
+				},
+			},
+		})
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+			Name:      prefix + "-client",
+			MountPath: dir + "/" + prefix + "-client",
+			ReadOnly:  true,
+		})
+
+		certFile = dir + "/" + prefix + "-client/" + v1.TLSCertKey
+		keyFile = dir + "/" + prefix + "-client/" + v1.TLSPrivateKeyKey
+	}
+
+	return
+}
+
+// mqttExpose returns parameters of exposing the built-in broker, if enabled
+func mqttExpose(i *kojedzinv1alpha1.ThermoCenter) *kojedzinv1alpha1.MQTTExpose {
+	if i.Spec.ExternalMQTT != nil || i.Spec.MQTT == nil {
+		return nil
+	}
+
+	return i.Spec.MQTT.Expose
+}
+
+// mqttWebSockets reports whether the built-in broker accepts websockets connections through the Ingress
+func mqttWebSockets(i *kojedzinv1alpha1.ThermoCenter) bool {
+	expose := mqttExpose(i)
+
+	return expose != nil && expose.WebSockets
+}
+
+// mqttBridge returns the bridge of the built-in broker, if configured
+func mqttBridge(i *kojedzinv1alpha1.ThermoCenter) *kojedzinv1alpha1.MQTTBridge {
+	if i.Spec.ExternalMQTT != nil || i.Spec.MQTT == nil {
+		return nil
+	}
+
+	return i.Spec.MQTT.Bridge
+}
+
+// mqttPersistent reports whether the built-in broker stores its state on a volume
+func mqttPersistent(i *kojedzinv1alpha1.ThermoCenter) bool {
+	return i.Spec.MQTT != nil && i.Spec.MQTT.Persistence != nil
+}
+
+func thermoCenterMQTTName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-mqtt"
+}
+
+func thermoCenterMQTTDataName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-mqtt-data"
+}
+
+// reconcileMQTT generates credentials, configuration and the data volume of the built-in broker
+func (r *ThermoCenterReconciler) reconcileMQTT(i *kojedzinv1alpha1.ThermoCenter) error {
+	if i.Spec.ExternalMQTT != nil {
+		return nil
+	}
+
+	if err := r.reconcileMQTTSecret(i); err != nil {
+		return err
+	}
+
+	if err := r.reconcileMQTTConfigMap(i); err != nil {
+		return err
+	}
+
+	if mqttPersistent(i) {
+		return r.reconcileMQTTVolume(i)
+	}
+
+	return nil
+}
+
+// reconcileMQTTSecret generates credentials once, the password file from them,
+// and the bridge configuration holding upstream credentials
+func (r *ThermoCenterReconciler) reconcileMQTTSecret(i *kojedzinv1alpha1.ThermoCenter) error {
+	secret := &v1.Secret{}
+	found := true
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterMQTTName(i)}, secret)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		found = false
+
+		secret.ObjectMeta = metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      thermoCenterMQTTName(i),
+		}
+
+		if err = controllerutil.SetControllerReference(i, secret, r.Scheme); err != nil {
+			return err
+		}
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+
+	changed := !found
+
+	if len(secret.Data[sMQTTUSERNAME]) == 0 || len(secret.Data[sMQTTPASSWORD]) == 0 {
+		secret.Data[sMQTTUSERNAME] = []byte(mqttUsername)
+		secret.Data[sMQTTPASSWORD] = []byte(r.randomString(32))
+		delete(secret.Data, sMQTTPASSWD)
+	}
+
+	if len(secret.Data[sMQTTPASSWD]) == 0 {
+		secret.Data[sMQTTPASSWD] = []byte(r.mosquittoPasswd(string(secret.Data[sMQTTUSERNAME]), string(secret.Data[sMQTTPASSWORD])))
+		changed = true
+	}
+
+	bridgeConfig, err := r.mqttBridgeConfig(i)
+	if err != nil {
+		return err
+	}
+
+	if bridgeConfig == "" {
+		if _, ok := secret.Data[sMQTTBRIDGE]; ok {
+			delete(secret.Data, sMQTTBRIDGE)
+			changed = true
+		}
+	} else if string(secret.Data[sMQTTBRIDGE]) != bridgeConfig {
+		secret.Data[sMQTTBRIDGE] = []byte(bridgeConfig)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if found {
+		return r.Update(context.TODO(), secret)
+	}
+
+	return r.Create(context.TODO(), secret)
+}
+
+// mosquittoPasswd renders a mosquitto password file entry, as mosquitto_passwd does
+func (r *ThermoCenterReconciler) mosquittoPasswd(username string, password string) string {
+	salt := r.randomBytes(12)
+
+	h := sha512.New()
+	h.Write([]byte(password))
+	h.Write(salt)
+
+	return fmt.Sprintf("%s:$6$%s$%s\n", username, base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
+// reconcileMQTTConfigMap generates mosquitto.conf
+func (r *ThermoCenterReconciler) reconcileMQTTConfigMap(i *kojedzinv1alpha1.ThermoCenter) error {
+	configMap := &v1.ConfigMap{}
+	found := true
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterMQTTName(i)}, configMap)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		found = false
+
+		configMap.ObjectMeta = metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      thermoCenterMQTTName(i),
+		}
+
+		if err = controllerutil.SetControllerReference(i, configMap, r.Scheme); err != nil {
+			return err
+		}
+	}
+
+	configMap.Data = map[string]string{
+		"mosquitto.conf": mqttConfig(i),
+	}
+
+	if found {
+		return r.Update(context.TODO(), configMap)
+	}
+
+	return r.Create(context.TODO(), configMap)
+}
+
+// mqttConfig renders mosquitto.conf of the built-in broker
+func mqttConfig(i *kojedzinv1alpha1.ThermoCenter) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "allow_anonymous false\n")
+	fmt.Fprintf(&b, "password_file /mosquitto/secret/%s\n", sMQTTPASSWD)
+	fmt.Fprintf(&b, "log_dest stdout\n")
+
+	if mqttPersistent(i) {
+		fmt.Fprintf(&b, "persistence true\n")
+		fmt.Fprintf(&b, "persistence_location /mosquitto/data/\n")
+		fmt.Fprintf(&b, "autosave_interval 60\n")
+	} else {
+		fmt.Fprintf(&b, "persistence false\n")
+	}
+
+	fmt.Fprintf(&b, "\nlistener %d\n", kojedzinv1alpha1.DefaultMQTTPort)
+
+	if mqttWebSockets(i) {
+		fmt.Fprintf(&b, "\nlistener %d\n", mqttWebSocketsPort)
+		fmt.Fprintf(&b, "protocol websockets\n")
+	}
+
+	// The bridge configuration is kept in the Secret, as it contains credentials
+	if mqttBridge(i) != nil {
+		fmt.Fprintf(&b, "\ninclude_dir /mosquitto/secret\n")
+	}
+
+	return b.String()
+}
+
+// mqttBridgeConfig renders the bridge section of mosquitto configuration
+func (r *ThermoCenterReconciler) mqttBridgeConfig(i *kojedzinv1alpha1.ThermoCenter) (string, error) {
+	bridge := mqttBridge(i)
+	if bridge == nil {
+		return "", nil
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "connection upstream\n")
+	fmt.Fprintf(&b, "address %s\n", bridge.Address)
+	fmt.Fprintf(&b, "remote_clientid thermo-center-%s-%s\n", i.Namespace, i.Name)
+	fmt.Fprintf(&b, "cleansession false\n")
+
+	if bridge.CredentialsSecretRef != nil {
+		secret := &v1.Secret{}
+		if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: bridge.CredentialsSecretRef.Name}, secret); err != nil {
+			return "", err
+		}
+
+		for _, key := range []string{"username", "password"} {
+			value := string(secret.Data[key])
+			if strings.ContainsAny(value, "\r\n") {
+				return "", fmt.Errorf("bridge credential %s of Secret %s contains a line break", key, secret.Name)
+			}
+			if value != "" {
+				fmt.Fprintf(&b, "remote_%s %s\n", key, value)
+			}
+		}
+	}
+
+	if bridge.TLS != nil {
+		if bridge.TLS.CASecretRef != nil {
+			fmt.Fprintf(&b, "bridge_cafile /mosquitto/bridge-ca/ca.crt\n")
+		} else {
+			fmt.Fprintf(&b, "bridge_cafile /etc/ssl/certs/ca-certificates.crt\n")
+		}
+		if bridge.TLS.ClientCertificateSecretRef != nil {
+			fmt.Fprintf(&b, "bridge_certfile /mosquitto/bridge-client/%s\n", v1.TLSCertKey)
+			fmt.Fprintf(&b, "bridge_keyfile /mosquitto/bridge-client/%s\n", v1.TLSPrivateKeyKey)
+		}
+	}
+
+	for _, topic := range bridge.Topics {
+		direction := topic.Direction
+		if direction == "" {
+			direction = kojedzinv1alpha1.BridgeDirectionOut
+		}
+
+		fmt.Fprintf(&b, "topic %s %s %d\n", topic.Pattern, direction, topic.QoS)
+	}
+
+	return b.String(), nil
+}
+
+// reconcileMQTTVolume creates the data volume once. It is kept when persistence is disabled.
+func (r *ThermoCenterReconciler) reconcileMQTTVolume(i *kojedzinv1alpha1.ThermoCenter) error {
+	pvc := &v1.PersistentVolumeClaim{}
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterMQTTDataName(i)}, pvc)
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	storageSize := mqttDefaultStorageSize
+	if i.Spec.MQTT.Persistence.StorageSize != nil {
+		storageSize = *i.Spec.MQTT.Persistence.StorageSize
+	}
+
+	pvc = &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      thermoCenterMQTTDataName(i),
+			Labels:    labelsForComponent(i, "mqtt"),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: i.Spec.MQTT.Persistence.StorageClassName,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: storageSize,
+				},
+			},
+		},
+	}
+
+	if err = controllerutil.SetControllerReference(i, pvc, r.Scheme); err != nil {
+		return err
+	}
+
+	return r.Create(context.TODO(), pvc)
 }
@@ -0,0 +1,608 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getMQTTObject gets the Secret or ConfigMap of the built-in broker
+func getMQTTObject(t *testing.T, r *ThermoCenterReconciler, obj client.Object) {
+	t.Helper()
+
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "tc-mqtt"}, obj); err != nil {
+		t.Fatalf("mqtt object not found: %v", err)
+	}
+}
+
+// containerMounts returns volume mounts of a container by name
+func containerMounts(c v1.Container) map[string]v1.VolumeMount {
+	mounts := make(map[string]v1.VolumeMount)
+	for _, m := range c.VolumeMounts {
+		mounts[m.Name] = m
+	}
+
+	return mounts
+}
+
+// podVolumes returns volumes of a pod by name
+func podVolumes(ps *v1.PodSpec) map[string]v1.Volume {
+	volumes := make(map[string]v1.Volume)
+	for _, v := range ps.Volumes {
+		volumes[v.Name] = v
+	}
+
+	return volumes
+}
+
+func TestMQTTConfig(t *testing.T) {
+	i := newTestThermoCenter()
+
+	config := mqttConfig(i)
+	for _, line := range []string{
+		"allow_anonymous false",
+		"password_file /mosquitto/secret/passwd",
+		"persistence false",
+		"listener 1883",
+	} {
+		if !strings.Contains(config, line+"\n") {
+			t.Errorf("config misses %q:\n%s", line, config)
+		}
+	}
+	for _, line := range []string{"persistence_location", "include_dir"} {
+		if strings.Contains(config, line) {
+			t.Errorf("config contains %q:\n%s", line, config)
+		}
+	}
+
+	i.Spec.MQTT = &kojedzinv1alpha1.MQTT{Persistence: &kojedzinv1alpha1.MQTTPersistence{}}
+
+	config = mqttConfig(i)
+	for _, line := range []string{
+		"persistence true",
+		"persistence_location /mosquitto/data/",
+	} {
+		if !strings.Contains(config, line+"\n") {
+			t.Errorf("config misses %q:\n%s", line, config)
+		}
+	}
+}
+
+func TestMosquittoPasswd(t *testing.T) {
+	r := newTestReconciler(nil)
+
+	entry := r.mosquittoPasswd("user", "secret")
+	if !strings.HasSuffix(entry, "\n") {
+		t.Errorf("entry %q is not terminated", entry)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(entry, "\n"), "$")
+	if len(fields) != 4 || fields[0] != "user:" || fields[1] != "6" {
+		t.Fatalf("entry %q is not in mosquitto_passwd format", entry)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil || len(salt) != 12 {
+		t.Fatalf("salt %q is invalid: %v", fields[2], err)
+	}
+
+	h := sha512.New()
+	h.Write([]byte("secret"))
+	h.Write(salt)
+	if want := base64.StdEncoding.EncodeToString(h.Sum(nil)); fields[3] != want {
+		t.Errorf("hash = %q, want %q", fields[3], want)
+	}
+
+	if other := r.mosquittoPasswd("user", "secret"); other == entry {
+		t.Error("salt is reused between entries")
+	}
+}
+
+func TestReconcileMQTTSecret(t *testing.T) {
+	i := newTestThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	if err := r.reconcileMQTT(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret := &v1.Secret{}
+	getMQTTObject(t, r, secret)
+
+	username := string(secret.Data[sMQTTUSERNAME])
+	password := string(secret.Data[sMQTTPASSWORD])
+	passwd := string(secret.Data[sMQTTPASSWD])
+	if username != mqttUsername || len(password) < 32 {
+		t.Errorf("username %q, password %q", username, password)
+	}
+	if !strings.HasPrefix(passwd, mqttUsername+":$6$") {
+		t.Errorf("passwd = %q", passwd)
+	}
+	if _, ok := secret.Data[sMQTTBRIDGE]; ok {
+		t.Error("bridge configuration rendered without bridge")
+	}
+
+	configMap := &v1.ConfigMap{}
+	getMQTTObject(t, r, configMap)
+	if configMap.Data["mosquitto.conf"] != mqttConfig(i) {
+		t.Errorf("mosquitto.conf = %q", configMap.Data["mosquitto.conf"])
+	}
+
+	// Credentials are kept
+	if err := r.reconcileMQTT(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret = &v1.Secret{}
+	getMQTTObject(t, r, secret)
+	if string(secret.Data[sMQTTPASSWORD]) != password || string(secret.Data[sMQTTPASSWD]) != passwd {
+		t.Error("credentials regenerated")
+	}
+
+	// A lost password regenerates the password file
+	delete(secret.Data, sMQTTPASSWORD)
+	if err := r.Update(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileMQTT(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret = &v1.Secret{}
+	getMQTTObject(t, r, secret)
+	if string(secret.Data[sMQTTPASSWORD]) == password || string(secret.Data[sMQTTPASSWD]) == passwd {
+		t.Error("credentials not regenerated")
+	}
+	if !strings.HasPrefix(string(secret.Data[sMQTTPASSWD]), mqttUsername+":$6$") {
+		t.Errorf("passwd = %q", secret.Data[sMQTTPASSWD])
+	}
+}
+
+func TestReconcileMQTTVolume(t *testing.T) {
+	storageSize := resource.MustParse("1Gi")
+
+	tests := []struct {
+		name        string
+		persistence *kojedzinv1alpha1.MQTTPersistence
+		want        *resource.Quantity
+	}{
+		{"disabled", nil, nil},
+		{"default size", &kojedzinv1alpha1.MQTTPersistence{}, &mqttDefaultStorageSize},
+		{"custom size", &kojedzinv1alpha1.MQTTPersistence{StorageSize: &storageSize, StorageClassName: stringPtr("fast")}, &storageSize},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			i.Spec.MQTT = &kojedzinv1alpha1.MQTT{Persistence: test.persistence}
+			r := newTestReconciler(nil, i)
+
+			if err := r.reconcileMQTT(i); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			pvc := &v1.PersistentVolumeClaim{}
+			err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: "tc-mqtt-data"}, pvc)
+			if test.want == nil {
+				if err == nil {
+					t.Error("data volume created without persistence")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("data volume not created: %v", err)
+			}
+
+			if size := pvc.Spec.Resources.Requests[v1.ResourceStorage]; size.Cmp(*test.want) != 0 {
+				t.Errorf("storage size = %s, want %s", size.String(), test.want.String())
+			}
+			if class := test.persistence.StorageClassName; class != nil && (pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != *class) {
+				t.Errorf("storage class = %v, want %s", pvc.Spec.StorageClassName, *class)
+			}
+
+			ps := r.getPodSpec(i, r.mqtt)
+			if ps == nil {
+				t.Fatal("broker not deployed")
+			}
+			volume, ok := podVolumes(ps)["data"]
+			if !ok || volume.PersistentVolumeClaim == nil || volume.PersistentVolumeClaim.ClaimName != "tc-mqtt-data" {
+				t.Errorf("data volume = %+v", volume)
+			}
+			if mount := containerMounts(ps.Containers[0])["data"]; mount.MountPath != "/mosquitto/data" {
+				t.Errorf("data mount = %+v", mount)
+			}
+		})
+	}
+}
+
+func TestMQTTPodSpec(t *testing.T) {
+	i := newTestThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	ps := r.getPodSpec(i, r.mqtt)
+	if ps == nil {
+		t.Fatal("broker not deployed")
+	}
+
+	mounts := containerMounts(ps.Containers[0])
+	for name, path := range map[string]string{
+		"config": "/mosquitto/config",
+		"secret": "/mosquitto/secret",
+	} {
+		if mount, ok := mounts[name]; !ok || mount.MountPath != path || !mount.ReadOnly {
+			t.Errorf("mount %s = %+v", name, mount)
+		}
+	}
+	if _, ok := mounts["data"]; ok {
+		t.Error("data volume mounted without persistence")
+	}
+
+	secret := podVolumes(ps)["secret"].Secret
+	if secret == nil || secret.SecretName != "tc-mqtt" || len(secret.Items) != 1 || secret.Items[0].Key != sMQTTPASSWD {
+		t.Errorf("secret volume = %+v", secret)
+	}
+
+	// Clients read credentials from the Secret
+	ws := r.getPodSpec(i, r.ws)
+	if ws == nil {
+		t.Fatal("ws not deployed")
+	}
+	env := containerEnv(ws.Containers[0])
+	for name, key := range map[string]string{
+		"MQTT_USERNAME": sMQTTUSERNAME,
+		"MQTT_PASSWORD": sMQTTPASSWORD,
+	} {
+		ref := env[name].ValueFrom
+		if ref == nil || ref.SecretKeyRef == nil || ref.SecretKeyRef.Name != "tc-mqtt" || ref.SecretKeyRef.Key != key {
+			t.Errorf("%s = %+v", name, env[name])
+		}
+	}
+}
+
+func TestExternalMQTTEnvironment(t *testing.T) {
+	caRef := &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "broker-ca"}, Key: "bundle.pem"}
+	clientRef := &v1.LocalObjectReference{Name: "broker-client"}
+
+	tests := []struct {
+		name string
+		port int
+		tls  *kojedzinv1alpha1.MQTTTLS
+		want map[string]string
+	}{
+		{"plain", 0, nil, map[string]string{
+			"MQTT_HOST": "broker.example.com",
+			"MQTT_PORT": "1883",
+		}},
+		{"custom port", 1884, nil, map[string]string{
+			"MQTT_PORT": "1884",
+		}},
+		{"system CA", 0, &kojedzinv1alpha1.MQTTTLS{}, map[string]string{
+			"MQTT_PORT": "8883",
+			"MQTT_TLS":  "1",
+		}},
+		{"CA and client certificate", 0, &kojedzinv1alpha1.MQTTTLS{CASecretRef: caRef, ClientCertificateSecretRef: clientRef}, map[string]string{
+			"MQTT_PORT":     "8883",
+			"MQTT_TLS":      "1",
+			"MQTT_TLS_CA":   "/etc/thermo-center/mqtt-ca/ca.crt",
+			"MQTT_TLS_CERT": "/etc/thermo-center/mqtt-client/tls.crt",
+			"MQTT_TLS_KEY":  "/etc/thermo-center/mqtt-client/tls.key",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			i.Spec.ExternalMQTT = &kojedzinv1alpha1.ExternalMQTT{
+				Hostname:          "broker.example.com",
+				Port:              test.port,
+				UsernameSecretRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "broker"}, Key: "user"},
+				PasswordSecretRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "broker"}, Key: "pass"},
+				TLS:               test.tls,
+			}
+			r := newTestReconciler(nil, i)
+
+			ps := r.getPodSpec(i, r.ws)
+			if ps == nil {
+				t.Fatal("ws not deployed")
+			}
+
+			env := containerEnv(ps.Containers[0])
+			for name, value := range test.want {
+				if env[name].Value != value {
+					t.Errorf("%s = %q, want %q", name, env[name].Value, value)
+				}
+			}
+			for _, name := range []string{"MQTT_TLS", "MQTT_TLS_CA", "MQTT_TLS_CERT", "MQTT_TLS_KEY"} {
+				if _, ok := test.want[name]; !ok {
+					if _, ok := env[name]; ok {
+						t.Errorf("%s is set", name)
+					}
+				}
+			}
+
+			for name, key := range map[string]string{
+				"MQTT_USERNAME": "user",
+				"MQTT_PASSWORD": "pass",
+			} {
+				ref := env[name].ValueFrom
+				if ref == nil || ref.SecretKeyRef == nil || ref.SecretKeyRef.Name != "broker" || ref.SecretKeyRef.Key != key {
+					t.Errorf("%s = %+v", name, env[name])
+				}
+			}
+
+			volumes := podVolumes(ps)
+			mounts := containerMounts(ps.Containers[0])
+
+			ca, hasCA := volumes["mqtt-ca"]
+			if hasCA != (test.want["MQTT_TLS_CA"] != "") {
+				t.Errorf("CA volume = %+v", ca)
+			}
+			if hasCA {
+				items := ca.Secret.Items
+				if ca.Secret.SecretName != "broker-ca" || len(items) != 1 || items[0].Key != "bundle.pem" || items[0].Path != "ca.crt" {
+					t.Errorf("CA volume = %+v", ca.Secret)
+				}
+				if mount := mounts["mqtt-ca"]; mount.MountPath != "/etc/thermo-center/mqtt-ca" || !mount.ReadOnly {
+					t.Errorf("CA mount = %+v", mount)
+				}
+			}
+
+			cert, hasCert := volumes["mqtt-client"]
+			if hasCert != (test.want["MQTT_TLS_CERT"] != "") {
+				t.Errorf("client certificate volume = %+v", cert)
+			}
+			if hasCert {
+				if cert.Secret.SecretName != "broker-client" {
+					t.Errorf("client certificate volume = %+v", cert.Secret)
+				}
+				if mount := mounts["mqtt-client"]; mount.MountPath != "/etc/thermo-center/mqtt-client" || !mount.ReadOnly {
+					t.Errorf("client certificate mount = %+v", mount)
+				}
+			}
+		})
+	}
+}
+
+func TestExternalMQTTSkipsBroker(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Spec.ExternalMQTT = &kojedzinv1alpha1.ExternalMQTT{Hostname: "broker.example.com"}
+	r := newTestReconciler(nil, i)
+
+	if err := r.reconcileMQTT(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: "tc-mqtt"}, &v1.Secret{}); err == nil {
+		t.Error("credentials generated for an external broker")
+	}
+	if ps := r.getPodSpec(i, r.mqtt); ps != nil {
+		t.Error("broker deployed with an external broker")
+	}
+
+	// Anonymous access without credential references
+	ps := r.getPodSpec(i, r.ws)
+	if ps == nil {
+		t.Fatal("ws not deployed")
+	}
+	env := containerEnv(ps.Containers[0])
+	for _, name := range []string{"MQTT_USERNAME", "MQTT_PASSWORD"} {
+		if _, ok := env[name]; ok {
+			t.Errorf("%s is set", name)
+		}
+	}
+}
+
+// newBridgeThermoCenter returns an instance bridging its built-in broker upstream
+func newBridgeThermoCenter() *kojedzinv1alpha1.ThermoCenter {
+	i := newTestThermoCenter()
+	i.Spec.MQTT = &kojedzinv1alpha1.MQTT{
+		Bridge: &kojedzinv1alpha1.MQTTBridge{
+			Address:              "upstream.example.com:8883",
+			CredentialsSecretRef: &v1.LocalObjectReference{Name: "upstream"},
+			Topics: []kojedzinv1alpha1.MQTTBridgeTopic{
+				{Pattern: "thermo-center/#"},
+				{Pattern: "commands/+", Direction: kojedzinv1alpha1.BridgeDirectionIn, QoS: 1},
+			},
+		},
+	}
+
+	return i
+}
+
+func TestMQTTBridgeConfig(t *testing.T) {
+	caRef := &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "upstream-ca"}, Key: "ca.pem"}
+	clientRef := &v1.LocalObjectReference{Name: "upstream-client"}
+
+	tests := []struct {
+		name    string
+		tls     *kojedzinv1alpha1.MQTTTLS
+		want    []string
+		without []string
+	}{
+		{"plain", nil, nil, []string{"bridge_cafile", "bridge_certfile"}},
+		{"system CA", &kojedzinv1alpha1.MQTTTLS{}, []string{
+			"bridge_cafile /etc/ssl/certs/ca-certificates.crt",
+		}, []string{"bridge_certfile"}},
+		{"CA and client certificate", &kojedzinv1alpha1.MQTTTLS{CASecretRef: caRef, ClientCertificateSecretRef: clientRef}, []string{
+			"bridge_cafile /mosquitto/bridge-ca/ca.crt",
+			"bridge_certfile /mosquitto/bridge-client/tls.crt",
+			"bridge_keyfile /mosquitto/bridge-client/tls.key",
+		}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newBridgeThermoCenter()
+			i.Spec.MQTT.Bridge.TLS = test.tls
+			r := newTestReconciler(nil, i, &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: i.Namespace, Name: "upstream"},
+				Data: map[string][]byte{
+					"username": []byte("bridge"),
+					"password": []byte("s3cret"),
+				},
+			})
+
+			config, err := r.mqttBridgeConfig(i)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.HasPrefix(config, "connection upstream\n") {
+				t.Errorf("config does not start with the connection:\n%s", config)
+			}
+			for _, line := range append([]string{
+				"address upstream.example.com:8883",
+				"remote_clientid thermo-center-default-tc",
+				"cleansession false",
+				"remote_username bridge",
+				"remote_password s3cret",
+				"topic thermo-center/# out 0",
+				"topic commands/+ in 1",
+			}, test.want...) {
+				if !strings.Contains(config, line+"\n") {
+					t.Errorf("config misses %q:\n%s", line, config)
+				}
+			}
+			for _, option := range test.without {
+				if strings.Contains(config, option) {
+					t.Errorf("config contains %q:\n%s", option, config)
+				}
+			}
+		})
+	}
+}
+
+func TestMQTTBridgeConfigCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		want    []string
+		without []string
+		err     bool
+	}{
+		{"username only", map[string][]byte{"username": []byte("bridge")}, []string{"remote_username bridge"}, []string{"remote_password"}, false},
+		{"line break", map[string][]byte{"username": []byte("bridge"), "password": []byte("x\nconnection evil")}, nil, nil, true},
+		{"missing Secret", nil, nil, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newBridgeThermoCenter()
+			objs := []client.Object{i}
+			if test.data != nil {
+				objs = append(objs, &v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: i.Namespace, Name: "upstream"},
+					Data:       test.data,
+				})
+			}
+			r := newTestReconciler(nil, objs...)
+
+			config, err := r.mqttBridgeConfig(i)
+			if (err != nil) != test.err {
+				t.Fatalf("error = %v, want error %v", err, test.err)
+			}
+			for _, line := range test.want {
+				if !strings.Contains(config, line+"\n") {
+					t.Errorf("config misses %q:\n%s", line, config)
+				}
+			}
+			for _, option := range test.without {
+				if strings.Contains(config, option) {
+					t.Errorf("config contains %q:\n%s", option, config)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileMQTTBridge(t *testing.T) {
+	i := newBridgeThermoCenter()
+	i.Spec.MQTT.Bridge.CredentialsSecretRef = nil
+	i.Spec.MQTT.Bridge.TLS = &kojedzinv1alpha1.MQTTTLS{
+		CASecretRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "upstream-ca"}, Key: "ca.pem"},
+	}
+	r := newTestReconciler(nil, i)
+
+	if err := r.reconcileMQTT(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The bridge configuration is kept in the Secret, included from mosquitto.conf
+	secret := &v1.Secret{}
+	getMQTTObject(t, r, secret)
+	bridge := string(secret.Data[sMQTTBRIDGE])
+	if !strings.Contains(bridge, "address upstream.example.com:8883\n") {
+		t.Errorf("bridge.conf = %q", bridge)
+	}
+
+	configMap := &v1.ConfigMap{}
+	getMQTTObject(t, r, configMap)
+	if !strings.Contains(configMap.Data["mosquitto.conf"], "include_dir /mosquitto/secret\n") {
+		t.Errorf("mosquitto.conf does not include the bridge:\n%s", configMap.Data["mosquitto.conf"])
+	}
+
+	ps := r.getPodSpec(i, r.mqtt)
+	if ps == nil {
+		t.Fatal("broker not deployed")
+	}
+	volumes := podVolumes(ps)
+	if items := volumes["secret"].Secret.Items; len(items) != 2 || items[1].Key != sMQTTBRIDGE {
+		t.Errorf("secret items = %+v", items)
+	}
+	if ca := volumes["bridge-ca"].Secret; ca == nil || ca.SecretName != "upstream-ca" {
+		t.Errorf("bridge CA volume = %+v", volumes["bridge-ca"])
+	}
+	if mount := containerMounts(ps.Containers[0])["bridge-ca"]; mount.MountPath != "/mosquitto/bridge-ca" {
+		t.Errorf("bridge CA mount = %+v", mount)
+	}
+
+	// Removing the bridge removes its configuration
+	i.Spec.MQTT.Bridge = nil
+	if err := r.reconcileMQTT(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret = &v1.Secret{}
+	getMQTTObject(t, r, secret)
+	if _, ok := secret.Data[sMQTTBRIDGE]; ok {
+		t.Error("bridge configuration kept after removing the bridge")
+	}
+
+	configMap = &v1.ConfigMap{}
+	getMQTTObject(t, r, configMap)
+	if strings.Contains(configMap.Data["mosquitto.conf"], "include_dir") {
+		t.Errorf("mosquitto.conf still includes the bridge:\n%s", configMap.Data["mosquitto.conf"])
+	}
+}
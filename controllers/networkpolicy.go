@@ -36,7 +36,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;delete
 
 func (r *ThermoCenterReconciler) reconcileNetworkPolicy(i *kojedzinv1alpha1.ThermoCenter) error {
 	policyName := i.Name + "-default"
@@ -139,6 +139,84 @@ func (r *ThermoCenterReconciler) reconcileNetworkPolicy(i *kojedzinv1alpha1.Ther
 		},
 	}
 
+	if found {
+		err = r.Update(context.TODO(), policy)
+	} else {
+		err = r.Create(context.TODO(), policy)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return r.reconcileMQTTNetworkPolicy(i)
+}
+
+// reconcileMQTTNetworkPolicy allows clients outside the cluster to connect to an exposed broker
+func (r *ThermoCenterReconciler) reconcileMQTTNetworkPolicy(i *kojedzinv1alpha1.ThermoCenter) error {
+	policyName := i.Name + "-mqtt"
+	policy := &networking.NetworkPolicy{}
+	found := true
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: policyName}, policy)
+
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		found = false
+
+		policy.ObjectMeta = metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      policyName,
+		}
+
+		if err = controllerutil.SetControllerReference(i, policy, r.Scheme); err != nil {
+			return err
+		}
+	}
+
+	// Without allowed networks, no clients are admitted from outside the cluster
+	expose := mqttExpose(i)
+	if expose == nil || len(expose.AllowedCIDRs) == 0 {
+		if found {
+			return r.Delete(context.TODO(), policy)
+		}
+
+		return nil
+	}
+
+	mqttPort := intstr.FromInt(kojedzinv1alpha1.DefaultMQTTPort)
+
+	var from []networking.NetworkPolicyPeer
+	for _, cidr := range expose.AllowedCIDRs {
+		from = append(from, networking.NetworkPolicyPeer{
+			IPBlock: &networking.IPBlock{
+				CIDR: cidr,
+			},
+		})
+	}
+
+	policy.Spec = networking.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{
+			MatchLabels: labelsForComponent(i, r.mqtt.component()),
+		},
+		PolicyTypes: []networking.PolicyType{
+			networking.PolicyTypeIngress,
+		},
+		Ingress: []networking.NetworkPolicyIngressRule{
+			{
+				Ports: []networking.NetworkPolicyPort{
+					{
+						Port: &mqttPort,
+					},
+				},
+				From: from,
+			},
+		},
+	}
+
 	if found {
 		return r.Update(context.TODO(), policy)
 	}
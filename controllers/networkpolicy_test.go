@@ -0,0 +1,83 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReconcileMQTTNetworkPolicy(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Spec.MQTT = &kojedzinv1alpha1.MQTT{
+		Expose: &kojedzinv1alpha1.MQTTExpose{
+			ServiceType:  v1.ServiceTypeNodePort,
+			AllowedCIDRs: []string{"192.168.1.0/24", "10.0.0.0/8"},
+		},
+	}
+	r := newTestReconciler(nil, i)
+
+	get := func() (*networking.NetworkPolicy, error) {
+		policy := &networking.NetworkPolicy{}
+
+		return policy, r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: i.Name + "-mqtt"}, policy)
+	}
+
+	if err := r.reconcileMQTTNetworkPolicy(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policy, err := get()
+	if err != nil {
+		t.Fatalf("policy not created: %v", err)
+	}
+
+	if len(policy.Spec.Ingress) != 1 {
+		t.Fatalf("policy has %d ingress rules", len(policy.Spec.Ingress))
+	}
+	from := policy.Spec.Ingress[0].From
+	if len(from) != 2 || from[0].IPBlock.CIDR != "192.168.1.0/24" || from[1].IPBlock.CIDR != "10.0.0.0/8" {
+		t.Errorf("policy admits %+v", from)
+	}
+	if selector := policy.Spec.PodSelector.MatchLabels; selector["thermo-center-component"] != r.mqtt.component() {
+		t.Errorf("policy selects %v", selector)
+	}
+
+	// Without allowed networks, nothing is admitted, rather than everything
+	i.Spec.MQTT.Expose.AllowedCIDRs = nil
+	if err = r.reconcileMQTTNetworkPolicy(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err = get(); !errors.IsNotFound(err) {
+		t.Errorf("policy not deleted without allowed networks: %v", err)
+	}
+}
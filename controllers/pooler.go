@@ -0,0 +1,291 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=delete
+
+// Annotation on pooler pods, restarting them on configuration changes
+const poolerConfigVersionAnnotation = "thermo-center-pooler-config-version"
+
+var poolerDeployment = &kojedzinv1alpha1.Deployment{
+	Image:    "edoburu/pgbouncer:1.15.0",
+	Replicas: replicas(1),
+}
+
+type poolerReconciler struct {
+	defaultDeploymentReconciler
+}
+
+func (p *poolerReconciler) component() string {
+	return "pooler"
+}
+
+func (p *poolerReconciler) getDeployment(i *kojedzinv1alpha1.ThermoCenter) *kojedzinv1alpha1.Deployment {
+	if i.Spec.Pooler == nil {
+		return poolerDeployment
+	}
+
+	return deploymentWithDefaults(&i.Spec.Pooler.Deployment, poolerDeployment)
+}
+
+func (p *poolerReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) *v1.PodSpec {
+	if !poolerActive(i) {
+		return nil
+	}
+
+	// Resource requirements
+	ps.Containers[0].Resources = v1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("10m"),
+			v1.ResourceMemory: resource.MustParse("8Mi"),
+		},
+	}
+
+	// Override uid/gid
+	runAsUser := int64(70)
+	runAsGroup := int64(70)
+
+	ps.SecurityContext.RunAsUser = &runAsUser
+	ps.SecurityContext.RunAsGroup = &runAsGroup
+
+	// Command, bypassing configuration generated by the image
+	ps.Containers[0].Command = []string{"pgbouncer", "/etc/pgbouncer/pgbouncer.ini"}
+
+	ps.Volumes = append(ps.Volumes, v1.Volume{
+		Name: "config",
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: thermoCenterPoolerSecretName(i),
+			},
+		},
+	})
+	ps.Containers[0].VolumeMounts = append(ps.Containers[0].VolumeMounts, v1.VolumeMount{
+		Name:      "config",
+		MountPath: "/etc/pgbouncer",
+		ReadOnly:  true,
+	})
+
+	// Server side TLS is configured in pgbouncer.ini, this mounts the CA bundle
+	setDatabaseTLS(i, ps, &ps.Containers[0])
+
+	// Ports
+	ps.Containers[0].Ports = []v1.ContainerPort{{
+		Name:          p.component(),
+		ContainerPort: kojedzinv1alpha1.DefaultDatabasePort,
+	}}
+
+	ps.Containers[0].ReadinessProbe = &v1.Probe{
+		Handler: v1.Handler{
+			TCPSocket: &v1.TCPSocketAction{
+				Port: intstr.FromInt(kojedzinv1alpha1.DefaultDatabasePort),
+			},
+		},
+	}
+
+	return ps
+}
+
+func (p *poolerReconciler) customizeService(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, service *v1.Service) *v1.Service {
+	if !poolerActive(i) {
+		return nil
+	}
+
+	service.Spec.Ports = []v1.ServicePort{{
+		Name: p.component(),
+		Port: kojedzinv1alpha1.DefaultDatabasePort,
+	}}
+
+	return service
+}
+
+func (p *poolerReconciler) customizeDeployment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, deployment *appsv1.Deployment) error {
+	// Restart the pooler when its configuration changes
+	secret := &v1.Secret{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterPoolerSecretName(i)}, secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	deployment.Spec.Template.Annotations[poolerConfigVersionAnnotation] = secret.ResourceVersion
+
+	return nil
+}
+
+// setDatabaseEnvironment points database connections of a pod to the pooler,
+// or configures TLS for direct connections
+func (p *poolerReconciler) setDatabaseEnvironment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) {
+	if !poolerActive(i) {
+		setDatabaseTLS(i, ps, &ps.Containers[0])
+
+		return
+	}
+
+	ps.Containers[0].Env = append(ps.Containers[0].Env,
+		v1.EnvVar{
+			Name:  sDBHOST,
+			Value: thermoCenterServiceName(i, p),
+		},
+		v1.EnvVar{
+			Name:  sDBPORT,
+			Value: strconv.Itoa(kojedzinv1alpha1.DefaultDatabasePort),
+		},
+	)
+}
+
+// unsetDatabaseEnvironment reverts setDatabaseEnvironment, for pods connecting to the database directly
+func (p *poolerReconciler) unsetDatabaseEnvironment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) {
+	if !poolerActive(i) {
+		return
+	}
+
+	env := ps.Containers[0].Env[:0]
+	for _, e := range ps.Containers[0].Env {
+		if e.Name != sDBHOST && e.Name != sDBPORT {
+			env = append(env, e)
+		}
+	}
+	ps.Containers[0].Env = env
+
+	setDatabaseTLS(i, ps, &ps.Containers[0])
+}
+
+// poolerActive reports whether api and grpcserver connect through the pooler
+func poolerActive(i *kojedzinv1alpha1.ThermoCenter) bool {
+	return i.Spec.Pooler != nil
+}
+
+func thermoCenterPoolerSecretName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-pooler"
+}
+
+// reconcilePoolerSecret generates PgBouncer configuration from database access parameters
+func (r *ThermoCenterReconciler) reconcilePoolerSecret(i *kojedzinv1alpha1.ThermoCenter) error {
+	secretName := thermoCenterPoolerSecretName(i)
+	secret := &v1.Secret{}
+	found := true
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: secretName}, secret)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		found = false
+	}
+
+	if !poolerActive(i) {
+		if found {
+			return r.Delete(context.TODO(), secret)
+		}
+
+		return nil
+	}
+
+	if !found {
+		secret.ObjectMeta = metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      secretName,
+		}
+
+		if err = controllerutil.SetControllerReference(i, secret, r.Scheme); err != nil {
+			return err
+		}
+	}
+
+	db, err := r.databaseParams(i)
+	if err != nil {
+		return err
+	}
+
+	secret.Data = map[string][]byte{
+		"pgbouncer.ini": []byte(poolerConfig(i, db)),
+		"userlist.txt":  []byte(fmt.Sprintf("%s %s\n", pgbouncerQuote(db.User), pgbouncerQuote(db.Password))),
+	}
+
+	if found {
+		return r.Update(context.TODO(), secret)
+	}
+
+	return r.Create(context.TODO(), secret)
+}
+
+// poolerConfig renders pgbouncer.ini forwarding all databases to the database server
+func poolerConfig(i *kojedzinv1alpha1.ThermoCenter, db *kojedzinv1alpha1.Database) string {
+	poolMode := i.Spec.Pooler.PoolMode
+	if poolMode == "" {
+		poolMode = kojedzinv1alpha1.PoolModeSession
+	}
+
+	poolSize := int32(kojedzinv1alpha1.DefaultPoolSize)
+	if i.Spec.Pooler.PoolSize != nil {
+		poolSize = *i.Spec.Pooler.PoolSize
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "[databases]\n")
+	fmt.Fprintf(&b, "* = host=%s port=%d\n", db.Host, db.Port)
+	fmt.Fprintf(&b, "\n[pgbouncer]\n")
+	fmt.Fprintf(&b, "listen_addr = 0.0.0.0\n")
+	fmt.Fprintf(&b, "listen_port = %d\n", kojedzinv1alpha1.DefaultDatabasePort)
+	fmt.Fprintf(&b, "unix_socket_dir =\n")
+	fmt.Fprintf(&b, "auth_type = md5\n")
+	fmt.Fprintf(&b, "auth_file = /etc/pgbouncer/userlist.txt\n")
+	fmt.Fprintf(&b, "pool_mode = %s\n", poolMode)
+	fmt.Fprintf(&b, "default_pool_size = %d\n", poolSize)
+	fmt.Fprintf(&b, "max_client_conn = 200\n")
+	fmt.Fprintf(&b, "ignore_startup_parameters = extra_float_digits\n")
+
+	if db.SSLMode != "" {
+		fmt.Fprintf(&b, "server_tls_sslmode = %s\n", db.SSLMode)
+	}
+	if db.CASecretRef != nil {
+		fmt.Fprintf(&b, "server_tls_ca_file = %s/ca.crt\n", databaseCAPath)
+	}
+
+	return b.String()
+}
+
+// pgbouncerQuote quotes a userlist.txt field
+func pgbouncerQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
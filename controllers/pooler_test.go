@@ -0,0 +1,292 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// failingClient fails Get calls with err
+type failingClient struct {
+	client.Client
+	err error
+}
+
+func (c failingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return c.err
+}
+
+func newPoolerThermoCenter() *kojedzinv1alpha1.ThermoCenter {
+	i := newTestThermoCenter()
+	i.Spec.Pooler = &kojedzinv1alpha1.Pooler{}
+
+	return i
+}
+
+func TestPoolerConfig(t *testing.T) {
+	db := &kojedzinv1alpha1.Database{Host: "postgres", Port: 5433}
+
+	tests := []struct {
+		name   string
+		pooler kojedzinv1alpha1.Pooler
+		db     kojedzinv1alpha1.Database
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "defaults",
+			db:     *db,
+			want:   []string{"* = host=postgres port=5433\n", "listen_port = 5432\n", "pool_mode = session\n", "default_pool_size = 10\n"},
+			absent: []string{"server_tls_sslmode", "server_tls_ca_file"},
+		},
+		{
+			name:   "transaction pooling",
+			pooler: kojedzinv1alpha1.Pooler{PoolMode: kojedzinv1alpha1.PoolModeTransaction, PoolSize: int32Ptr(25)},
+			db:     *db,
+			want:   []string{"pool_mode = transaction\n", "default_pool_size = 25\n"},
+		},
+		{
+			name: "server tls",
+			db: kojedzinv1alpha1.Database{
+				Host:        "postgres",
+				Port:        5432,
+				SSLMode:     "verify-full",
+				CASecretRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "ca"}, Key: "ca.crt"},
+			},
+			want: []string{"server_tls_sslmode = verify-full\n", "server_tls_ca_file = " + databaseCAPath + "/ca.crt\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			i.Spec.Pooler = &test.pooler
+
+			config := poolerConfig(i, &test.db)
+
+			if !strings.HasPrefix(config, "[databases]\n") {
+				t.Errorf("config does not start with the databases section:\n%s", config)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(config, want) {
+					t.Errorf("config lacks %q:\n%s", want, config)
+				}
+			}
+			for _, absent := range test.absent {
+				if strings.Contains(config, absent) {
+					t.Errorf("config contains %q:\n%s", absent, config)
+				}
+			}
+		})
+	}
+}
+
+func TestPgbouncerQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", `""`},
+		{"thermo", `"thermo"`},
+		{`pa"ss`, `"pa""ss"`},
+		{`""`, `""""""`},
+		{`with space\`, `"with space\"`},
+	}
+
+	for _, test := range tests {
+		if got := pgbouncerQuote(test.in); got != test.want {
+			t.Errorf("pgbouncerQuote(%q) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestPoolerDatabaseEnvironment(t *testing.T) {
+	tls := &kojedzinv1alpha1.Database{
+		Host:        "postgres",
+		SSLMode:     "require",
+		CASecretRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "ca"}, Key: "ca.crt"},
+	}
+
+	tests := []struct {
+		name     string
+		pooler   bool
+		database *kojedzinv1alpha1.Database
+		// environment after setDatabaseEnvironment
+		set map[string]string
+		// environment after unsetDatabaseEnvironment
+		unset map[string]string
+	}{
+		{
+			name:  "direct connections",
+			set:   map[string]string{},
+			unset: map[string]string{},
+		},
+		{
+			name:     "direct connections with tls",
+			database: tls,
+			set:      map[string]string{"PGSSLMODE": "require", "PGSSLROOTCERT": databaseCAPath + "/ca.crt"},
+			unset:    map[string]string{"PGSSLMODE": "require", "PGSSLROOTCERT": databaseCAPath + "/ca.crt"},
+		},
+		{
+			name:   "pooled connections",
+			pooler: true,
+			set:    map[string]string{sDBHOST: "tc-pooler", sDBPORT: "5432"},
+			unset:  map[string]string{},
+		},
+		{
+			name:     "pooled connections with tls",
+			pooler:   true,
+			database: tls,
+			set:      map[string]string{sDBHOST: "tc-pooler", sDBPORT: "5432"},
+			unset:    map[string]string{"PGSSLMODE": "require", "PGSSLROOTCERT": databaseCAPath + "/ca.crt"},
+		},
+	}
+
+	check := func(t *testing.T, step string, ps *v1.PodSpec, want map[string]string) {
+		t.Helper()
+
+		env := containerEnv(ps.Containers[0])
+		delete(env, "OTHER")
+		if len(env) != len(want) {
+			t.Errorf("%s: environment = %v, want %v", step, env, want)
+		}
+		for name, value := range want {
+			if env[name].Value != value {
+				t.Errorf("%s: %s = %q, want %q", step, name, env[name].Value, value)
+			}
+		}
+
+		_, tlsWanted := want["PGSSLROOTCERT"]
+		if mounted := len(ps.Containers[0].VolumeMounts) == 1 && len(ps.Volumes) == 1; mounted != tlsWanted {
+			t.Errorf("%s: CA bundle mounted = %v, want %v", step, mounted, tlsWanted)
+		}
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			i.Spec.Database = test.database
+			if test.pooler {
+				i.Spec.Pooler = &kojedzinv1alpha1.Pooler{}
+			}
+
+			r := newTestReconciler(nil)
+			p := r.pooler
+
+			// Environment of the api, connecting through the pooler
+			ps := &v1.PodSpec{Containers: []v1.Container{{Env: []v1.EnvVar{{Name: "OTHER", Value: "kept"}}}}}
+			p.setDatabaseEnvironment(r, i, ps)
+			check(t, "set", ps, test.set)
+
+			// Environment of migrations, connecting directly
+			p.unsetDatabaseEnvironment(r, i, ps)
+			if test.pooler {
+				check(t, "unset", ps, test.unset)
+			}
+
+			if env := containerEnv(ps.Containers[0]); env["OTHER"].Value != "kept" {
+				t.Error("unrelated environment variables were removed")
+			}
+		})
+	}
+}
+
+func TestPoolerCustomizeDeployment(t *testing.T) {
+	i := newPoolerThermoCenter()
+
+	newDeployment := func() *appsv1.Deployment {
+		d := &appsv1.Deployment{}
+		d.Spec.Template.Annotations = map[string]string{}
+
+		return d
+	}
+
+	// Configuration not generated yet
+	r := newTestReconciler(nil)
+	d := newDeployment()
+	if err := r.pooler.customizeDeployment(r, i, d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := d.Spec.Template.Annotations[poolerConfigVersionAnnotation]; ok {
+		t.Error("config version annotated without a configuration")
+	}
+
+	// Configuration changes restart the pooler
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: thermoCenterPoolerSecretName(i)}}
+	r = newTestReconciler(nil, secret)
+	d = newDeployment()
+	if err := r.pooler.customizeDeployment(r, i, d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Spec.Template.Annotations[poolerConfigVersionAnnotation] == "" {
+		t.Error("config version not annotated")
+	}
+
+	// Other errors are returned
+	r.Client = failingClient{Client: r.Client, err: fmt.Errorf("connection refused")}
+	if err := r.pooler.customizeDeployment(r, i, newDeployment()); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestReconcilePoolerSecret(t *testing.T) {
+	i := newPoolerThermoCenter()
+	i.Spec.Database = &kojedzinv1alpha1.Database{Host: "postgres", Port: 5432, Name: "thermo", User: "thermo", Password: `se"cret`}
+
+	r := newTestReconciler(nil, i)
+
+	if err := r.reconcilePoolerSecret(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pooler := &v1.Secret{}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: thermoCenterPoolerSecretName(i)}, pooler); err != nil {
+		t.Fatalf("pooler secret not created: %v", err)
+	}
+	if got := string(pooler.Data["userlist.txt"]); got != `"thermo" "se""cret"`+"\n" {
+		t.Errorf("userlist.txt = %q", got)
+	}
+	if !strings.Contains(string(pooler.Data["pgbouncer.ini"]), "* = host=postgres port=5432\n") {
+		t.Errorf("pgbouncer.ini = %s", pooler.Data["pgbouncer.ini"])
+	}
+
+	// Removed with the pooler
+	i.Spec.Pooler = nil
+	if err := r.reconcilePoolerSecret(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: thermoCenterPoolerSecretName(i)}, &v1.Secret{}); err == nil {
+		t.Error("pooler secret not deleted")
+	}
+}
@@ -0,0 +1,301 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update
+
+const (
+	postgresqlComponent    = "postgresql"
+	postgresqlDefaultImage = "postgres:13-alpine"
+	postgresqlDatabase     = "thermo-center"
+	postgresqlUser         = "thermo-center"
+
+	sPOSTGRESDB       = "POSTGRES_DB"
+	sPOSTGRESUSER     = "POSTGRES_USER"
+	sPOSTGRESPASSWORD = "POSTGRES_PASSWORD"
+)
+
+var postgresqlDefaultStorageSize = resource.MustParse("1Gi")
+
+func thermoCenterPostgreSQLName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-" + postgresqlComponent
+}
+
+// usesBuiltinPostgreSQL reports whether the instance runs its own PostgreSQL
+func usesBuiltinPostgreSQL(i *kojedzinv1alpha1.ThermoCenter) bool {
+	return i.Spec.Database == nil
+}
+
+// reconcilePostgreSQL provisions the built-in PostgreSQL instance, and reports whether it is ready.
+// An already provisioned instance is kept when an external database gets configured.
+func (r *ThermoCenterReconciler) reconcilePostgreSQL(i *kojedzinv1alpha1.ThermoCenter) (bool, error) {
+	if !usesBuiltinPostgreSQL(i) {
+		return true, nil
+	}
+
+	if err := r.reconcilePostgreSQLSecret(i); err != nil {
+		return false, err
+	}
+
+	if err := r.reconcilePostgreSQLService(i); err != nil {
+		return false, err
+	}
+
+	return r.reconcilePostgreSQLStatefulSet(i)
+}
+
+// reconcilePostgreSQLSecret generates credentials once
+func (r *ThermoCenterReconciler) reconcilePostgreSQLSecret(i *kojedzinv1alpha1.ThermoCenter) error {
+	secret := &v1.Secret{}
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterPostgreSQLName(i)}, secret)
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	secret.ObjectMeta = metav1.ObjectMeta{
+		Namespace: i.Namespace,
+		Name:      thermoCenterPostgreSQLName(i),
+	}
+
+	if err = controllerutil.SetControllerReference(i, secret, r.Scheme); err != nil {
+		return err
+	}
+
+	secret.Data = map[string][]byte{
+		sPOSTGRESDB:       []byte(postgresqlDatabase),
+		sPOSTGRESUSER:     []byte(postgresqlUser),
+		sPOSTGRESPASSWORD: []byte(r.randomString(32)),
+	}
+
+	return r.Create(context.TODO(), secret)
+}
+
+func (r *ThermoCenterReconciler) reconcilePostgreSQLService(i *kojedzinv1alpha1.ThermoCenter) error {
+	service := &v1.Service{}
+	found := true
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterPostgreSQLName(i)}, service)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		found = false
+
+		service.ObjectMeta = metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      thermoCenterPostgreSQLName(i),
+		}
+
+		if err = controllerutil.SetControllerReference(i, service, r.Scheme); err != nil {
+			return err
+		}
+	}
+
+	service.Spec.Selector = labelsForComponent(i, postgresqlComponent)
+	service.Spec.Ports = []v1.ServicePort{{
+		Name: postgresqlComponent,
+		Port: kojedzinv1alpha1.DefaultDatabasePort,
+	}}
+
+	if found {
+		return r.Update(context.TODO(), service)
+	}
+
+	return r.Create(context.TODO(), service)
+}
+
+func (r *ThermoCenterReconciler) reconcilePostgreSQLStatefulSet(i *kojedzinv1alpha1.ThermoCenter) (bool, error) {
+	pg := i.Spec.PostgreSQL
+	if pg == nil {
+		pg = &kojedzinv1alpha1.PostgreSQL{}
+	}
+
+	sts := &appsv1.StatefulSet{}
+	found := true
+
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterPostgreSQLName(i)}, sts)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+
+		found = false
+
+		ls := labelsForComponent(i, postgresqlComponent)
+
+		storageSize := postgresqlDefaultStorageSize
+		if pg.StorageSize != nil {
+			storageSize = *pg.StorageSize
+		}
+
+		// Volume claims are immutable, thus set only on creation
+		sts = &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: i.Namespace,
+				Name:      thermoCenterPostgreSQLName(i),
+			},
+			Spec: appsv1.StatefulSetSpec{
+				ServiceName: thermoCenterPostgreSQLName(i),
+				Selector: &metav1.LabelSelector{
+					MatchLabels: ls,
+				},
+				Template: v1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: ls,
+					},
+				},
+				VolumeClaimTemplates: []v1.PersistentVolumeClaim{{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "data",
+					},
+					Spec: v1.PersistentVolumeClaimSpec{
+						AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+						StorageClassName: pg.StorageClassName,
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								v1.ResourceStorage: storageSize,
+							},
+						},
+					},
+				}},
+			},
+		}
+
+		if err = controllerutil.SetControllerReference(i, sts, r.Scheme); err != nil {
+			return false, err
+		}
+	}
+
+	sts.Spec.Replicas = replicas(1)
+	sts.Spec.Template.Spec = *r.getPostgreSQLPodSpec(i, pg)
+
+	if found {
+		err = r.Update(context.TODO(), sts)
+	} else {
+		err = r.Create(context.TODO(), sts)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return sts.Status.ReadyReplicas > 0, nil
+}
+
+func (r *ThermoCenterReconciler) getPostgreSQLPodSpec(i *kojedzinv1alpha1.ThermoCenter, pg *kojedzinv1alpha1.PostgreSQL) *v1.PodSpec {
+	enableServiceLinks := false
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+
+	// postgres user of the alpine based images
+	uid := int64(70)
+
+	image := pg.Image
+	if image == "" {
+		image = postgresqlDefaultImage
+	}
+
+	ps := &v1.PodSpec{
+		Containers: []v1.Container{{
+			Name:  postgresqlComponent,
+			Image: r.rewriteImage(i, image),
+			Env: []v1.EnvVar{{
+				Name:  "PGDATA",
+				Value: "/var/lib/postgresql/data/pgdata",
+			}},
+			EnvFrom: []v1.EnvFromSource{{
+				SecretRef: &v1.SecretEnvSource{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: thermoCenterPostgreSQLName(i),
+					},
+				},
+			}},
+			Ports: []v1.ContainerPort{{
+				Name:          postgresqlComponent,
+				ContainerPort: kojedzinv1alpha1.DefaultDatabasePort,
+			}},
+			ReadinessProbe: &v1.Probe{
+				Handler: v1.Handler{
+					Exec: &v1.ExecAction{
+						Command: []string{"pg_isready", "-h", "127.0.0.1", "-U", postgresqlUser, "-d", postgresqlDatabase},
+					},
+				},
+				PeriodSeconds: 10,
+			},
+			LivenessProbe: &v1.Probe{
+				Handler: v1.Handler{
+					TCPSocket: &v1.TCPSocketAction{
+						Port: intstr.FromInt(kojedzinv1alpha1.DefaultDatabasePort),
+					},
+				},
+				InitialDelaySeconds: 30,
+				PeriodSeconds:       30,
+			},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("10m"),
+					v1.ResourceMemory: resource.MustParse("64Mi"),
+				},
+			},
+			SecurityContext: &v1.SecurityContext{
+				AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			},
+			VolumeMounts: []v1.VolumeMount{{
+				Name:      "data",
+				MountPath: "/var/lib/postgresql/data",
+			}},
+		}},
+		EnableServiceLinks: &enableServiceLinks,
+		SecurityContext: &v1.PodSecurityContext{
+			RunAsNonRoot: &runAsNonRoot,
+			RunAsUser:    &uid,
+			RunAsGroup:   &uid,
+			FSGroup:      &uid,
+		},
+		Affinity:     pg.Affinity,
+		NodeSelector: pg.NodeSelector,
+		Tolerations:  pg.Tolerations,
+	}
+
+	setImagePullOptions(i, ps)
+
+	return ps
+}
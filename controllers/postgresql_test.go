@@ -0,0 +1,214 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReconcilePostgreSQL(t *testing.T) {
+	storageSize := resource.MustParse("5Gi")
+
+	i := newTestThermoCenter()
+	i.Spec.PostgreSQL = &kojedzinv1alpha1.PostgreSQL{
+		Image:            "postgres:13.4-alpine",
+		StorageSize:      &storageSize,
+		StorageClassName: stringPtr("fast"),
+		NodeSelector:     map[string]string{"disk": "ssd"},
+	}
+	r := newTestReconciler(nil, i)
+
+	ready, err := r.reconcilePostgreSQL(i)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ready {
+		t.Error("reported ready without ready replicas")
+	}
+
+	key := types.NamespacedName{Namespace: i.Namespace, Name: "tc-postgresql"}
+
+	// Credentials
+	secret := &v1.Secret{}
+	if err = r.Get(context.TODO(), key, secret); err != nil {
+		t.Fatalf("secret not created: %v", err)
+	}
+	if string(secret.Data[sPOSTGRESDB]) != postgresqlDatabase || string(secret.Data[sPOSTGRESUSER]) != postgresqlUser {
+		t.Errorf("database %q, user %q", secret.Data[sPOSTGRESDB], secret.Data[sPOSTGRESUSER])
+	}
+	password := string(secret.Data[sPOSTGRESPASSWORD])
+	if len(password) < 32 {
+		t.Errorf("password %q is too short", password)
+	}
+
+	// Service
+	service := &v1.Service{}
+	if err = r.Get(context.TODO(), key, service); err != nil {
+		t.Fatalf("service not created: %v", err)
+	}
+	if service.Spec.Selector["thermo-center-component"] != postgresqlComponent {
+		t.Errorf("service selector = %v", service.Spec.Selector)
+	}
+	if len(service.Spec.Ports) != 1 || service.Spec.Ports[0].Port != kojedzinv1alpha1.DefaultDatabasePort {
+		t.Errorf("service ports = %v", service.Spec.Ports)
+	}
+
+	// StatefulSet
+	sts := &appsv1.StatefulSet{}
+	if err = r.Get(context.TODO(), key, sts); err != nil {
+		t.Fatalf("statefulset not created: %v", err)
+	}
+	if owners := sts.GetOwnerReferences(); len(owners) != 1 || owners[0].Kind != "ThermoCenter" {
+		t.Errorf("owner references = %v", owners)
+	}
+	if *sts.Spec.Replicas != 1 || sts.Spec.ServiceName != "tc-postgresql" {
+		t.Errorf("replicas = %d, service name = %q", *sts.Spec.Replicas, sts.Spec.ServiceName)
+	}
+
+	claims := sts.Spec.VolumeClaimTemplates
+	if len(claims) != 1 || claims[0].Name != "data" {
+		t.Fatalf("volume claim templates = %v", claims)
+	}
+	if size := claims[0].Spec.Resources.Requests[v1.ResourceStorage]; size.String() != "5Gi" {
+		t.Errorf("storage size = %s, want 5Gi", size.String())
+	}
+	if class := claims[0].Spec.StorageClassName; class == nil || *class != "fast" {
+		t.Errorf("storage class = %v, want fast", class)
+	}
+
+	ps := sts.Spec.Template.Spec
+	c := ps.Containers[0]
+	if c.Image != "postgres:13.4-alpine" {
+		t.Errorf("image = %q", c.Image)
+	}
+	if len(c.EnvFrom) != 1 || c.EnvFrom[0].SecretRef.Name != "tc-postgresql" {
+		t.Errorf("environment not taken from the secret: %v", c.EnvFrom)
+	}
+	if env := containerEnv(c); env["PGDATA"].Value != "/var/lib/postgresql/data/pgdata" {
+		t.Errorf("PGDATA = %q", env["PGDATA"].Value)
+	}
+	if len(c.VolumeMounts) != 1 || c.VolumeMounts[0].Name != "data" || c.VolumeMounts[0].MountPath != "/var/lib/postgresql/data" {
+		t.Errorf("volume mounts = %v", c.VolumeMounts)
+	}
+	if c.ReadinessProbe == nil || c.ReadinessProbe.Exec == nil || c.ReadinessProbe.Exec.Command[0] != "pg_isready" {
+		t.Errorf("readiness probe = %v", c.ReadinessProbe)
+	}
+	if c.LivenessProbe == nil || c.LivenessProbe.TCPSocket == nil || c.LivenessProbe.TCPSocket.Port.IntValue() != kojedzinv1alpha1.DefaultDatabasePort {
+		t.Errorf("liveness probe = %v", c.LivenessProbe)
+	}
+	if ps.SecurityContext == nil || *ps.SecurityContext.RunAsUser != 70 || *ps.SecurityContext.FSGroup != 70 {
+		t.Errorf("pod security context = %v", ps.SecurityContext)
+	}
+	if ps.NodeSelector["disk"] != "ssd" {
+		t.Errorf("node selector = %v", ps.NodeSelector)
+	}
+
+	// Ready replicas
+	sts.Status.ReadyReplicas = 1
+	if err = r.Update(context.TODO(), sts); err != nil {
+		t.Fatal(err)
+	}
+
+	if ready, err = r.reconcilePostgreSQL(i); err != nil || !ready {
+		t.Errorf("ready = %v, err = %v, want ready", ready, err)
+	}
+
+	// Credentials are generated only once
+	secret = &v1.Secret{}
+	if err = r.Get(context.TODO(), key, secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data[sPOSTGRESPASSWORD]) != password {
+		t.Error("password regenerated")
+	}
+
+	// Volume claims are immutable, thus kept on updates
+	newSize := resource.MustParse("10Gi")
+	i.Spec.PostgreSQL.StorageSize = &newSize
+	i.Spec.PostgreSQL.Image = "postgres:13.5-alpine"
+	if _, err = r.reconcilePostgreSQL(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sts = &appsv1.StatefulSet{}
+	if err = r.Get(context.TODO(), key, sts); err != nil {
+		t.Fatal(err)
+	}
+	if size := sts.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[v1.ResourceStorage]; size.String() != "5Gi" {
+		t.Errorf("storage size = %s, want 5Gi kept", size.String())
+	}
+	if image := sts.Spec.Template.Spec.Containers[0].Image; image != "postgres:13.5-alpine" {
+		t.Errorf("image = %q, want it updated", image)
+	}
+}
+
+func TestReconcilePostgreSQLDefaults(t *testing.T) {
+	i := newTestThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	if _, err := r.reconcilePostgreSQL(i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: "tc-postgresql"}, sts); err != nil {
+		t.Fatal(err)
+	}
+
+	if image := sts.Spec.Template.Spec.Containers[0].Image; image != postgresqlDefaultImage {
+		t.Errorf("image = %q, want %q", image, postgresqlDefaultImage)
+	}
+
+	claim := sts.Spec.VolumeClaimTemplates[0].Spec
+	if size := claim.Resources.Requests[v1.ResourceStorage]; size.String() != "1Gi" {
+		t.Errorf("storage size = %s, want 1Gi", size.String())
+	}
+	if claim.StorageClassName != nil {
+		t.Errorf("storage class = %q, want the default", *claim.StorageClassName)
+	}
+}
+
+func TestReconcilePostgreSQLExternalDatabase(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Spec.Database = &kojedzinv1alpha1.Database{Host: "postgres", Name: "thermo", User: "thermo"}
+	r := newTestReconciler(nil, i)
+
+	ready, err := r.reconcilePostgreSQL(i)
+	if err != nil || !ready {
+		t.Errorf("ready = %v, err = %v, want ready", ready, err)
+	}
+
+	if err = r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: "tc-postgresql"}, &appsv1.StatefulSet{}); !errors.IsNotFound(err) {
+		t.Errorf("built-in PostgreSQL provisioned for an external database: %v", err)
+	}
+}
@@ -44,6 +44,11 @@ func (rec *receiverReconciler) getDeployment(i *kojedzinv1alpha1.ThermoCenter) *
 }
 
 func (rec *receiverReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) *v1.PodSpec {
+	// Clones do not receive by default
+	if i.Spec.InitFrom != nil && !i.Spec.EnableReceiver {
+		return nil
+	}
+
 	// Resource requirements
 	ps.Containers[0].Resources = v1.ResourceRequirements{
 		Requests: v1.ResourceList{
@@ -74,11 +79,13 @@ func (rec *receiverReconciler) customizeService(r *ThermoCenterReconciler, i *ko
 	return service
 }
 
-func (rec *receiverReconciler) customizeDeployment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, d *appsv1.Deployment) {
+func (rec *receiverReconciler) customizeDeployment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, d *appsv1.Deployment) error {
 	d.Spec.Strategy = appsv1.DeploymentStrategy{
 		Type: appsv1.RollingUpdateDeploymentStrategyType,
 		RollingUpdate: &appsv1.RollingUpdateDeployment{
 			MaxUnavailable: &intstr.IntOrString{IntVal: 1},
 		},
 	}
+
+	return nil
 }
@@ -0,0 +1,148 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"strconv"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var redisDeployment = &kojedzinv1alpha1.Deployment{
+	Image:    "redis:6.0.10-alpine",
+	Replicas: replicas(1),
+}
+
+type redisReconciler struct {
+	defaultDeploymentReconciler
+}
+
+func (rd *redisReconciler) component() string {
+	return "redis"
+}
+
+func (rd *redisReconciler) getDeployment(i *kojedzinv1alpha1.ThermoCenter) *kojedzinv1alpha1.Deployment {
+	if i.Spec.Redis == nil {
+		return redisDeployment
+	}
+
+	return deploymentWithDefaults(&i.Spec.Redis.Deployment, redisDeployment)
+}
+
+func (rd *redisReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) *v1.PodSpec {
+	if cacheType(i) != kojedzinv1alpha1.CacheTypeRedis || i.Spec.Cache.ExternalRedis != nil {
+		return nil
+	}
+
+	memorySize := cacheMemorySize(i.Spec.Redis)
+
+	// Resource requirements
+	ps.Containers[0].Resources = cacheResources(i.Spec.Redis, memorySize)
+
+	// Override uid/gid
+	runAsUser := int64(999)
+	runAsGroup := int64(1000)
+
+	ps.SecurityContext.RunAsUser = &runAsUser
+	ps.SecurityContext.RunAsGroup = &runAsGroup
+
+	// Command, running as a cache without persistence
+	ps.Containers[0].Command = []string{
+		"redis-server",
+		"--save", "",
+		"--appendonly", "no",
+		"--maxmemory", strconv.FormatInt(memorySize.Value(), 10),
+		"--maxmemory-policy", "allkeys-lru",
+	}
+
+	// Ports
+	ps.Containers[0].Ports = []v1.ContainerPort{{
+		Name:          rd.component(),
+		ContainerPort: kojedzinv1alpha1.DefaultRedisPort,
+	}}
+
+	ps.Containers[0].ReadinessProbe = &v1.Probe{
+		Handler: v1.Handler{
+			TCPSocket: &v1.TCPSocketAction{
+				Port: intstr.FromInt(kojedzinv1alpha1.DefaultRedisPort),
+			},
+		},
+	}
+
+	return ps
+}
+
+func (rd *redisReconciler) customizeService(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, service *v1.Service) *v1.Service {
+	if cacheType(i) != kojedzinv1alpha1.CacheTypeRedis || i.Spec.Cache.ExternalRedis != nil {
+		return nil
+	}
+
+	service.Spec.Ports = []v1.ServicePort{{
+		Name: rd.component(),
+		Port: kojedzinv1alpha1.DefaultRedisPort,
+	}}
+
+	return service
+}
+
+func (rd *redisReconciler) setEnvironment(r *ThermoCenterReconciler, i *kojedzinv1alpha1.ThermoCenter, env *[]v1.EnvVar) {
+	host := thermoCenterServiceName(i, rd)
+	port := kojedzinv1alpha1.DefaultRedisPort
+	database := int32(0)
+
+	ext := i.Spec.Cache.ExternalRedis
+	if ext != nil {
+		host = ext.Hostname
+		if ext.Port != 0 {
+			port = ext.Port
+		}
+		database = ext.Database
+	}
+
+	*env = append(*env,
+		v1.EnvVar{
+			Name:  "CACHE_TYPE",
+			Value: kojedzinv1alpha1.CacheTypeRedis,
+		},
+		v1.EnvVar{
+			Name:  "REDIS_HOST",
+			Value: host,
+		},
+		v1.EnvVar{
+			Name:  "REDIS_PORT",
+			Value: strconv.Itoa(port),
+		},
+		v1.EnvVar{
+			Name:  "REDIS_DB",
+			Value: strconv.Itoa(int(database)),
+		},
+	)
+
+	if ext != nil && ext.PasswordSecretRef != nil {
+		*env = append(*env, secretKeyEnv("REDIS_PASSWORD", ext.PasswordSecretRef.Name, ext.PasswordSecretRef.Key))
+	}
+}
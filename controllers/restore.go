@@ -0,0 +1,280 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotation on ThermoCenter instances naming the restore in progress
+const thermoCenterRestoreAnnotation = "thermo-center-restore"
+
+// Restores a backup in a single transaction, and reports the database version recorded in it
+const restoreScript = `set -e
+pg_restore --clean --if-exists --no-owner --no-privileges --single-transaction --exit-on-error -d "${PGDATABASE}" "/backup/${FILE}"
+version=""
+if pg_restore -l "/backup/${FILE}" | grep -q " TABLE public thermo_center_controller_version "; then
+	version="$(psql -At -c "SELECT version FROM thermo_center_controller_version")"
+fi
+printf '{"databaseVersion":"%s"}' "${version}" > /dev/termination-log
+`
+
+// Downloads a backup from S3 into backupDir
+const restoreDownloadScript = `set -e
+mc alias set source "${S3_ENDPOINT}" "${S3_ACCESS_KEY}" "${S3_SECRET_KEY}" > /dev/null
+mc cp "source/${S3_BUCKET}/${S3_PREFIX}${FILE}" "/backup/${FILE}"
+`
+
+// restoreResult is reported by restore pods in their termination message
+type restoreResult struct {
+	DatabaseVersion string `json:"databaseVersion"`
+}
+
+// restoredDatabaseVersion returns the database version recorded in the dump
+// restored by a finished job. Dumps taken before versions were recorded in
+// them fall back to the version recorded for the backup.
+func (r *ThermoCenterReconciler) restoredDatabaseVersion(job *batchv1.Job, recorded string) (string, error) {
+	pods := &v1.PodList{}
+	if err := r.List(context.TODO(), pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Terminated == nil || cs.State.Terminated.ExitCode != 0 || cs.State.Terminated.Message == "" {
+				continue
+			}
+
+			result := &restoreResult{}
+			if err := json.Unmarshal([]byte(cs.State.Terminated.Message), result); err == nil && result.DatabaseVersion != "" {
+				return result.DatabaseVersion, nil
+			}
+		}
+	}
+
+	return recorded, nil
+}
+
+// restorePaused reports whether the instance is paused for a restore
+func restorePaused(i *kojedzinv1alpha1.ThermoCenter) bool {
+	return i.Annotations[thermoCenterRestoreAnnotation] != ""
+}
+
+// restoreComponents lists components which are stopped during a restore
+func (r *ThermoCenterReconciler) restoreComponents() []deploymentReconciler {
+	return []deploymentReconciler{r.ui, r.api, r.ws, r.grpc, r.receiver, r.pooler}
+}
+
+// restoreScalesDown reports whether a component must be kept scaled down
+func (r *ThermoCenterReconciler) restoreScalesDown(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler) bool {
+	if !restorePaused(i) {
+		return false
+	}
+
+	for _, c := range r.restoreComponents() {
+		if c == rec {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pauseForRestore scales down components accessing the database
+func (r *ThermoCenterReconciler) pauseForRestore(i *kojedzinv1alpha1.ThermoCenter) error {
+	for _, rec := range r.restoreComponents() {
+		if err := r.reconcile(i, rec); err != nil {
+			return err
+		}
+	}
+
+	if i.Status.Status == "restoring" {
+		return nil
+	}
+
+	i.Status.Status = "restoring"
+
+	return r.Status().Update(context.TODO(), i)
+}
+
+// pausedForRestore reports whether components are stopped and no migration is running
+func (r *ThermoCenterReconciler) pausedForRestore(i *kojedzinv1alpha1.ThermoCenter) (bool, error) {
+	for _, rec := range r.restoreComponents() {
+		deployment := &appsv1.Deployment{}
+		err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterDeploymentName(i, rec)}, deployment)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+
+			return false, err
+		}
+
+		if deployment.Status.Replicas > 0 {
+			return false, nil
+		}
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterMigrationJobName(i)}, job)
+	if err == nil {
+		return false, nil
+	}
+
+	return errors.IsNotFound(err), client.IgnoreNotFound(err)
+}
+
+// resolveBackupSource returns the location of a referenced backup
+func (r *ThermoCenterReconciler) resolveBackupSource(namespace string, src *kojedzinv1alpha1.BackupSource) (*kojedzinv1alpha1.BackupLocation, error) {
+	if src.Location != nil {
+		return src.Location, nil
+	}
+
+	if src.Backup == "" {
+		return nil, fmt.Errorf("no backup specified")
+	}
+
+	backup := &kojedzinv1alpha1.ThermoCenterBackup{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: src.Backup}, backup); err != nil {
+		return nil, err
+	}
+
+	if backup.Status.Phase != kojedzinv1alpha1.BackupSucceeded || backup.Status.Storage == nil {
+		return nil, fmt.Errorf("backup %s has not succeeded", src.Backup)
+	}
+
+	return &kojedzinv1alpha1.BackupLocation{
+		Storage:         *backup.Status.Storage,
+		File:            backup.Status.File,
+		DatabaseVersion: backup.Status.DatabaseVersion,
+	}, nil
+}
+
+// restoreJobSpec returns the job restoring a backup into the instance's database
+func (r *ThermoCenterReconciler) restoreJobSpec(i *kojedzinv1alpha1.ThermoCenter, loc *kojedzinv1alpha1.BackupLocation) batchv1.JobSpec {
+	image := postgresqlDefaultImage
+	if i.Spec.Backup != nil && i.Spec.Backup.Image != "" {
+		image = i.Spec.Backup.Image
+	}
+
+	activeDeadlineSeconds := int64(3600)
+	backoffLimit := int32(0)
+	enableServiceLinks := false
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+	uid := int64(70)
+
+	fileEnv := v1.EnvVar{
+		Name:  "FILE",
+		Value: loc.File,
+	}
+
+	restore := v1.Container{
+		Name:    "restore",
+		Image:   r.rewriteImage(i, image),
+		Command: []string{"sh", "-c", restoreScript},
+		Env:     append(databaseEnv(i), fileEnv),
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("10m"),
+				v1.ResourceMemory: resource.MustParse("16Mi"),
+			},
+		},
+		SecurityContext: &v1.SecurityContext{
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		},
+		VolumeMounts: []v1.VolumeMount{{
+			Name:      backupComponent,
+			MountPath: backupDir,
+		}},
+	}
+
+	ps := v1.PodSpec{
+		EnableServiceLinks: &enableServiceLinks,
+		RestartPolicy:      v1.RestartPolicyNever,
+		SecurityContext: &v1.PodSecurityContext{
+			RunAsNonRoot: &runAsNonRoot,
+			RunAsUser:    &uid,
+			RunAsGroup:   &uid,
+			FSGroup:      &uid,
+		},
+	}
+
+	if s3 := loc.Storage.S3; s3 != nil {
+		download := *restore.DeepCopy()
+		download.Name = "download"
+		download.Image = r.rewriteImage(i, backupMCImage)
+		download.Command = []string{"sh", "-c", restoreDownloadScript}
+		download.Env = append(s3Env(s3), fileEnv)
+
+		ps.InitContainers = []v1.Container{download}
+		ps.Volumes = []v1.Volume{{
+			Name: backupComponent,
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		}}
+	} else {
+		restore.VolumeMounts[0].ReadOnly = true
+
+		ps.Volumes = []v1.Volume{{
+			Name: backupComponent,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: loc.Storage.PersistentVolumeClaim.Name,
+					ReadOnly:  true,
+				},
+			},
+		}}
+	}
+
+	setDatabaseTLS(i, &ps, &restore)
+	ps.Containers = []v1.Container{restore}
+
+	setImagePullOptions(i, &ps)
+
+	return batchv1.JobSpec{
+		ActiveDeadlineSeconds: &activeDeadlineSeconds,
+		BackoffLimit:          &backoffLimit,
+		Template: v1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: labelsForComponent(i, "restore"),
+			},
+			Spec: ps,
+		},
+	}
+}
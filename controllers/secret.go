@@ -50,11 +50,16 @@ const (
 
 // Reconcile secret for thermo-center
 func (r *ThermoCenterReconciler) reconcileSecret(i *kojedzinv1alpha1.ThermoCenter) error {
+	db, err := r.databaseParams(i)
+	if err != nil {
+		return err
+	}
+
 	secretName := thermoCenterSecretName(i)
 	secret := &v1.Secret{}
 	found := true
 
-	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: secretName}, secret)
+	err = r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: secretName}, secret)
 	if err != nil {
 		if !errors.IsNotFound(err) {
 			return err
@@ -73,16 +78,21 @@ func (r *ThermoCenterReconciler) reconcileSecret(i *kojedzinv1alpha1.ThermoCente
 		}
 
 		secret.Data = make(map[string][]byte)
-		secret.Data[sDBCONNMAXAGE] = []byte("0")
 		secret.Data[sSECRETKEY] = []byte(r.randomString(64))
 	}
 
 	// Overwrite fields
-	secret.Data[sDBHOST] = []byte(i.Spec.Database.Host)
-	secret.Data[sDBPORT] = []byte(fmt.Sprintf("%d", i.Spec.Database.Port))
-	secret.Data[sDBNAME] = []byte(i.Spec.Database.Name)
-	secret.Data[sDBUSER] = []byte(i.Spec.Database.User)
-	secret.Data[sDBPASSWORD] = []byte(i.Spec.Database.Password)
+	secret.Data[sDBHOST] = []byte(db.Host)
+	secret.Data[sDBPORT] = []byte(fmt.Sprintf("%d", db.Port))
+	secret.Data[sDBNAME] = []byte(db.Name)
+	secret.Data[sDBUSER] = []byte(db.User)
+	secret.Data[sDBPASSWORD] = []byte(db.Password)
+	// Unset lifetime closes connections after each request
+	connMaxAge := int32(0)
+	if db.ConnMaxAge != nil {
+		connMaxAge = *db.ConnMaxAge
+	}
+	secret.Data[sDBCONNMAXAGE] = []byte(fmt.Sprintf("%d", connMaxAge))
 
 	if found {
 		return r.Update(context.TODO(), secret)
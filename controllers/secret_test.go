@@ -0,0 +1,76 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReconcileSecretConnMaxAge(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Spec.Database = &kojedzinv1alpha1.Database{
+		Host:     "postgres",
+		Name:     "thermo",
+		User:     "thermo",
+		Password: "secret",
+	}
+	r := newTestReconciler(nil, i)
+
+	connMaxAge := func() string {
+		t.Helper()
+
+		if err := r.reconcileSecret(i); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		secret := &v1.Secret{}
+		if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterSecretName(i)}, secret); err != nil {
+			t.Fatal(err)
+		}
+
+		return string(secret.Data[sDBCONNMAXAGE])
+	}
+
+	if got := connMaxAge(); got != "0" {
+		t.Errorf("%s = %q, want 0", sDBCONNMAXAGE, got)
+	}
+
+	age := int32(60)
+	i.Spec.Database.ConnMaxAge = &age
+	if got := connMaxAge(); got != "60" {
+		t.Errorf("%s = %q, want 60", sDBCONNMAXAGE, got)
+	}
+
+	// Unsetting restores the default
+	i.Spec.Database.ConnMaxAge = nil
+	if got := connMaxAge(); got != "0" {
+		t.Errorf("%s = %q after unsetting, want 0", sDBCONNMAXAGE, got)
+	}
+}
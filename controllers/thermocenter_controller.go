@@ -50,28 +50,49 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"github.com/rkojedzinszky/thermo-center-controller/registry"
 )
 
+// latestCheckInterval specifies how often new images are looked for when tracking latest
+const latestCheckInterval = time.Hour
+
+// Options holds controller wide settings
+type Options struct {
+	// Registry is used to query image registries
+	Registry registry.Client
+
+	// ImageRewrites are applied to image names of all instances, after their own rules
+	ImageRewrites []kojedzinv1alpha1.ImageRewrite
+}
+
 // ThermoCenterReconciler reconciles a ThermoCenter object
 type ThermoCenterReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
 
+	registry      registry.Client
+	imageRewrites []kojedzinv1alpha1.ImageRewrite
+
 	rand     *rand.Rand
 	randLock *sync.Mutex
 
 	memcached *memcachedReconciler
+	redis     *redisReconciler
 	mqtt      *mqttReconciler
 	ui        *uiReconciler
 	grpc      *grpcReconciler
 	receiver  *receiverReconciler
 	api       *apiReconciler
 	ws        *wsReconciler
+
+	pooler        *poolerReconciler
+	maintenance   *maintenanceReconciler
+	homeAssistant *homeAssistantReconciler
 }
 
 // NewThermoCenterReconciler instantiates a new ThermoCenter Reconciler
-func NewThermoCenterReconciler(mgr manager.Manager) *ThermoCenterReconciler {
+func NewThermoCenterReconciler(mgr manager.Manager, opts Options) *ThermoCenterReconciler {
 	client, _ := client.New(mgr.GetConfig(), client.Options{})
 
 	return &ThermoCenterReconciler{
@@ -79,16 +100,24 @@ func NewThermoCenterReconciler(mgr manager.Manager) *ThermoCenterReconciler {
 		Log:    ctrl.Log.WithName("controllers").WithName("ThermoCenter"),
 		Scheme: mgr.GetScheme(),
 
+		registry:      opts.Registry,
+		imageRewrites: opts.ImageRewrites,
+
 		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
 		randLock: &sync.Mutex{},
 
 		mqtt:      &mqttReconciler{},
 		memcached: &memcachedReconciler{},
+		redis:     &redisReconciler{},
 		ui:        &uiReconciler{},
 		grpc:      &grpcReconciler{},
 		receiver:  &receiverReconciler{},
 		api:       &apiReconciler{},
 		ws:        &wsReconciler{},
+
+		pooler:        &poolerReconciler{},
+		maintenance:   &maintenanceReconciler{},
+		homeAssistant: &homeAssistantReconciler{},
 	}
 }
 
@@ -114,18 +143,57 @@ func (r *ThermoCenterReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
+	// Carry out the deletion policy
+	if !instance.DeletionTimestamp.IsZero() {
+		return r.finalize(instance, reqLogger)
+	}
+
+	if err = r.reconcileFinalizer(instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Provision built-in database
+	dbReady, err := r.reconcilePostgreSQL(instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Reconcile secret
 	err = r.reconcileSecret(instance)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
+	// Reconcile connection pooler configuration
+	err = r.reconcilePoolerSecret(instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Reconcile built-in MQTT broker configuration
+	err = r.reconcileMQTT(instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Reconcile network policies
 	err = r.reconcileNetworkPolicy(instance)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
+	// Wait for built-in database
+	if !dbReady {
+		reqLogger.Info("Waiting for database")
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	// Reconcile scheduled backups
+	err = r.reconcileBackupCronJob(instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Process existing migration Job
 	job, err := r.fetchMigrationJob(instance, reqLogger)
 	if err != nil {
@@ -135,9 +203,35 @@ func (r *ThermoCenterReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return r.handleMigrationJob(instance, job, reqLogger)
 	}
 
+	// Keep components stopped while a backup is being restored
+	if restorePaused(instance) {
+		return ctrl.Result{}, r.pauseForRestore(instance)
+	}
+
+	// Look for version updates
+	if err = r.reconcileUpdatePolicy(instance, reqLogger); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Pin image digests for the desired version
+	if err = r.reconcilePinnedDigests(instance, reqLogger); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Create database before the first migration
+	if needsDatabaseBootstrap(instance) {
+		return r.reconcileDatabaseBootstrap(instance, reqLogger)
+	}
+
+	// Initialize database from a backup before the first migration
+	if needsInit(instance) {
+		return r.reconcileInit(instance, reqLogger)
+	}
+
 	// Create migration Job if needed
-	if r.needsMigration(instance, reqLogger) {
-		return r.createMigrationJob(instance, reqLogger)
+	target := r.migrationTarget(instance, reqLogger)
+	if r.needsMigration(instance, target, reqLogger) {
+		return r.createMigrationJob(instance, target, reqLogger)
 	}
 
 	// Set state to ready
@@ -153,19 +247,40 @@ func (r *ThermoCenterReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	}
 
 	// Reconcile deployments
-	for _, rec := range []deploymentReconciler{r.mqtt, r.memcached, r.ui, r.grpc, r.receiver, r.api, r.ws} {
+	for _, rec := range r.deploymentReconcilers() {
 		err = r.reconcile(instance, rec)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	return ctrl.Result{}, nil
+	// Report component images
+	if err = r.reconcileComponentStatus(instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Periodically look for new images when tracking latest
+	if desiredVersion(instance) == "" {
+		return requeueForPinning(instance, ctrl.Result{RequeueAfter: latestCheckInterval}), nil
+	}
+
+	// Verify upgraded components
+	result, err := r.reconcileUpgradeVerification(instance, reqLogger)
+	if err != nil {
+		return result, err
+	}
+
+	// Requeue for the next version check
+	if result.IsZero() && instance.Spec.UpdatePolicy != nil && instance.Status.NextUpdateCheck != nil {
+		result.RequeueAfter = time.Until(instance.Status.NextUpdateCheck.Time)
+	}
+
+	return requeueForPinning(instance, result), nil
 }
 
 func (r *ThermoCenterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&kojedzinv1alpha1.ThermoCenter{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		For(&kojedzinv1alpha1.ThermoCenter{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
 		Watches(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
 			OwnerType: &kojedzinv1alpha1.ThermoCenter{},
 		}, builder.WithPredicates(predicate.Funcs{
@@ -173,6 +288,7 @@ func (r *ThermoCenterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				return false
 			},
 		})).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.secretToThermoCenters)).
 		Complete(r)
 }
 
@@ -183,18 +299,10 @@ func (r *ThermoCenterReconciler) getPodSpec(i *kojedzinv1alpha1.ThermoCenter, re
 
 	dep := rec.getDeployment(i)
 
-	// Assign image
-	var image string
-	if dep != nil && dep.Image != "" {
-		image = dep.Image
-	} else {
-		image = getImagePrefix(i) + rec.component()
-	}
-
 	ps := &v1.PodSpec{
 		Containers: []v1.Container{{
 			Name:  rec.component(),
-			Image: setImageTag(i, image),
+			Image: pinImage(i, rec, r.componentImage(i, rec)),
 			SecurityContext: &v1.SecurityContext{
 				AllowPrivilegeEscalation: &allowPrivilegeEscalation,
 			},
@@ -205,6 +313,8 @@ func (r *ThermoCenterReconciler) getPodSpec(i *kojedzinv1alpha1.ThermoCenter, re
 		},
 	}
 
+	setImagePullOptions(i, ps)
+
 	if dep != nil {
 		ps.Affinity = dep.Affinity
 		ps.NodeSelector = dep.NodeSelector
@@ -214,8 +324,31 @@ func (r *ThermoCenterReconciler) getPodSpec(i *kojedzinv1alpha1.ThermoCenter, re
 	return rec.customizePodSpec(r, i, ps)
 }
 
-// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update
-// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update
+// componentImage returns the image of a component, before pinning its digest
+func (r *ThermoCenterReconciler) componentImage(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler) string {
+	dep := rec.getDeployment(i)
+
+	var image string
+	if dep != nil && dep.Image != "" {
+		image = dep.Image
+	} else {
+		image = getImagePrefix(i) + rec.component()
+	}
+
+	return r.rewriteImage(i, setImageTag(i, image))
+}
+
+// componentReplicas returns the replicas of a component, defaulting to the instance's replicas
+func componentReplicas(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler) int32 {
+	if dep := rec.getDeployment(i); dep != nil && dep.Replicas != nil {
+		return *dep.Replicas
+	}
+
+	return i.Spec.Replicas
+}
+
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;delete
 
 func (r *ThermoCenterReconciler) reconcile(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler) error {
 	var err error
@@ -264,16 +397,27 @@ func (r *ThermoCenterReconciler) reconcile(i *kojedzinv1alpha1.ThermoCenter, rec
 
 	if ps != nil {
 		deployment.Spec.Template.Spec = *ps
-		dep := rec.getDeployment(i)
-		// Assign replicas
-		if dep != nil && dep.Replicas != nil {
-			deployment.Spec.Replicas = dep.Replicas
+		deployment.Spec.Replicas = replicas(componentReplicas(i, rec))
+
+		// Restart components when the tracked latest image changes
+		if deployment.Spec.Template.Annotations == nil {
+			deployment.Spec.Template.Annotations = make(map[string]string)
+		}
+		if r.isThermoCenterComponent(rec) && imageVersion(i) == "" && isDigest(i.Status.DatabaseVersion) {
+			deployment.Spec.Template.Annotations[thermoCenterDBVersionAnnotation] = i.Status.DatabaseVersion
 		} else {
-			deployment.Spec.Replicas = &i.Spec.Replicas
+			delete(deployment.Spec.Template.Annotations, thermoCenterDBVersionAnnotation)
+		}
+
+		// Keep component stopped during maintenance and restores
+		if r.maintenanceScalesDown(i, rec) || r.restoreScalesDown(i, rec) {
+			deployment.Spec.Replicas = replicas(0)
 		}
 
 		// Final deployment customization
-		rec.customizeDeployment(r, i, deployment)
+		if err = rec.customizeDeployment(r, i, deployment); err != nil {
+			return err
+		}
 
 		if deploymentExists {
 			err = r.Update(context.TODO(), deployment)
@@ -288,6 +432,11 @@ func (r *ThermoCenterReconciler) reconcile(i *kojedzinv1alpha1.ThermoCenter, rec
 		return err
 	}
 
+	// Disabled components have no Service, which would be owned by the Deployment
+	if ps == nil {
+		deployment = nil
+	}
+
 	//
 	// Reconcile service
 	serviceName := thermoCenterServiceName(i, rec)
@@ -328,15 +477,17 @@ func (r *ThermoCenterReconciler) reconcile(i *kojedzinv1alpha1.ThermoCenter, rec
 
 	if service != nil {
 		if serviceExists {
-			err = r.Update(context.TODO(), service)
-		} else {
-			err = r.Create(context.TODO(), service)
+			return r.Update(context.TODO(), service)
 		}
-	} else if serviceExists {
-		err = r.Delete(context.TODO(), origService)
+
+		return r.Create(context.TODO(), service)
+	}
+
+	if serviceExists {
+		return r.Delete(context.TODO(), origService)
 	}
 
-	return err
+	return nil
 }
 
 // deploymentReconciler is responsible for exactly one deployment and one service only
@@ -353,7 +504,18 @@ type deploymentReconciler interface {
 	customizeService(*ThermoCenterReconciler, *kojedzinv1alpha1.ThermoCenter, *v1.Service) *v1.Service
 
 	// Customize appsv1.Deployment
-	customizeDeployment(*ThermoCenterReconciler, *kojedzinv1alpha1.ThermoCenter, *appsv1.Deployment)
+	customizeDeployment(*ThermoCenterReconciler, *kojedzinv1alpha1.ThermoCenter, *appsv1.Deployment) error
+}
+
+// isThermoCenterComponent reports whether a component runs a thermo-center image
+func (r *ThermoCenterReconciler) isThermoCenterComponent(rec deploymentReconciler) bool {
+	for _, c := range []deploymentReconciler{r.ui, r.api, r.ws, r.grpc, r.receiver} {
+		if c == rec {
+			return true
+		}
+	}
+
+	return false
 }
 
 type defaultDeploymentReconciler struct{}
@@ -372,7 +534,8 @@ func (*defaultDeploymentReconciler) customizeService(_ *ThermoCenterReconciler,
 
 //lint:ignore U1000 noop function to comply with interface
 // Customize appsv1.Deployment
-func (*defaultDeploymentReconciler) customizeDeployment(_ *ThermoCenterReconciler, _ *kojedzinv1alpha1.ThermoCenter, _ *appsv1.Deployment) {
+func (*defaultDeploymentReconciler) customizeDeployment(_ *ThermoCenterReconciler, _ *kojedzinv1alpha1.ThermoCenter, _ *appsv1.Deployment) error {
+	return nil
 }
 
 func thermoCenterDeploymentName(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler) string {
@@ -395,6 +558,18 @@ func thermoCenterMigrationJobName(i *kojedzinv1alpha1.ThermoCenter) string {
 	return i.Name + "-migrate"
 }
 
+func thermoCenterVerificationJobName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-verify"
+}
+
+func thermoCenterBootstrapJobName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-bootstrap"
+}
+
+func thermoCenterInitJobName(i *kojedzinv1alpha1.ThermoCenter) string {
+	return i.Name + "-init"
+}
+
 func (r *ThermoCenterReconciler) randomString(len int) string {
 	b := r.randomBytes(len * 3 / 4)
 
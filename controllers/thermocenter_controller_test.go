@@ -0,0 +1,115 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+func TestReconcileDisabledComponents(t *testing.T) {
+	i := newTestThermoCenter()
+	r := newTestReconciler(nil, i)
+
+	// Components are reconciled repeatedly, whether enabled or not
+	for round := 0; round < 2; round++ {
+		for _, rec := range r.deploymentReconcilers() {
+			if err := r.reconcile(i, rec); err != nil {
+				t.Fatalf("reconciling %s: %v", rec.component(), err)
+			}
+		}
+	}
+
+	for _, rec := range r.deploymentReconcilers() {
+		err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterServiceName(i, rec)}, &v1.Service{})
+		if r.getPodSpec(i, rec) == nil && !errors.IsNotFound(err) {
+			t.Errorf("service of disabled %s: %v", rec.component(), err)
+		}
+	}
+
+	// Services of enabled components are owned by their Deployments
+	service := &v1.Service{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterServiceName(i, r.api)}, service); err != nil {
+		t.Fatalf("api service: %v", err)
+	}
+	if owners := service.OwnerReferences; len(owners) != 1 || owners[0].Kind != "Deployment" || owners[0].Name != thermoCenterDeploymentName(i, r.api) {
+		t.Errorf("api service owners = %+v", owners)
+	}
+}
+
+// TestRoleAllowsDeletes checks the generated role against objects deleted by
+// the controllers, which the fake client does not authorize
+func TestRoleAllowsDeletes(t *testing.T) {
+	data, err := os.ReadFile("../config/rbac/role.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role := &rbacv1.ClusterRole{}
+	if err = yaml.Unmarshal(data, role); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := func(group, resource string) bool {
+		for _, rule := range role.Rules {
+			for _, g := range rule.APIGroups {
+				for _, r := range rule.Resources {
+					if g != group || r != resource {
+						continue
+					}
+					for _, verb := range rule.Verbs {
+						if verb == "delete" {
+							return true
+						}
+					}
+				}
+			}
+		}
+
+		return false
+	}
+
+	for _, gr := range []struct{ group, resource string }{
+		{"", "services"},
+		{"", "secrets"},
+		{"", "persistentvolumeclaims"},
+		{"apps", "deployments"},
+		{"batch", "jobs"},
+		{"batch", "cronjobs"},
+		{"networking.k8s.io", "networkpolicies"},
+		{"kojedz.in", "thermocenterbackups"},
+	} {
+		if !allowed(gr.group, gr.resource) {
+			t.Errorf("role does not allow deleting %s.%s", gr.resource, gr.group)
+		}
+	}
+}
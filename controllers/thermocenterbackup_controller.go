@@ -0,0 +1,284 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ThermoCenterBackupReconciler reconciles a ThermoCenterBackup object. Backups
+// are taken by jobs named after them, either created on demand, or by the
+// backup CronJob of an instance, in which case the backup is recorded here.
+type ThermoCenterBackupReconciler struct {
+	*ThermoCenterReconciler
+}
+
+// NewThermoCenterBackupReconciler instantiates a new ThermoCenterBackup Reconciler
+func NewThermoCenterBackupReconciler(mgr manager.Manager, opts Options) *ThermoCenterBackupReconciler {
+	r := &ThermoCenterBackupReconciler{
+		ThermoCenterReconciler: NewThermoCenterReconciler(mgr, opts),
+	}
+	r.Log = ctrl.Log.WithName("controllers").WithName("ThermoCenterBackup")
+
+	return r
+}
+
+// +kubebuilder:rbac:groups=kojedz.in,resources=thermocenterbackups,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=kojedz.in,resources=thermocenterbackups/status,verbs=get;update
+
+func (r *ThermoCenterBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := r.Log.WithValues("thermocenterbackup", req.NamespacedName)
+
+	backup := &kojedzinv1alpha1.ThermoCenterBackup{}
+	err := r.Get(ctx, req.NamespacedName, backup)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.recordScheduledBackup(req.NamespacedName)
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	if backup.Status.Phase == kojedzinv1alpha1.BackupSucceeded || backup.Status.Phase == kojedzinv1alpha1.BackupFailed {
+		return ctrl.Result{}, nil
+	}
+
+	instance := &kojedzinv1alpha1.ThermoCenter{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: backup.Namespace, Name: backup.Spec.ThermoCenter}, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.failBackup(backup, "ThermoCenter not found")
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	// Label backups for listing them by instance
+	if backup.Labels[ThermoCenterInstanceLabel] != instance.Name {
+		if backup.Labels == nil {
+			backup.Labels = make(map[string]string)
+		}
+		backup.Labels[ThermoCenterInstanceLabel] = instance.Name
+
+		return ctrl.Result{}, r.Update(ctx, backup)
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(ctx, req.NamespacedName, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		if backup.Status.Phase == kojedzinv1alpha1.BackupRunning {
+			return ctrl.Result{}, r.failBackup(backup, "Backup job disappeared")
+		}
+
+		if instance.Spec.Backup == nil {
+			return ctrl.Result{}, r.failBackup(backup, "No backup storage configured for ThermoCenter")
+		}
+
+		reqLogger.Info("Creating backup job")
+
+		return ctrl.Result{}, r.createBackupJob(instance, backup)
+	}
+
+	if backup.Status.Storage == nil && instance.Spec.Backup != nil {
+		backup.Status.Storage = instance.Spec.Backup.Storage.DeepCopy()
+	}
+	backup.Status.JobName = job.Name
+	backup.Status.DatabaseVersion = job.Annotations[thermoCenterDBVersionAnnotation]
+	backup.Status.StartTime = job.Status.StartTime
+
+	if job.Status.Succeeded > 0 {
+		reqLogger.Info("Backup succeeded")
+
+		pods := &v1.PodList{}
+		if err = r.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		result, err := parseBackupResult(pods.Items)
+		if err != nil {
+			return ctrl.Result{}, r.failBackup(backup, err.Error())
+		}
+
+		backup.Status.Phase = kojedzinv1alpha1.BackupSucceeded
+		backup.Status.File = result.File
+		backup.Status.Size = result.Size
+		if backup.Status.Storage != nil {
+			backup.Status.Location = backupLocation(backup.Status.Storage, result.File)
+		}
+		backup.Status.CompletionTime = job.Status.CompletionTime
+
+		if err = r.Status().Update(ctx, backup); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{}, r.pruneBackups(instance)
+	} else if job.Status.Failed > 0 {
+		reqLogger.Info("Backup failed")
+
+		return ctrl.Result{}, r.failBackup(backup, "Backup job failed")
+	}
+
+	backup.Status.Phase = kojedzinv1alpha1.BackupRunning
+
+	return ctrl.Result{}, r.Status().Update(ctx, backup)
+}
+
+// recordScheduledBackup creates a ThermoCenterBackup for a job started by a backup CronJob
+func (r *ThermoCenterBackupReconciler) recordScheduledBackup(name types.NamespacedName) error {
+	job := &batchv1.Job{}
+	if err := r.Get(context.TODO(), name, job); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	owner := metav1.GetControllerOf(job)
+	if owner == nil || owner.Kind != "CronJob" || job.Labels[ThermoCenterInstanceLabel] == "" {
+		return nil
+	}
+
+	backup := &kojedzinv1alpha1.ThermoCenterBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: job.Namespace,
+			Name:      job.Name,
+			Labels: map[string]string{
+				ThermoCenterInstanceLabel: job.Labels[ThermoCenterInstanceLabel],
+			},
+		},
+		Spec: kojedzinv1alpha1.ThermoCenterBackupSpec{
+			ThermoCenter: job.Labels[ThermoCenterInstanceLabel],
+		},
+	}
+
+	if err := r.Create(context.TODO(), backup); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	return nil
+}
+
+// createBackupJob starts an on-demand backup
+func (r *ThermoCenterBackupReconciler) createBackupJob(i *kojedzinv1alpha1.ThermoCenter, backup *kojedzinv1alpha1.ThermoCenterBackup) error {
+	job := &batchv1.Job{
+		ObjectMeta: r.backupJobMeta(i),
+		Spec:       r.backupJobSpec(i),
+	}
+	job.Namespace = backup.Namespace
+	job.Name = backup.Name
+
+	if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+		return err
+	}
+
+	if err := r.Create(context.TODO(), job); err != nil {
+		return err
+	}
+
+	backup.Status.Phase = kojedzinv1alpha1.BackupRunning
+	backup.Status.JobName = job.Name
+	backup.Status.Storage = i.Spec.Backup.Storage.DeepCopy()
+	backup.Status.DatabaseVersion = i.Status.DatabaseVersion
+
+	return r.Status().Update(context.TODO(), backup)
+}
+
+func (r *ThermoCenterBackupReconciler) failBackup(backup *kojedzinv1alpha1.ThermoCenterBackup, message string) error {
+	now := metav1.Now()
+
+	backup.Status.Phase = kojedzinv1alpha1.BackupFailed
+	backup.Status.Message = message
+	backup.Status.CompletionTime = &now
+
+	return r.Status().Update(context.TODO(), backup)
+}
+
+// pruneBackups deletes records of succeeded backups beyond retention, their
+// files are pruned by backup jobs
+func (r *ThermoCenterBackupReconciler) pruneBackups(i *kojedzinv1alpha1.ThermoCenter) error {
+	if i.Spec.Backup == nil {
+		return nil
+	}
+
+	list := &kojedzinv1alpha1.ThermoCenterBackupList{}
+	if err := r.List(context.TODO(), list, client.InNamespace(i.Namespace), client.MatchingLabels{ThermoCenterInstanceLabel: i.Name}); err != nil {
+		return err
+	}
+
+	var succeeded []kojedzinv1alpha1.ThermoCenterBackup
+	for _, backup := range list.Items {
+		if backup.Status.Phase == kojedzinv1alpha1.BackupSucceeded && backup.Status.CompletionTime != nil {
+			succeeded = append(succeeded, backup)
+		}
+	}
+
+	// Newest first
+	sort.Slice(succeeded, func(a, b int) bool {
+		return succeeded[b].Status.CompletionTime.Before(succeeded[a].Status.CompletionTime)
+	})
+
+	for idx := int(backupRetention(i)); idx < len(succeeded); idx++ {
+		if err := r.Delete(context.TODO(), &succeeded[idx]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// jobToBackup maps backup jobs to the backups named after them
+func (r *ThermoCenterBackupReconciler) jobToBackup(obj client.Object) []reconcile.Request {
+	if obj.GetLabels()["thermo-center-component"] != backupComponent {
+		return nil
+	}
+
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}}}
+}
+
+func (r *ThermoCenterBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kojedzinv1alpha1.ThermoCenterBackup{}).
+		Watches(&source.Kind{Type: &batchv1.Job{}}, handler.EnqueueRequestsFromMapFunc(r.jobToBackup)).
+		Complete(r)
+}
@@ -0,0 +1,264 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ThermoCenterRestoreReconciler reconciles a ThermoCenterRestore object. The
+// instance is paused by annotating it, then the backup is restored by a job
+// named after the restore. Finally, the instance is resumed at the database
+// version recorded in the restored dump, which gets migrated as usual.
+type ThermoCenterRestoreReconciler struct {
+	*ThermoCenterReconciler
+}
+
+// NewThermoCenterRestoreReconciler instantiates a new ThermoCenterRestore Reconciler
+func NewThermoCenterRestoreReconciler(mgr manager.Manager, opts Options) *ThermoCenterRestoreReconciler {
+	r := &ThermoCenterRestoreReconciler{
+		ThermoCenterReconciler: NewThermoCenterReconciler(mgr, opts),
+	}
+	r.Log = ctrl.Log.WithName("controllers").WithName("ThermoCenterRestore")
+
+	return r
+}
+
+// +kubebuilder:rbac:groups=kojedz.in,resources=thermocenterrestores,verbs=get;list;watch
+// +kubebuilder:rbac:groups=kojedz.in,resources=thermocenterrestores/status,verbs=get;update
+
+func (r *ThermoCenterRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := r.Log.WithValues("thermocenterrestore", req.NamespacedName)
+
+	restore := &kojedzinv1alpha1.ThermoCenterRestore{}
+	err := r.Get(ctx, req.NamespacedName, restore)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if restore.Status.Phase == kojedzinv1alpha1.RestoreSucceeded || restore.Status.Phase == kojedzinv1alpha1.RestoreFailed {
+		return ctrl.Result{}, nil
+	}
+
+	instance := &kojedzinv1alpha1.ThermoCenter{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: restore.Namespace, Name: restore.Spec.ThermoCenter}, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.failRestore(restore, "ThermoCenter not found")
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	switch restore.Status.Phase {
+	case "", kojedzinv1alpha1.RestorePending:
+		if other := instance.Annotations[thermoCenterRestoreAnnotation]; other != "" && other != restore.Name {
+			restore.Status.Phase = kojedzinv1alpha1.RestorePending
+			restore.Status.Message = "Waiting for restore " + other
+
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, r.Status().Update(ctx, restore)
+		}
+
+		loc, err := r.resolveBackupSource(restore.Namespace, &restore.Spec.BackupSource)
+		if err != nil {
+			return ctrl.Result{}, r.failRestore(restore, err.Error())
+		}
+
+		reqLogger.Info("Pausing instance for restore")
+
+		if instance.Annotations == nil {
+			instance.Annotations = make(map[string]string)
+		}
+		instance.Annotations[thermoCenterRestoreAnnotation] = restore.Name
+		if err = r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		now := metav1.Now()
+		restore.Status.Phase = kojedzinv1alpha1.RestorePausing
+		restore.Status.Message = ""
+		restore.Status.DatabaseVersion = loc.DatabaseVersion
+		restore.Status.StartTime = &now
+
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, r.Status().Update(ctx, restore)
+
+	case kojedzinv1alpha1.RestorePausing:
+		paused, err := r.pausedForRestore(instance)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if !paused {
+			reqLogger.Info("Waiting for components to stop")
+
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+
+		loc, err := r.resolveBackupSource(restore.Namespace, &restore.Spec.BackupSource)
+		if err != nil {
+			return ctrl.Result{}, r.resumeAndFailRestore(instance, restore, err.Error())
+		}
+
+		reqLogger.Info("Creating restore job")
+
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: restore.Namespace,
+				Name:      restore.Name,
+			},
+			Spec: r.restoreJobSpec(instance, loc),
+		}
+
+		if err = controllerutil.SetControllerReference(restore, job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err = r.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+			return ctrl.Result{}, err
+		}
+
+		restore.Status.Phase = kojedzinv1alpha1.RestoreRestoring
+		restore.Status.JobName = job.Name
+
+		return ctrl.Result{}, r.Status().Update(ctx, restore)
+
+	case kojedzinv1alpha1.RestoreRestoring:
+		job := &batchv1.Job{}
+		err = r.Get(ctx, types.NamespacedName{Namespace: restore.Namespace, Name: restore.Status.JobName}, job)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return ctrl.Result{}, r.resumeAndFailRestore(instance, restore, "Restore job disappeared")
+			}
+
+			return ctrl.Result{}, err
+		}
+
+		if job.Status.Succeeded > 0 {
+			version, err := r.restoredDatabaseVersion(job, restore.Status.DatabaseVersion)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+
+			reqLogger.Info("Restore succeeded, resuming instance", "databaseVersion", version)
+
+			// Migrations start from the restored version
+			restore.Status.DatabaseVersion = version
+			instance.Status.DatabaseVersion = version
+			instance.Status.Status = "restored"
+			if err = r.Status().Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+
+			if err = r.resume(instance); err != nil {
+				return ctrl.Result{}, err
+			}
+
+			restore.Status.Phase = kojedzinv1alpha1.RestoreMigrating
+
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, r.Status().Update(ctx, restore)
+		} else if job.Status.Failed > 0 {
+			reqLogger.Info("Restore failed, resuming instance")
+
+			return ctrl.Result{}, r.resumeAndFailRestore(instance, restore, "Restore job failed, database left unchanged")
+		}
+
+		return ctrl.Result{}, nil
+
+	case kojedzinv1alpha1.RestoreMigrating:
+		if instance.Status.Status != "ready" {
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+		}
+
+		now := metav1.Now()
+		restore.Status.Phase = kojedzinv1alpha1.RestoreSucceeded
+		restore.Status.CompletionTime = &now
+
+		return ctrl.Result{}, r.Status().Update(ctx, restore)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// resume removes the restore annotation from the instance
+func (r *ThermoCenterRestoreReconciler) resume(i *kojedzinv1alpha1.ThermoCenter) error {
+	if _, ok := i.Annotations[thermoCenterRestoreAnnotation]; !ok {
+		return nil
+	}
+
+	delete(i.Annotations, thermoCenterRestoreAnnotation)
+
+	return r.Update(context.TODO(), i)
+}
+
+func (r *ThermoCenterRestoreReconciler) resumeAndFailRestore(i *kojedzinv1alpha1.ThermoCenter, restore *kojedzinv1alpha1.ThermoCenterRestore, message string) error {
+	if err := r.resume(i); err != nil {
+		return err
+	}
+
+	return r.failRestore(restore, message)
+}
+
+func (r *ThermoCenterRestoreReconciler) failRestore(restore *kojedzinv1alpha1.ThermoCenterRestore, message string) error {
+	now := metav1.Now()
+
+	restore.Status.Phase = kojedzinv1alpha1.RestoreFailed
+	restore.Status.Message = message
+	restore.Status.CompletionTime = &now
+
+	return r.Status().Update(context.TODO(), restore)
+}
+
+// jobToRestore maps restore jobs to the restores named after them
+func (r *ThermoCenterRestoreReconciler) jobToRestore(obj client.Object) []reconcile.Request {
+	if obj.GetLabels()["thermo-center-component"] != "restore" {
+		return nil
+	}
+
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}}}
+}
+
+func (r *ThermoCenterRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kojedzinv1alpha1.ThermoCenterRestore{}).
+		Watches(&source.Kind{Type: &batchv1.Job{}}, handler.EnqueueRequestsFromMapFunc(r.jobToRestore)).
+		Complete(r)
+}
@@ -0,0 +1,362 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newSucceededBackup returns a backup record of the instance taken at version
+func newSucceededBackup(i *kojedzinv1alpha1.ThermoCenter, name, version string) *kojedzinv1alpha1.ThermoCenterBackup {
+	return &kojedzinv1alpha1.ThermoCenterBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      name,
+		},
+		Spec: kojedzinv1alpha1.ThermoCenterBackupSpec{
+			ThermoCenter: i.Name,
+		},
+		Status: kojedzinv1alpha1.ThermoCenterBackupStatus{
+			Phase:           kojedzinv1alpha1.BackupSucceeded,
+			Storage:         newBackupThermoCenter().Spec.Backup.Storage.DeepCopy(),
+			File:            name + ".dump",
+			DatabaseVersion: version,
+		},
+	}
+}
+
+// newJobPod returns a pod of a job, whose container terminated with message
+func newJobPod(job, message string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      job + "-abcde",
+			Labels:    map[string]string{"job-name": job},
+		},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name: "restore",
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{Message: message},
+				},
+			}},
+		},
+	}
+}
+
+func newTestRestoreReconciler(objs ...client.Object) *ThermoCenterRestoreReconciler {
+	return &ThermoCenterRestoreReconciler{
+		ThermoCenterReconciler: newTestReconciler(nil, objs...),
+	}
+}
+
+// reconcileRestore reconciles the restore, and returns its updated state
+func reconcileRestore(t *testing.T, r *ThermoCenterRestoreReconciler, name string) *kojedzinv1alpha1.ThermoCenterRestore {
+	t.Helper()
+
+	if _, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restore := &kojedzinv1alpha1.ThermoCenterRestore{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: name}, restore); err != nil {
+		t.Fatal(err)
+	}
+
+	return restore
+}
+
+func getThermoCenter(t *testing.T, r client.Client) *kojedzinv1alpha1.ThermoCenter {
+	t.Helper()
+
+	i := &kojedzinv1alpha1.ThermoCenter{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "tc"}, i); err != nil {
+		t.Fatal(err)
+	}
+
+	return i
+}
+
+func TestRestore(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Status.DatabaseVersion = "4.1.0"
+	i.Status.Status = "ready"
+
+	restore := &kojedzinv1alpha1.ThermoCenterRestore{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "restore"},
+		Spec: kojedzinv1alpha1.ThermoCenterRestoreSpec{
+			ThermoCenter: i.Name,
+			BackupSource: kojedzinv1alpha1.BackupSource{Backup: "nightly"},
+		},
+	}
+
+	api := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tc-api"},
+		Status:     appsv1.DeploymentStatus{Replicas: 1},
+	}
+
+	r := newTestRestoreReconciler(i, restore, api, newSucceededBackup(i, "nightly", "3.3.1"))
+
+	// Pausing
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestorePausing {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestorePausing)
+	}
+	if restore.Status.DatabaseVersion != "3.3.1" {
+		t.Errorf("recorded database version = %q, want 3.3.1", restore.Status.DatabaseVersion)
+	}
+	if got := getThermoCenter(t, r.Client).Annotations[thermoCenterRestoreAnnotation]; got != "restore" {
+		t.Errorf("restore annotation = %q, want restore", got)
+	}
+
+	// The job is not started while components are running
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestorePausing {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestorePausing)
+	}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "restore"}, &batchv1.Job{}); !errors.IsNotFound(err) {
+		t.Fatalf("restore job started while paused components are running: %v", err)
+	}
+
+	api.Status.Replicas = 0
+	if err := r.Update(context.TODO(), api); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restoring
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestoreRestoring {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestoreRestoring)
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: restore.Status.JobName}, job); err != nil {
+		t.Fatalf("restore job not created: %v", err)
+	}
+	if env := containerEnv(job.Spec.Template.Spec.Containers[0]); env["FILE"].Value != "nightly.dump" {
+		t.Errorf("restored file = %q, want nightly.dump", env["FILE"].Value)
+	}
+
+	// The job has not finished yet
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestoreRestoring {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestoreRestoring)
+	}
+
+	// The dump records a version differing from the backup record
+	job.Status.Succeeded = 1
+	if err := r.Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(context.TODO(), newJobPod(job.Name, `{"databaseVersion":"3.4.0"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrating
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestoreMigrating {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestoreMigrating)
+	}
+	if restore.Status.DatabaseVersion != "3.4.0" {
+		t.Errorf("restored database version = %q, want 3.4.0", restore.Status.DatabaseVersion)
+	}
+
+	instance := getThermoCenter(t, r.Client)
+	if _, ok := instance.Annotations[thermoCenterRestoreAnnotation]; ok {
+		t.Error("instance not resumed")
+	}
+	if instance.Status.DatabaseVersion != "3.4.0" {
+		t.Errorf("instance database version = %q, want 3.4.0", instance.Status.DatabaseVersion)
+	}
+	if !r.needsMigration(instance, "4.1.0", r.Log) {
+		t.Error("restored database is not migrated to the instance version")
+	}
+
+	// Waiting for the migration
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestoreMigrating {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestoreMigrating)
+	}
+
+	instance.Status.DatabaseVersion = "4.1.0"
+	instance.Status.Status = "ready"
+	if err := r.Status().Update(context.TODO(), instance); err != nil {
+		t.Fatal(err)
+	}
+
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestoreSucceeded {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestoreSucceeded)
+	}
+	if restore.Status.CompletionTime == nil {
+		t.Error("completion time not set")
+	}
+}
+
+func TestRestoreFailure(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Annotations = map[string]string{thermoCenterRestoreAnnotation: "restore"}
+	i.Status.DatabaseVersion = "4.1.0"
+
+	restore := &kojedzinv1alpha1.ThermoCenterRestore{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "restore"},
+		Spec: kojedzinv1alpha1.ThermoCenterRestoreSpec{
+			ThermoCenter: i.Name,
+			BackupSource: kojedzinv1alpha1.BackupSource{Backup: "nightly"},
+		},
+		Status: kojedzinv1alpha1.ThermoCenterRestoreStatus{
+			Phase:           kojedzinv1alpha1.RestoreRestoring,
+			JobName:         "restore",
+			DatabaseVersion: "3.3.1",
+		},
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "restore"},
+		Status:     batchv1.JobStatus{Failed: 1},
+	}
+
+	r := newTestRestoreReconciler(i, restore, job)
+
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestoreFailed {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestoreFailed)
+	}
+
+	instance := getThermoCenter(t, r.Client)
+	if _, ok := instance.Annotations[thermoCenterRestoreAnnotation]; ok {
+		t.Error("instance not resumed")
+	}
+	if instance.Status.DatabaseVersion != "4.1.0" {
+		t.Errorf("database version = %q, want it unchanged", instance.Status.DatabaseVersion)
+	}
+}
+
+func TestRestoreWaitsForOtherRestore(t *testing.T) {
+	i := newTestThermoCenter()
+	i.Annotations = map[string]string{thermoCenterRestoreAnnotation: "first"}
+
+	restore := &kojedzinv1alpha1.ThermoCenterRestore{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "second"},
+		Spec: kojedzinv1alpha1.ThermoCenterRestoreSpec{
+			ThermoCenter: i.Name,
+			BackupSource: kojedzinv1alpha1.BackupSource{Backup: "nightly"},
+		},
+	}
+
+	r := newTestRestoreReconciler(i, restore, newSucceededBackup(i, "nightly", "3.3.1"))
+
+	restore = reconcileRestore(t, r, "second")
+	if restore.Status.Phase != kojedzinv1alpha1.RestorePending {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestorePending)
+	}
+	if !strings.Contains(restore.Status.Message, "first") {
+		t.Errorf("message = %q", restore.Status.Message)
+	}
+	if got := getThermoCenter(t, r.Client).Annotations[thermoCenterRestoreAnnotation]; got != "first" {
+		t.Errorf("restore annotation = %q, want first", got)
+	}
+}
+
+func TestRestoreUnknownBackup(t *testing.T) {
+	i := newTestThermoCenter()
+
+	restore := &kojedzinv1alpha1.ThermoCenterRestore{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "restore"},
+		Spec: kojedzinv1alpha1.ThermoCenterRestoreSpec{
+			ThermoCenter: i.Name,
+			BackupSource: kojedzinv1alpha1.BackupSource{Backup: "missing"},
+		},
+	}
+
+	r := newTestRestoreReconciler(i, restore)
+
+	restore = reconcileRestore(t, r, "restore")
+	if restore.Status.Phase != kojedzinv1alpha1.RestoreFailed {
+		t.Fatalf("phase = %q, want %q", restore.Status.Phase, kojedzinv1alpha1.RestoreFailed)
+	}
+	if _, ok := getThermoCenter(t, r.Client).Annotations[thermoCenterRestoreAnnotation]; ok {
+		t.Error("instance paused for a failed restore")
+	}
+}
+
+func TestRestoredDatabaseVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []client.Object
+		want string
+	}{
+		{"version recorded in dump", []client.Object{newJobPod("restore", `{"databaseVersion":"3.4.0"}`)}, "3.4.0"},
+		{"dump without version", []client.Object{newJobPod("restore", `{"databaseVersion":""}`)}, "3.3.1"},
+		{"invalid message", []client.Object{newJobPod("restore", `3.4.0`)}, "3.3.1"},
+		{"no message", []client.Object{newJobPod("restore", "")}, "3.3.1"},
+		{"pod of other job", []client.Object{newJobPod("other", `{"databaseVersion":"3.4.0"}`)}, "3.3.1"},
+		{"pods removed", nil, "3.3.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestReconciler(nil, test.pods...)
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "restore"}}
+
+			got, err := r.restoredDatabaseVersion(job, "3.3.1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("version = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBackupRecordsDatabaseVersion(t *testing.T) {
+	i := newBackupThermoCenter()
+	i.Status.DatabaseVersion = "4.1.0"
+
+	r := newTestReconciler(nil)
+	spec := r.backupJobSpec(i)
+
+	dump := spec.Template.Spec.InitContainers[0]
+	if env := containerEnv(dump); env["DATABASE_VERSION"].Value != "4.1.0" {
+		t.Errorf("DATABASE_VERSION = %q, want 4.1.0", env["DATABASE_VERSION"].Value)
+	}
+	if script := dump.Command[len(dump.Command)-1]; !strings.Contains(script, "thermo_center_controller_version") {
+		t.Error("dump script does not record the database version")
+	}
+}
@@ -0,0 +1,182 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"github.com/rkojedzinszky/thermo-center-controller/registry"
+	"golang.org/x/mod/semver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Default interval between checks for new versions
+const defaultUpdateInterval = kojedzinv1alpha1.DefaultUpdateInterval
+
+// reconcileUpdatePolicy periodically lists image tags, and records the newest
+// version allowed by the update policy in status. The recorded version then
+// becomes the desired version, going through the normal migration path.
+func (r *ThermoCenterReconciler) reconcileUpdatePolicy(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) error {
+	policy := i.Spec.UpdatePolicy
+	if policy == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if i.Status.NextUpdateCheck != nil && now.Before(i.Status.NextUpdateCheck.Time) {
+		return nil
+	}
+
+	interval := defaultUpdateInterval
+	if policy.Interval != nil && policy.Interval.Duration > 0 {
+		interval = policy.Interval.Duration
+	}
+
+	next := now.Add(interval)
+
+	inWindow, windowStart, err := updateWindow(policy.Window, now)
+	if err != nil {
+		return err
+	}
+
+	if !inWindow {
+		next = windowStart
+	} else {
+		keychain, err := r.registryKeychain(i)
+		if err != nil {
+			return err
+		}
+
+		tags, err := r.listUpdateTags(i, keychain)
+		if err != nil {
+			l.Error(err, "Unable to list image tags")
+		} else if version := selectVersion(tags, policy.Channel, desiredVersion(i)); version != "" {
+			l.Info("Found version update", "version", version)
+
+			i.Status.UpdateVersion = version
+		}
+	}
+
+	i.Status.NextUpdateCheck = &metav1.Time{Time: next}
+
+	return r.Status().Update(context.TODO(), i)
+}
+
+// updateImages returns the api images new versions are looked for in. Default
+// images are named differently from v4, so tags are listed with the naming of
+// newer versions, and for older versions, also with the legacy naming.
+func (r *ThermoCenterReconciler) updateImages(i *kojedzinv1alpha1.ThermoCenter) []string {
+	if dep := r.api.getDeployment(i); dep != nil && dep.Image != "" {
+		return []string{registry.Name(r.rewriteImage(i, dep.Image))}
+	}
+
+	images := []string{r.rewriteImage(i, imagePrefix+r.api.component())}
+	if isLegacyVersion(desiredVersion(i)) {
+		images = append(images, r.rewriteImage(i, legacyImagePrefix+r.api.component()))
+	}
+
+	return images
+}
+
+// listUpdateTags lists tags of all images new versions are looked for in
+func (r *ThermoCenterReconciler) listUpdateTags(i *kojedzinv1alpha1.ThermoCenter, keychain registry.Keychain) ([]string, error) {
+	var tags []string
+
+	for _, image := range r.updateImages(i) {
+		t, err := r.registry.Tags(context.TODO(), image, keychain)
+		if err != nil {
+			return nil, fmt.Errorf("listing tags of %s: %w", image, err)
+		}
+
+		tags = append(tags, t...)
+	}
+
+	return tags, nil
+}
+
+// updateWindow reports whether now is within the update window, or otherwise
+// returns the start of the next window
+func updateWindow(w *kojedzinv1alpha1.UpdateWindow, now time.Time) (bool, time.Time, error) {
+	if w == nil {
+		return true, now, nil
+	}
+
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return false, now, fmt.Errorf("invalid update window start %q: %w", w.Start, err)
+	}
+
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+
+	for _, s := range []time.Time{today.AddDate(0, 0, -1), today} {
+		if !now.Before(s) && now.Before(s.Add(w.Duration.Duration)) {
+			return true, now, nil
+		}
+	}
+
+	if now.Before(today) {
+		return false, today, nil
+	}
+
+	return false, today.AddDate(0, 0, 1), nil
+}
+
+// selectVersion returns the newest release tag allowed by channel, newer than current
+func selectVersion(tags []string, channel string, current string) string {
+	if channel == kojedzinv1alpha1.UpdateChannelPatch && current == "" {
+		return ""
+	}
+
+	best := ""
+	for _, tag := range tags {
+		version := strings.TrimPrefix(tag, "v")
+		v := "v" + version
+
+		// Only full releases are considered
+		if !semver.IsValid(v) || semver.Prerelease(v) != "" || semver.Build(v) != "" || strings.Count(version, ".") != 2 {
+			continue
+		}
+
+		if channel == kojedzinv1alpha1.UpdateChannelPatch && semver.MajorMinor(v) != semver.MajorMinor("v"+current) {
+			continue
+		}
+
+		if current != "" && semver.Compare(v, "v"+current) <= 0 {
+			continue
+		}
+
+		if best == "" || semver.Compare(v, "v"+best) > 0 {
+			best = version
+		}
+	}
+
+	return best
+}
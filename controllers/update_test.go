@@ -0,0 +1,166 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelectVersion(t *testing.T) {
+	tags := []string{"latest", "3.3.1", "3.3.2", "v3.4.0", "4.0.0-rc1", "4.0.0", "4.1", "4.1.0+build", "4.0.1", "main"}
+
+	tests := []struct {
+		channel string
+		current string
+		want    string
+	}{
+		{kojedzinv1alpha1.UpdateChannelStable, "3.3.1", "4.0.1"},
+		{kojedzinv1alpha1.UpdateChannelStable, "", "4.0.1"},
+		{kojedzinv1alpha1.UpdateChannelStable, "4.0.1", ""},
+		{kojedzinv1alpha1.UpdateChannelPatch, "3.3.1", "3.3.2"},
+		{kojedzinv1alpha1.UpdateChannelPatch, "3.4.0", ""},
+		{kojedzinv1alpha1.UpdateChannelPatch, "4.0.0", "4.0.1"},
+		{kojedzinv1alpha1.UpdateChannelPatch, "", ""},
+	}
+
+	for _, test := range tests {
+		if got := selectVersion(tags, test.channel, test.current); got != test.want {
+			t.Errorf("selectVersion(%s, %q) = %q, want %q", test.channel, test.current, got, test.want)
+		}
+	}
+}
+
+func TestUpdateWindow(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2021, 3, 10, hour, minute, 0, 0, time.UTC)
+	}
+
+	window := &kojedzinv1alpha1.UpdateWindow{Start: "22:00", Duration: metav1.Duration{Duration: 4 * time.Hour}}
+
+	tests := []struct {
+		name     string
+		window   *kojedzinv1alpha1.UpdateWindow
+		now      time.Time
+		inWindow bool
+		start    time.Time
+	}{
+		{"no window", nil, day(12, 0), true, day(12, 0)},
+		{"before window", window, day(12, 0), false, day(22, 0)},
+		{"start of window", window, day(22, 0), true, day(22, 0)},
+		{"window of previous day", window, day(1, 30), true, day(1, 30)},
+		{"after window of previous day", window, day(2, 0), false, day(22, 0)},
+		{"non-UTC time", window, day(23, 0).In(time.FixedZone("CET", 3600)), true, day(23, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inWindow, start, err := updateWindow(test.window, test.now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if inWindow != test.inWindow || !start.Equal(test.start) {
+				t.Errorf("updateWindow() = %v, %v, want %v, %v", inWindow, start, test.inWindow, test.start)
+			}
+		})
+	}
+
+	if _, _, err := updateWindow(&kojedzinv1alpha1.UpdateWindow{Start: "25:00"}, day(0, 0)); err == nil {
+		t.Error("expected an error for invalid start")
+	}
+}
+
+func TestReconcileUpdatePolicy(t *testing.T) {
+	reg := &fakeRegistry{tags: map[string][]string{
+		"rkojedzinszky/thermo-center-api":                {"3.3.1", "3.3.2"},
+		"ghcr.io/rkojedzinszky/thermo-center-api":        {"3.3.2", "4.0.0", "4.1.0"},
+		"mirror.example.com/rkojedzinszky/thermo-center": {"4.2.0"},
+	}}
+
+	tests := []struct {
+		name    string
+		version string
+		channel string
+		image   string
+		want    string
+	}{
+		{"legacy version sees new naming", "3.3.1", kojedzinv1alpha1.UpdateChannelStable, "", "4.1.0"},
+		{"legacy patch", "3.3.1", kojedzinv1alpha1.UpdateChannelPatch, "", "3.3.2"},
+		{"current version", "4.0.0", kojedzinv1alpha1.UpdateChannelStable, "", "4.1.0"},
+		{"custom image", "4.0.0", kojedzinv1alpha1.UpdateChannelStable, "mirror.example.com/rkojedzinszky/thermo-center:4.0.0", "4.2.0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			i.Spec.Version = stringPtr(test.version)
+			i.Spec.UpdatePolicy = &kojedzinv1alpha1.UpdatePolicy{Channel: test.channel}
+			if test.image != "" {
+				i.Spec.API = &kojedzinv1alpha1.Deployment{Image: test.image}
+			}
+
+			r := newTestReconciler(reg, i)
+
+			before := time.Now()
+			if err := r.reconcileUpdatePolicy(i, r.Log); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if i.Status.UpdateVersion != test.want {
+				t.Errorf("update version = %q, want %q", i.Status.UpdateVersion, test.want)
+			}
+			if i.Status.NextUpdateCheck == nil || i.Status.NextUpdateCheck.Time.Before(before.Add(defaultUpdateInterval)) {
+				t.Errorf("next update check = %v, want after %v", i.Status.NextUpdateCheck, before.Add(defaultUpdateInterval))
+			}
+		})
+	}
+}
+
+func TestReconcileUpdatePolicyOutsideWindow(t *testing.T) {
+	reg := &fakeRegistry{}
+
+	i := newTestThermoCenter()
+	start := time.Now().UTC().Add(2 * time.Hour).Format("15:04")
+	i.Spec.UpdatePolicy = &kojedzinv1alpha1.UpdatePolicy{
+		Channel: kojedzinv1alpha1.UpdateChannelStable,
+		Window:  &kojedzinv1alpha1.UpdateWindow{Start: start, Duration: metav1.Duration{Duration: time.Hour}},
+	}
+
+	r := newTestReconciler(reg, i)
+	if err := r.reconcileUpdatePolicy(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(reg.keychains) != 0 {
+		t.Error("registry queried outside the update window")
+	}
+	if i.Status.NextUpdateCheck == nil || i.Status.NextUpdateCheck.Format("15:04") != start {
+		t.Errorf("next update check = %v, want window start %s", i.Status.NextUpdateCheck, start)
+	}
+}
@@ -29,8 +29,10 @@ import (
 	"strings"
 
 	"golang.org/x/mod/semver"
+	v1 "k8s.io/api/core/v1"
 
 	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"github.com/rkojedzinszky/thermo-center-controller/registry"
 )
 
 const (
@@ -50,6 +52,23 @@ func replicas(r int32) *int32 {
 	return &r
 }
 
+// deploymentWithDefaults returns dep with image and replicas defaulting to those of defaults
+func deploymentWithDefaults(dep *kojedzinv1alpha1.Deployment, defaults *kojedzinv1alpha1.Deployment) *kojedzinv1alpha1.Deployment {
+	if dep == nil {
+		return defaults
+	}
+
+	dep = dep.DeepCopy()
+	if dep.Image == "" {
+		dep.Image = defaults.Image
+	}
+	if dep.Replicas == nil {
+		dep.Replicas = defaults.Replicas
+	}
+
+	return dep
+}
+
 // Generate image name based on desired version
 func setImageTag(i *kojedzinv1alpha1.ThermoCenter, image string) string {
 	if strings.IndexByte(image, ':') != -1 {
@@ -58,17 +77,134 @@ func setImageTag(i *kojedzinv1alpha1.ThermoCenter, image string) string {
 
 	tag := "latest"
 
-	if i.Spec.Version != nil && *i.Spec.Version != "" {
-		tag = *i.Spec.Version
+	if version := imageVersion(i); version != "" {
+		tag = version
 	}
 
 	return image + ":" + tag
 }
 
+const (
+	// imagePrefix names default images from v4
+	imagePrefix = "ghcr.io/rkojedzinszky/thermo-center-"
+
+	// legacyImagePrefix names default images before v4
+	legacyImagePrefix = "rkojedzinszky/thermo-center-"
+)
+
+// isLegacyVersion reports whether a version is published with legacy image names
+func isLegacyVersion(version string) bool {
+	return version != "" && semver.Compare(fmt.Sprintf("v%s", version), "v4") < 0
+}
+
 func getImagePrefix(i *kojedzinv1alpha1.ThermoCenter) string {
-	if i.Spec.Version != nil && semver.Compare(fmt.Sprintf("v%s", *i.Spec.Version), "v4") < 0 {
-		return "rkojedzinszky/thermo-center-"
+	if isLegacyVersion(imageVersion(i)) {
+		return legacyImagePrefix
 	}
 
-	return "ghcr.io/rkojedzinszky/thermo-center-"
+	return imagePrefix
+}
+
+// desiredVersion returns the version the instance should run, which is the
+// version chosen by the update policy, if newer than the specified one
+func desiredVersion(i *kojedzinv1alpha1.ThermoCenter) string {
+	version := ""
+	if i.Spec.Version != nil {
+		version = *i.Spec.Version
+	}
+
+	if i.Spec.UpdatePolicy != nil && i.Status.UpdateVersion != "" {
+		if version == "" || semver.Compare("v"+i.Status.UpdateVersion, "v"+version) > 0 {
+			return i.Status.UpdateVersion
+		}
+	}
+
+	return version
+}
+
+// imageVersion returns the version components are deployed with. After a failed
+// upgrade verification components may be kept at the previous version.
+func imageVersion(i *kojedzinv1alpha1.ThermoCenter) string {
+	version := desiredVersion(i)
+
+	if version != "" && i.Status.RollbackVersion != "" && i.Status.FailedVersion == version {
+		return i.Status.RollbackVersion
+	}
+
+	return version
+}
+
+// isDigest reports whether a database version is an image digest, recorded
+// when tracking latest images
+func isDigest(version string) bool {
+	return strings.HasPrefix(version, "sha256:")
+}
+
+// rewriteImage applies instance and then controller wide rewrite rules to an image name
+func (r *ThermoCenterReconciler) rewriteImage(i *kojedzinv1alpha1.ThermoCenter, image string) string {
+	rules := r.imageRewrites
+	if i.Spec.ImageRegistry != nil {
+		rules = append(append([]kojedzinv1alpha1.ImageRewrite{}, i.Spec.ImageRegistry.Rewrites...), rules...)
+	}
+
+	full := registry.FullName(image)
+
+	for _, rule := range rules {
+		if rule.From == "" {
+			continue
+		}
+
+		if hasImagePrefix(image, rule.From) {
+			return rule.To + image[len(rule.From):]
+		}
+
+		if hasImagePrefix(full, rule.From) {
+			return rule.To + full[len(rule.From):]
+		}
+	}
+
+	return image
+}
+
+// hasImagePrefix reports whether image starts with prefix, ending at a path,
+// tag or digest separator, so that ghcr.io/rk does not match ghcr.io/rkojedzinszky/
+func hasImagePrefix(image, prefix string) bool {
+	if !strings.HasPrefix(image, prefix) {
+		return false
+	}
+
+	if len(image) == len(prefix) || strings.ContainsAny(prefix[len(prefix)-1:], "/:@") {
+		return true
+	}
+
+	return strings.ContainsAny(image[len(prefix):len(prefix)+1], "/:@")
+}
+
+// setImagePullOptions sets pull secrets and pull policy on a pod spec
+func setImagePullOptions(i *kojedzinv1alpha1.ThermoCenter, ps *v1.PodSpec) {
+	if i.Spec.ImageRegistry == nil {
+		return
+	}
+
+	ps.ImagePullSecrets = append(ps.ImagePullSecrets, i.Spec.ImageRegistry.PullSecrets...)
+
+	for c := range ps.InitContainers {
+		ps.InitContainers[c].ImagePullPolicy = i.Spec.ImageRegistry.PullPolicy
+	}
+	for c := range ps.Containers {
+		ps.Containers[c].ImagePullPolicy = i.Spec.ImageRegistry.PullPolicy
+	}
+}
+
+// secretKeyEnv returns an environment variable taken from a Secret key
+func secretKeyEnv(name string, secret string, key string) v1.EnvVar {
+	return v1.EnvVar{
+		Name: name,
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: secret},
+				Key:                  key,
+			},
+		},
+	}
 }
@@ -0,0 +1,97 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+)
+
+func TestRewriteImage(t *testing.T) {
+	controllerRules := []kojedzinv1alpha1.ImageRewrite{
+		{From: "docker.io/", To: "mirror.example.com/dockerhub/"},
+	}
+
+	tests := []struct {
+		name  string
+		rules []kojedzinv1alpha1.ImageRewrite
+		image string
+		want  string
+	}{
+		{"registry prefix", []kojedzinv1alpha1.ImageRewrite{{From: "ghcr.io/", To: "mirror.example.com/ghcr/"}}, "ghcr.io/rkojedzinszky/thermo-center-api:4.1.0", "mirror.example.com/ghcr/rkojedzinszky/thermo-center-api:4.1.0"},
+		{"repository prefix", []kojedzinv1alpha1.ImageRewrite{{From: "ghcr.io/rkojedzinszky", To: "mirror.example.com/tc"}}, "ghcr.io/rkojedzinszky/thermo-center-api:4.1.0", "mirror.example.com/tc/thermo-center-api:4.1.0"},
+		{"partial path component", []kojedzinv1alpha1.ImageRewrite{{From: "ghcr.io/rk", To: "mirror.example.com/rk"}}, "ghcr.io/rkojedzinszky/thermo-center-api:4.1.0", "ghcr.io/rkojedzinszky/thermo-center-api:4.1.0"},
+		{"partial registry name", []kojedzinv1alpha1.ImageRewrite{{From: "ghcr.io", To: "mirror.example.com"}}, "ghcr.iox/image:1", "ghcr.iox/image:1"},
+		{"image before tag", []kojedzinv1alpha1.ImageRewrite{{From: "docker.io/library/memcached", To: "mirror.example.com/memcached"}}, "memcached:1.6.6-alpine", "mirror.example.com/memcached:1.6.6-alpine"},
+		{"image before digest", []kojedzinv1alpha1.ImageRewrite{{From: "redis", To: "mirror.example.com/redis"}}, "redis@sha256:abcd", "mirror.example.com/redis@sha256:abcd"},
+		{"longer image name", []kojedzinv1alpha1.ImageRewrite{{From: "docker.io/library/memcached", To: "mirror.example.com/memcached"}}, "memcached-exporter:0.9", "mirror.example.com/dockerhub/library/memcached-exporter:0.9"},
+		{"exact name", []kojedzinv1alpha1.ImageRewrite{{From: "postgres:13-alpine", To: "mirror.example.com/postgres:13-alpine"}}, "postgres:13-alpine", "mirror.example.com/postgres:13-alpine"},
+		{"tag prefix", []kojedzinv1alpha1.ImageRewrite{{From: "postgres:13", To: "postgres:14"}}, "postgres:13-alpine", "mirror.example.com/dockerhub/library/postgres:13-alpine"},
+		{"empty rule skipped", []kojedzinv1alpha1.ImageRewrite{{From: "", To: "mirror.example.com/"}}, "ghcr.io/rkojedzinszky/thermo-center-ui", "ghcr.io/rkojedzinszky/thermo-center-ui"},
+		{"instance rules first", []kojedzinv1alpha1.ImageRewrite{{From: "docker.io/", To: "local.example.com/"}}, "memcached", "local.example.com/library/memcached"},
+		{"controller rules", nil, "eclipse-mosquitto:1.6.12", "mirror.example.com/dockerhub/library/eclipse-mosquitto:1.6.12"},
+		{"no matching rule", nil, "quay.io/prometheus/node-exporter", "quay.io/prometheus/node-exporter"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestThermoCenter()
+			i.Spec.ImageRegistry = &kojedzinv1alpha1.ImageRegistry{Rewrites: test.rules}
+
+			r := newTestReconciler(nil)
+			r.imageRewrites = controllerRules
+
+			if got := r.rewriteImage(i, test.image); got != test.want {
+				t.Errorf("rewriteImage(%q) = %q, want %q", test.image, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHasImagePrefix(t *testing.T) {
+	tests := []struct {
+		image  string
+		prefix string
+		want   bool
+	}{
+		{"ghcr.io/rkojedzinszky/api", "ghcr.io/", true},
+		{"ghcr.io/rkojedzinszky/api", "ghcr.io", true},
+		{"ghcr.io/rkojedzinszky/api", "ghcr.io/rkojedzinszky", true},
+		{"ghcr.io/rkojedzinszky/api", "ghcr.io/rk", false},
+		{"ghcr.io/rkojedzinszky/api", "ghcr.io/rkojedzinszky/api", true},
+		{"ghcr.io/rkojedzinszky/api:4.1.0", "ghcr.io/rkojedzinszky/api", true},
+		{"ghcr.io/rkojedzinszky/api-server", "ghcr.io/rkojedzinszky/api", false},
+		{"ghcr.io/rkojedzinszky/api:4.1.0", "ghcr.io/rkojedzinszky/api:", true},
+		{"ghcr.io/rkojedzinszky/api:4.1.0", "ghcr.io/rkojedzinszky/api:4.1", false},
+		{"ghcr.io/rkojedzinszky/api", "quay.io/", false},
+	}
+
+	for _, test := range tests {
+		if got := hasImagePrefix(test.image, test.prefix); got != test.want {
+			t.Errorf("hasImagePrefix(%q, %q) = %v, want %v", test.image, test.prefix, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	"golang.org/x/mod/semver"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const verificationImage = "curlimages/curl:7.78.0"
+
+// Checks api health, and that ws answers HTTP requests
+const verificationScript = `set -e
+curl -fsS --retry 10 --retry-connrefused --retry-delay 3 -o /dev/null "http://${API_HOST}:8080/healthz"
+code=$(curl -sS --retry 10 --retry-connrefused --retry-delay 3 --max-time 10 -o /dev/null -w '%{http_code}' "http://${WS_HOST}:8080/ws/")
+echo "ws responded with ${code}"
+[ "${code}" -gt 0 ] && [ "${code}" -lt 500 ]
+`
+
+// reconcileUpgradeVerification runs a verification Job once components are
+// rolled out at a new version, and reverts them if the verification fails
+func (r *ThermoCenterReconciler) reconcileUpgradeVerification(i *kojedzinv1alpha1.ThermoCenter, l logr.Logger) (ctrl.Result, error) {
+	version := desiredVersion(i)
+	if i.Spec.UpgradeVerification == nil || version == "" {
+		return ctrl.Result{}, nil
+	}
+	if i.Status.VerifiedVersion == version || i.Status.FailedVersion == version {
+		return ctrl.Result{}, nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterVerificationJobName(i)}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		// Wait for components to be rolled out
+		for _, rec := range []deploymentReconciler{r.api, r.ws} {
+			rolledOut, err := r.deploymentRolledOut(i, rec)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+
+			if !rolledOut {
+				l.Info("Waiting for rollout before verification", "component", rec.component())
+
+				return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+			}
+		}
+
+		return ctrl.Result{}, r.createVerificationJob(i, version, l)
+	}
+
+	// Stale job, left from verifying another version
+	if job.Annotations[thermoCenterDBVersionAnnotation] != version {
+		return ctrl.Result{}, r.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	}
+
+	if job.Status.Succeeded > 0 {
+		l.Info("Upgrade verification succeeded", "version", version)
+
+		i.Status.VerifiedVersion = version
+		meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+			Type:               kojedzinv1alpha1.ConditionUpgradeFailed,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: i.Generation,
+			Reason:             "Verified",
+			Message:            fmt.Sprintf("Version %s passed verification", version),
+		})
+	} else if job.Status.Failed > 0 {
+		l.Info("Upgrade verification failed", "version", version)
+
+		message := fmt.Sprintf("Version %s failed verification", version)
+		i.Status.FailedVersion = version
+		i.Status.RollbackVersion = ""
+
+		if i.Spec.UpgradeVerification.Rollback {
+			if rollbackPermitted(i.Status.PreviousVersion, version) {
+				i.Status.RollbackVersion = i.Status.PreviousVersion
+				message += fmt.Sprintf(", reverted to %s", i.Status.PreviousVersion)
+			} else {
+				message += ", rollback not permitted by database schema"
+			}
+		}
+
+		meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+			Type:               kojedzinv1alpha1.ConditionUpgradeFailed,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: i.Generation,
+			Reason:             "VerificationFailed",
+			Message:            message,
+		})
+	} else {
+		return ctrl.Result{}, nil
+	}
+
+	if err = r.Status().Update(context.TODO(), i); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Delete job. This will trigger new reconcile cycle, reverting components if needed.
+	return ctrl.Result{}, r.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+}
+
+func (r *ThermoCenterReconciler) createVerificationJob(i *kojedzinv1alpha1.ThermoCenter, version string, l logr.Logger) error {
+	l.Info("Creating upgrade verification job", "version", version)
+
+	image := i.Spec.UpgradeVerification.Image
+	if image == "" {
+		image = verificationImage
+	}
+
+	activeDeadlineSeconds := int64(kojedzinv1alpha1.DefaultVerificationDeadlineSeconds)
+	if i.Spec.UpgradeVerification.ActiveDeadlineSeconds != nil {
+		activeDeadlineSeconds = *i.Spec.UpgradeVerification.ActiveDeadlineSeconds
+	}
+
+	backoffLimit := int32(0)
+	enableServiceLinks := false
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+	runAsUser := int64(100)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      thermoCenterVerificationJobName(i),
+			Annotations: map[string]string{
+				thermoCenterDBVersionAnnotation: version,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			BackoffLimit:          &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labelsForComponent(i, "verify"),
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{
+						Name:    "verify",
+						Image:   r.rewriteImage(i, image),
+						Command: []string{"sh", "-c", verificationScript},
+						Env: []v1.EnvVar{
+							{
+								Name:  "API_HOST",
+								Value: thermoCenterServiceName(i, r.api),
+							},
+							{
+								Name:  "WS_HOST",
+								Value: thermoCenterServiceName(i, r.ws),
+							},
+						},
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								v1.ResourceCPU:    resource.MustParse("1m"),
+								v1.ResourceMemory: resource.MustParse("8Mi"),
+							},
+						},
+						SecurityContext: &v1.SecurityContext{
+							AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+						},
+					}},
+					EnableServiceLinks: &enableServiceLinks,
+					RestartPolicy:      v1.RestartPolicyNever,
+					SecurityContext: &v1.PodSecurityContext{
+						RunAsNonRoot: &runAsNonRoot,
+						RunAsUser:    &runAsUser,
+					},
+				},
+			},
+		},
+	}
+
+	setImagePullOptions(i, &job.Spec.Template.Spec)
+
+	if err := controllerutil.SetControllerReference(i, job, r.Scheme); err != nil {
+		return err
+	}
+
+	return r.Create(context.TODO(), job)
+}
+
+// deploymentRolledOut reports whether all replicas of a component are updated and available
+func (r *ThermoCenterReconciler) deploymentRolledOut(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler) (bool, error) {
+	deployment := &appsv1.Deployment{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterDeploymentName(i, rec)}, deployment)
+	if err != nil {
+		return false, err
+	}
+
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == desired &&
+		deployment.Status.AvailableReplicas == desired &&
+		deployment.Status.Replicas == desired, nil
+}
+
+// rollbackPermitted reports whether components may be reverted from version
+// to previous after the database was migrated. Schema changes are assumed
+// to be backwards compatible within the same major version only.
+func rollbackPermitted(previous, version string) bool {
+	if previous == "" || previous == version {
+		return false
+	}
+
+	return semver.Major("v"+previous) != "" && semver.Major("v"+previous) == semver.Major("v"+version)
+}
@@ -0,0 +1,276 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRollbackPermitted(t *testing.T) {
+	tests := []struct {
+		previous string
+		version  string
+		want     bool
+	}{
+		{"4.0.0", "4.1.0", true},
+		{"4.1.0", "4.1.1", true},
+		{"4.1.1", "4.1.0", true},
+		{"3.3.1", "4.0.0", false},
+		{"4.1.0", "5.0.0", false},
+		{"", "4.1.0", false},
+		{"4.1.0", "4.1.0", false},
+		{"sha256:abcd", "4.1.0", false},
+		{"latest", "4.1.0", false},
+	}
+
+	for _, test := range tests {
+		if got := rollbackPermitted(test.previous, test.version); got != test.want {
+			t.Errorf("rollbackPermitted(%q, %q) = %v, want %v", test.previous, test.version, got, test.want)
+		}
+	}
+}
+
+// newVerifiedThermoCenter returns an instance upgraded from previous to 4.1.0,
+// with verification and rollback enabled
+func newVerifiedThermoCenter(previous string) *kojedzinv1alpha1.ThermoCenter {
+	i := newTestThermoCenter()
+	i.Spec.UpgradeVerification = &kojedzinv1alpha1.UpgradeVerification{Rollback: true}
+	i.Status.DatabaseVersion = "4.1.0"
+	i.Status.PreviousVersion = previous
+
+	return i
+}
+
+// newRolledOutDeployment returns the deployment of a component, rolled out as desired
+func newRolledOutDeployment(i *kojedzinv1alpha1.ThermoCenter, rec deploymentReconciler, rolledOut bool) *appsv1.Deployment {
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: i.Namespace, Name: thermoCenterDeploymentName(i, rec)},
+		Spec:       appsv1.DeploymentSpec{Replicas: replicas(1)},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+	if !rolledOut {
+		d.Status.UpdatedReplicas = 0
+	}
+
+	return d
+}
+
+func newVerificationJob(i *kojedzinv1alpha1.ThermoCenter, version string, status batchv1.JobStatus) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   i.Namespace,
+			Name:        thermoCenterVerificationJobName(i),
+			Annotations: map[string]string{thermoCenterDBVersionAnnotation: version},
+		},
+		Status: status,
+	}
+}
+
+func TestUpgradeVerificationJob(t *testing.T) {
+	i := newVerifiedThermoCenter("4.0.0")
+	api := newRolledOutDeployment(i, &apiReconciler{}, true)
+	ws := newRolledOutDeployment(i, &wsReconciler{}, false)
+	r := newTestReconciler(nil, i, api, ws)
+
+	getJob := func() (*batchv1.Job, error) {
+		job := &batchv1.Job{}
+
+		return job, r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterVerificationJobName(i)}, job)
+	}
+
+	// Waiting for ws to roll out
+	result, err := r.reconcileUpgradeVerification(i, r.Log)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter == 0 {
+		t.Error("rollout is not waited for")
+	}
+	if _, err = getJob(); !errors.IsNotFound(err) {
+		t.Fatalf("verification started before rollout: %v", err)
+	}
+
+	ws.Status.UpdatedReplicas = 1
+	if err = r.Update(context.TODO(), ws); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.reconcileUpgradeVerification(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	job, err := getJob()
+	if err != nil {
+		t.Fatalf("verification job not created: %v", err)
+	}
+	if got := job.Annotations[thermoCenterDBVersionAnnotation]; got != "4.1.0" {
+		t.Errorf("verified version = %q, want 4.1.0", got)
+	}
+	if got := *job.Spec.ActiveDeadlineSeconds; got != kojedzinv1alpha1.DefaultVerificationDeadlineSeconds {
+		t.Errorf("active deadline = %d, want %d", got, kojedzinv1alpha1.DefaultVerificationDeadlineSeconds)
+	}
+
+	env := containerEnv(job.Spec.Template.Spec.Containers[0])
+	if env["API_HOST"].Value != "tc-api" || env["WS_HOST"].Value != "tc-ws" {
+		t.Errorf("environment = %v", env)
+	}
+}
+
+func TestUpgradeVerificationResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		rollback bool
+		status   batchv1.JobStatus
+		verified bool
+		// RollbackVersion expected after a failure
+		rollbackVersion string
+		// imageVersion expected after the result is recorded
+		imageVersion string
+		message      string
+	}{
+		{
+			name:         "succeeded",
+			previous:     "4.0.0",
+			rollback:     true,
+			status:       batchv1.JobStatus{Succeeded: 1},
+			verified:     true,
+			imageVersion: "4.1.0",
+			message:      "passed verification",
+		},
+		{
+			name:            "failed, reverted within the major version",
+			previous:        "4.0.0",
+			rollback:        true,
+			status:          batchv1.JobStatus{Failed: 1},
+			rollbackVersion: "4.0.0",
+			imageVersion:    "4.0.0",
+			message:         "reverted to 4.0.0",
+		},
+		{
+			name:         "failed, rollback refused across major versions",
+			previous:     "3.3.1",
+			rollback:     true,
+			status:       batchv1.JobStatus{Failed: 1},
+			imageVersion: "4.1.0",
+			message:      "rollback not permitted",
+		},
+		{
+			name:         "failed, rollback refused without a previous version",
+			rollback:     true,
+			status:       batchv1.JobStatus{Failed: 1},
+			imageVersion: "4.1.0",
+			message:      "rollback not permitted",
+		},
+		{
+			name:         "failed, rollback disabled",
+			previous:     "4.0.0",
+			status:       batchv1.JobStatus{Failed: 1},
+			imageVersion: "4.1.0",
+			message:      "Version 4.1.0 failed verification",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newVerifiedThermoCenter(test.previous)
+			i.Spec.UpgradeVerification.Rollback = test.rollback
+			r := newTestReconciler(nil, i, newVerificationJob(i, "4.1.0", test.status))
+
+			if _, err := r.reconcileUpgradeVerification(i, r.Log); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			i = getThermoCenter(t, r.Client)
+
+			if test.verified {
+				if i.Status.VerifiedVersion != "4.1.0" || i.Status.FailedVersion != "" {
+					t.Errorf("verified = %q, failed = %q", i.Status.VerifiedVersion, i.Status.FailedVersion)
+				}
+			} else if i.Status.FailedVersion != "4.1.0" || i.Status.VerifiedVersion != "" {
+				t.Errorf("verified = %q, failed = %q", i.Status.VerifiedVersion, i.Status.FailedVersion)
+			}
+
+			if i.Status.RollbackVersion != test.rollbackVersion {
+				t.Errorf("rollback version = %q, want %q", i.Status.RollbackVersion, test.rollbackVersion)
+			}
+			if got := imageVersion(i); got != test.imageVersion {
+				t.Errorf("image version = %q, want %q", got, test.imageVersion)
+			}
+
+			cond := meta.FindStatusCondition(i.Status.Conditions, kojedzinv1alpha1.ConditionUpgradeFailed)
+			if cond == nil {
+				t.Fatal("UpgradeFailed condition not set")
+			}
+			if failed := cond.Status == metav1.ConditionTrue; failed == test.verified {
+				t.Errorf("UpgradeFailed = %s", cond.Status)
+			}
+			if !strings.Contains(cond.Message, test.message) {
+				t.Errorf("message = %q, want it to contain %q", cond.Message, test.message)
+			}
+
+			// The job is removed, and the version is not verified again
+			job := &batchv1.Job{}
+			if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterVerificationJobName(i)}, job); !errors.IsNotFound(err) {
+				t.Errorf("verification job not deleted: %v", err)
+			}
+
+			if _, err := r.reconcileUpgradeVerification(i, r.Log); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterVerificationJobName(i)}, job); !errors.IsNotFound(err) {
+				t.Errorf("version verified again: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpgradeVerificationStaleJob(t *testing.T) {
+	i := newVerifiedThermoCenter("4.0.0")
+	r := newTestReconciler(nil, i, newVerificationJob(i, "4.0.0", batchv1.JobStatus{Failed: 1}))
+
+	if _, err := r.reconcileUpgradeVerification(i, r.Log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: i.Namespace, Name: thermoCenterVerificationJobName(i)}, &batchv1.Job{}); !errors.IsNotFound(err) {
+		t.Errorf("stale verification job not deleted: %v", err)
+	}
+
+	i = getThermoCenter(t, r.Client)
+	if i.Status.FailedVersion != "" || i.Status.RollbackVersion != "" {
+		t.Errorf("stale job result recorded: %+v", i.Status)
+	}
+}
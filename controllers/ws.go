@@ -62,7 +62,7 @@ func (ws *wsReconciler) customizePodSpec(r *ThermoCenterReconciler, i *kojedzinv
 		},
 	)
 
-	r.mqtt.setEnvironment(r, i, &ps.Containers[0].Env)
+	r.mqtt.setEnvironment(r, i, ps)
 
 	return ps
 }
@@ -9,6 +9,7 @@ require (
 	k8s.io/apimachinery v0.20.2
 	k8s.io/client-go v0.20.2
 	sigs.k8s.io/controller-runtime v0.8.0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace github.com/go-logr/zapr => github.com/go-logr/zapr v0.2.0
@@ -26,7 +26,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -34,7 +37,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	kojedzinv1alpha1 "github.com/rkojedzinszky/thermo-center-controller/api/v1alpha1"
+	kojedzinv1beta1 "github.com/rkojedzinszky/thermo-center-controller/api/v1beta1"
 	"github.com/rkojedzinszky/thermo-center-controller/controllers"
+	"github.com/rkojedzinszky/thermo-center-controller/registry"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -42,18 +47,52 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// imageRewrites collects repeated -image-rewrite flags
+type imageRewrites []kojedzinv1alpha1.ImageRewrite
+
+func (f *imageRewrites) String() string {
+	rules := make([]string, 0, len(*f))
+	for _, rule := range *f {
+		rules = append(rules, rule.From+"="+rule.To)
+	}
+
+	return strings.Join(rules, ",")
+}
+
+func (f *imageRewrites) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid image rewrite %q, expected from=to", value)
+	}
+
+	*f = append(*f, kojedzinv1alpha1.ImageRewrite{From: parts[0], To: parts[1]})
+
+	return nil
+}
+
 func init() {
 	_ = kojedzinv1alpha1.AddToScheme(clientgoscheme.Scheme)
+	_ = kojedzinv1beta1.AddToScheme(clientgoscheme.Scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var registryPlainHTTP bool
+	var registryTimeout time.Duration
+	var registryCacheTTL time.Duration
+	var rewrites imageRewrites
+	var enableWebhooks bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&registryPlainHTTP, "registry-plain-http", false, "Access image registries over plain HTTP.")
+	flag.DurationVar(&registryTimeout, "registry-timeout", 30*time.Second, "Timeout of image registry requests.")
+	flag.DurationVar(&registryCacheTTL, "registry-cache-ttl", 5*time.Minute, "How long image registry query results, including failures, are reused.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false, "Enable admission webhooks, serving certificates are expected in /tmp/k8s-webhook-server/serving-certs.")
+	flag.Var(&rewrites, "image-rewrite", "Rewrite image name prefixes, in from=to form. May be repeated.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -70,10 +109,33 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = controllers.NewThermoCenterReconciler(mgr).SetupWithManager(mgr); err != nil {
+	opts := controllers.Options{
+		Registry:      registry.NewCached(registry.New(registry.Options{PlainHTTP: registryPlainHTTP, Timeout: registryTimeout}), registryCacheTTL),
+		ImageRewrites: rewrites,
+	}
+
+	if err = controllers.NewThermoCenterReconciler(mgr, opts).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ThermoCenter")
 		os.Exit(1)
 	}
+	if err = controllers.NewThermoCenterBackupReconciler(mgr, opts).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ThermoCenterBackup")
+		os.Exit(1)
+	}
+	if err = controllers.NewThermoCenterRestoreReconciler(mgr, opts).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ThermoCenterRestore")
+		os.Exit(1)
+	}
+	if enableWebhooks {
+		if err = (&kojedzinv1alpha1.ThermoCenter{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ThermoCenter")
+			os.Exit(1)
+		}
+		if err = (&kojedzinv1beta1.ThermoCenter{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ThermoCenter")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
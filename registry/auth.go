@@ -0,0 +1,115 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Credentials authenticate to a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Keychain holds credentials of registries, keyed by registry host
+type Keychain map[string]Credentials
+
+// dockerConfig is the format of .dockerconfigjson and .dockercfg Secret keys
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// ParseDockerConfig adds credentials from a docker config file to the keychain.
+// Both the .dockerconfigjson format and the legacy .dockercfg format are accepted.
+func (k Keychain) ParseDockerConfig(data []byte) error {
+	config := dockerConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	if config.Auths == nil {
+		if err := json.Unmarshal(data, &config.Auths); err != nil {
+			return err
+		}
+	}
+
+	for server, auth := range config.Auths {
+		creds := Credentials{Username: auth.Username, Password: auth.Password}
+
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return fmt.Errorf("invalid auth of %s: %w", server, err)
+			}
+
+			colon := strings.IndexByte(string(decoded), ':')
+			if colon == -1 {
+				return fmt.Errorf("invalid auth of %s: missing colon", server)
+			}
+
+			creds = Credentials{Username: string(decoded[:colon]), Password: string(decoded[colon+1:])}
+		}
+
+		k[configHost(server)] = creds
+	}
+
+	return nil
+}
+
+// configHost extracts the registry host from a docker config server entry,
+// which may be a bare host or an url
+func configHost(server string) string {
+	host := server
+	if i := strings.Index(host, "://"); i != -1 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexByte(host, '/'); i != -1 {
+		host = host[:i]
+	}
+
+	switch host {
+	case "index.docker.io", dockerHubAPIHost:
+		return dockerHub
+	}
+
+	return host
+}
+
+// lookup returns credentials for a registry
+func (k Keychain) lookup(registry string) (Credentials, bool) {
+	creds, ok := k[registry]
+
+	return creds, ok
+}
@@ -0,0 +1,128 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type cacheKey struct {
+	tags        bool
+	image       string
+	credentials Credentials
+}
+
+type cacheEntry struct {
+	expires time.Time
+	digest  string
+	tags    []string
+	err     error
+}
+
+type cachedClient struct {
+	client Client
+	ttl    time.Duration
+	now    func() time.Time
+
+	lock    sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+// NewCached wraps a Client, remembering results, including failures, for ttl.
+// Reconciles within ttl thus do not query registries again, nor wait for
+// unreachable ones.
+func NewCached(c Client, ttl time.Duration) Client {
+	return &cachedClient{
+		client:  c,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[cacheKey]cacheEntry),
+	}
+}
+
+func (c *cachedClient) Digest(ctx context.Context, image string, keychain Keychain) (string, error) {
+	key := c.key(false, image, keychain)
+	if e, ok := c.get(key); ok {
+		return e.digest, e.err
+	}
+
+	digest, err := c.client.Digest(ctx, image, keychain)
+	c.set(key, cacheEntry{digest: digest, err: err})
+
+	return digest, err
+}
+
+func (c *cachedClient) Tags(ctx context.Context, image string, keychain Keychain) ([]string, error) {
+	key := c.key(true, image, keychain)
+	if e, ok := c.get(key); ok {
+		return e.tags, e.err
+	}
+
+	tags, err := c.client.Tags(ctx, image, keychain)
+	c.set(key, cacheEntry{tags: tags, err: err})
+
+	return tags, err
+}
+
+// key identifies a request, results obtained with different credentials are kept apart
+func (c *cachedClient) key(tags bool, image string, keychain Keychain) cacheKey {
+	key := cacheKey{tags: tags, image: image}
+
+	if ref, err := ParseReference(image); err == nil {
+		key.credentials, _ = keychain.lookup(ref.Registry)
+	}
+
+	return key
+}
+
+func (c *cachedClient) get(key cacheKey) (cacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !c.now().Before(e.expires) {
+		return e, false
+	}
+
+	return e, true
+}
+
+func (c *cachedClient) set(key cacheKey, e cacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	e.expires = now.Add(c.ttl)
+	c.entries[key] = e
+
+	// Drop expired entries, keeping the cache bounded to recent requests
+	for k, old := range c.entries {
+		if !now.Before(old.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
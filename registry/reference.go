@@ -0,0 +1,130 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	dockerHub        = "docker.io"
+	dockerHubAPIHost = "registry-1.docker.io"
+)
+
+// Reference is a parsed image reference
+type Reference struct {
+	// Registry host, optionally with port
+	Registry string
+
+	// Repository path within the registry
+	Repository string
+
+	// Tag of the image, defaults to latest
+	Tag string
+
+	// Digest of the image, if specified
+	Digest string
+}
+
+// ParseReference parses an image reference in the form [registry/]repository[:tag][@digest]
+func ParseReference(image string) (Reference, error) {
+	ref := Reference{}
+	name := image
+
+	if at := strings.IndexByte(name, '@'); at != -1 {
+		ref.Digest = name[at+1:]
+		name = name[:at]
+	}
+
+	if colon := strings.LastIndexByte(name, ':'); colon > strings.LastIndexByte(name, '/') {
+		ref.Tag = name[colon+1:]
+		name = name[:colon]
+	}
+
+	if name == "" {
+		return ref, fmt.Errorf("invalid image reference: %q", image)
+	}
+
+	if slash := strings.IndexByte(name, '/'); slash != -1 && isRegistry(name[:slash]) {
+		ref.Registry = name[:slash]
+		ref.Repository = name[slash+1:]
+	} else {
+		ref.Registry = dockerHub
+		ref.Repository = name
+	}
+
+	if ref.Registry == dockerHub && strings.IndexByte(ref.Repository, '/') == -1 {
+		ref.Repository = "library/" + ref.Repository
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	return ref, nil
+}
+
+// isRegistry reports whether the first path component of a reference names a registry
+func isRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// apiHost returns the host serving the registry API
+func (ref Reference) apiHost() string {
+	if ref.Registry == dockerHub {
+		return dockerHubAPIHost
+	}
+
+	return ref.Registry
+}
+
+// Name returns the image name, without tag and digest
+func Name(image string) string {
+	if at := strings.IndexByte(image, '@'); at != -1 {
+		image = image[:at]
+	}
+
+	if colon := strings.LastIndexByte(image, ':'); colon > strings.LastIndexByte(image, '/') {
+		image = image[:colon]
+	}
+
+	return image
+}
+
+// FullName returns the fully qualified form of an image reference, with the
+// implicit docker.io registry and library/ path spelled out
+func FullName(image string) string {
+	slash := strings.IndexByte(image, '/')
+	if slash != -1 && isRegistry(image[:slash]) {
+		return image
+	}
+
+	if slash == -1 {
+		return dockerHub + "/library/" + image
+	}
+
+	return dockerHub + "/" + image
+}
@@ -0,0 +1,345 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package registry implements a minimal client for OCI distribution compatible
+// container image registries
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client queries image registries
+type Client interface {
+	// Digest resolves an image reference to its manifest digest
+	Digest(ctx context.Context, image string, keychain Keychain) (string, error)
+
+	// Tags lists the tags of the repository of an image reference
+	Tags(ctx context.Context, image string, keychain Keychain) ([]string, error)
+}
+
+// Options for the default Client
+type Options struct {
+	// PlainHTTP makes the client access registries over plain HTTP
+	PlainHTTP bool
+
+	// Timeout for a single request, defaults to 30 seconds
+	Timeout time.Duration
+}
+
+// Accepted manifest media types, with manifest lists preferred
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+type client struct {
+	http      *http.Client
+	plainHTTP bool
+}
+
+// New creates a Client, accessing registries with credentials from the keychain
+// passed to each call, or anonymously
+func New(opts Options) Client {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	return &client{
+		http:      &http.Client{Timeout: timeout},
+		plainHTTP: opts.PlainHTTP,
+	}
+}
+
+func (c *client) Digest(ctx context.Context, image string, keychain Keychain) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+
+	resp, err := c.do(ctx, http.MethodHead, c.url(ref, "manifests/"+ref.Tag), ref, keychain, manifestMediaTypes)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry returned no digest for %s", image)
+	}
+
+	return digest, nil
+}
+
+func (c *client) Tags(ctx context.Context, image string, keychain Keychain) ([]string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	next := c.url(ref, "tags/list")
+
+	for next != "" {
+		resp, err := c.do(ctx, http.MethodGet, next, ref, keychain, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		link := resp.Header.Get("Link")
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, list.Tags...)
+
+		next, err = nextLink(next, link)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+func (c *client) url(ref Reference, path string) string {
+	scheme := "https"
+	if c.plainHTTP {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s/%s", scheme, ref.apiHost(), ref.Repository, path)
+}
+
+// do performs a request, authenticating as challenged by the registry
+func (c *client) do(ctx context.Context, method, u string, ref Reference, keychain Keychain, accept []string) (*http.Response, error) {
+	creds, hasCreds := keychain.lookup(ref.Registry)
+	authorization := ""
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && authorization == "" {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			drain(resp.Body)
+
+			if isBasicChallenge(challenge) {
+				if !hasCreds {
+					return nil, fmt.Errorf("%s %s: registry requires credentials", method, u)
+				}
+
+				authorization = "Basic " + basicAuth(creds)
+
+				continue
+			}
+
+			token, err := c.token(ctx, challenge, ref, creds, hasCreds)
+			if err != nil {
+				return nil, err
+			}
+
+			authorization = "Bearer " + token
+
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			drain(resp.Body)
+
+			return nil, fmt.Errorf("%s %s: unexpected status %s", method, u, resp.Status)
+		}
+
+		return resp, nil
+	}
+}
+
+// token fetches a token according to a Bearer challenge, anonymously when no
+// credentials are given
+func (c *client) token(ctx context.Context, challenge string, ref Reference, creds Credentials, hasCreds bool) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("unsupported authentication challenge: %q", challenge)
+	}
+
+	q := url.Values{}
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + ref.Repository + ":pull"
+	}
+	q.Set("scope", scope)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if hasCreds {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer drain(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", err
+	}
+
+	if t.Token != "" {
+		return t.Token, nil
+	}
+
+	return t.AccessToken, nil
+}
+
+// isBasicChallenge reports whether a WWW-Authenticate challenge requests Basic authentication
+func isBasicChallenge(challenge string) bool {
+	return strings.HasPrefix(strings.ToLower(challenge), "basic")
+}
+
+func basicAuth(creds Credentials) string {
+	return base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+}
+
+// parseChallenge parses parameters of a WWW-Authenticate Bearer challenge
+func parseChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return params
+	}
+
+	rest := challenge[len("bearer "):]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq == -1 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				break
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end == -1 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+
+	return params
+}
+
+// nextLink returns the next page from a Link header, relative to the current url
+func nextLink(current, link string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+
+	start := strings.IndexByte(link, '<')
+	end := strings.IndexByte(link, '>')
+	if start == -1 || end < start || !strings.Contains(link[end:], `rel="next"`) {
+		return "", nil
+	}
+
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+
+	next, err := base.Parse(link[start+1 : end])
+	if err != nil {
+		return "", err
+	}
+
+	return next.String(), nil
+}
+
+func drain(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, body)
+	body.Close()
+}
@@ -0,0 +1,393 @@
+/*
+MIT License
+
+Copyright (c) 2020 Richard Kojedzinszky
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// testRegistry serves a repository of the distribution API, requiring
+// authentication according to auth: "", "basic" or "bearer"
+type testRegistry struct {
+	*httptest.Server
+
+	auth     string
+	username string
+	password string
+	tags     []string
+	pageSize int
+
+	requests int
+}
+
+func newTestRegistry(auth string) *testRegistry {
+	reg := &testRegistry{
+		auth:     auth,
+		username: "user",
+		password: "secret",
+		tags:     []string{"3.3.1", "4.0.0", "4.0.1", "latest"},
+		pageSize: 2,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", reg.token)
+	mux.HandleFunc("/v2/", reg.api)
+	reg.Server = httptest.NewServer(mux)
+
+	return reg
+}
+
+func (reg *testRegistry) host() string {
+	return strings.TrimPrefix(reg.URL, "http://")
+}
+
+func (reg *testRegistry) image(tag string) string {
+	return reg.host() + "/rkojedzinszky/thermo-center-api:" + tag
+}
+
+func (reg *testRegistry) keychain() Keychain {
+	return Keychain{reg.host(): {Username: reg.username, Password: reg.password}}
+}
+
+func (reg *testRegistry) token(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("scope") != "repository:rkojedzinszky/thermo-center-api:pull" || r.URL.Query().Get("service") != "test" {
+		http.Error(w, "invalid scope", http.StatusBadRequest)
+		return
+	}
+
+	token := "anonymous"
+	if username, password, ok := r.BasicAuth(); ok {
+		if username != reg.username || password != reg.password {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		token = "authenticated"
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func (reg *testRegistry) authorized(w http.ResponseWriter, r *http.Request) bool {
+	authorization := r.Header.Get("Authorization")
+
+	switch reg.auth {
+	case "basic":
+		if username, password, ok := r.BasicAuth(); ok && username == reg.username && password == reg.password {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+	case "bearer":
+		if authorization == "Bearer authenticated" {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:rkojedzinszky/thermo-center-api:pull"`, reg.URL))
+	case "anonymous":
+		if authorization == "Bearer anonymous" {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, reg.URL))
+	default:
+		return true
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+
+	return false
+}
+
+func (reg *testRegistry) api(w http.ResponseWriter, r *http.Request) {
+	reg.requests++
+
+	if !reg.authorized(w, r) {
+		return
+	}
+
+	const prefix = "/v2/rkojedzinszky/thermo-center-api/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch path := r.URL.Path[len(prefix):]; {
+	case path == "tags/list":
+		start := 0
+		if last := r.URL.Query().Get("last"); last != "" {
+			for idx, tag := range reg.tags {
+				if tag == last {
+					start = idx + 1
+				}
+			}
+		}
+
+		end := start + reg.pageSize
+		if end < len(reg.tags) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/rkojedzinszky/thermo-center-api/tags/list?n=%d&last=%s>; rel="next"`, reg.pageSize, reg.tags[end-1]))
+		} else {
+			end = len(reg.tags)
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "rkojedzinszky/thermo-center-api", "tags": reg.tags[start:end]})
+	case strings.HasPrefix(path, "manifests/"):
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			http.Error(w, "missing accept header", http.StatusBadRequest)
+			return
+		}
+
+		tag := path[len("manifests/"):]
+		for _, t := range reg.tags {
+			if t == tag {
+				w.Header().Set("Docker-Content-Digest", testDigest)
+				return
+			}
+		}
+
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	tests := []struct {
+		auth     string
+		keychain bool
+		tag      string
+		err      bool
+	}{
+		{auth: "", tag: "4.0.0"},
+		{auth: "anonymous", tag: "4.0.0"},
+		{auth: "bearer", keychain: true, tag: "4.0.0"},
+		{auth: "bearer", tag: "4.0.0", err: true},
+		{auth: "basic", keychain: true, tag: "4.0.0"},
+		{auth: "basic", tag: "4.0.0", err: true},
+		{auth: "", tag: "5.0.0", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%v/%s", test.auth, test.keychain, test.tag), func(t *testing.T) {
+			reg := newTestRegistry(test.auth)
+			defer reg.Close()
+
+			var keychain Keychain
+			if test.keychain {
+				keychain = reg.keychain()
+			}
+
+			digest, err := New(Options{PlainHTTP: true}).Digest(context.Background(), reg.image(test.tag), keychain)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got digest %s", digest)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if digest != testDigest {
+				t.Errorf("digest = %s, want %s", digest, testDigest)
+			}
+		})
+	}
+}
+
+func TestDigestOfPinnedImage(t *testing.T) {
+	digest, err := New(Options{}).Digest(context.Background(), "ghcr.io/rkojedzinszky/thermo-center-api@"+testDigest, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if digest != testDigest {
+		t.Errorf("digest = %s, want %s", digest, testDigest)
+	}
+}
+
+func TestTags(t *testing.T) {
+	reg := newTestRegistry("bearer")
+	defer reg.Close()
+
+	tags, err := New(Options{PlainHTTP: true}).Tags(context.Background(), reg.image("latest"), reg.keychain())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(tags, reg.tags) {
+		t.Errorf("tags = %v, want %v", tags, reg.tags)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	image := strings.TrimPrefix(server.URL, "http://") + "/thermo-center-api:4.0.0"
+	if _, err := New(Options{PlainHTTP: true, Timeout: 10 * time.Millisecond}).Digest(context.Background(), image, nil); err == nil {
+		t.Error("expected a timeout")
+	}
+}
+
+func TestParseDockerConfig(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass:word"))
+
+	tests := []struct {
+		name   string
+		config string
+		want   Keychain
+		err    bool
+	}{
+		{
+			name:   "dockerconfigjson",
+			config: `{"auths":{"ghcr.io":{"auth":"` + auth + `"},"https://index.docker.io/v1/":{"username":"hub","password":"pw"}}}`,
+			want: Keychain{
+				"ghcr.io":   {Username: "user", Password: "pass:word"},
+				"docker.io": {Username: "hub", Password: "pw"},
+			},
+		},
+		{
+			name:   "dockercfg",
+			config: `{"mirror.example.com:5000":{"auth":"` + auth + `"}}`,
+			want: Keychain{
+				"mirror.example.com:5000": {Username: "user", Password: "pass:word"},
+			},
+		},
+		{
+			name:   "invalid auth",
+			config: `{"auths":{"ghcr.io":{"auth":"` + base64.StdEncoding.EncodeToString([]byte("user")) + `"}}}`,
+			err:    true,
+		},
+		{
+			name:   "invalid json",
+			config: `[]`,
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keychain := Keychain{}
+			err := keychain.ParseDockerConfig([]byte(test.config))
+			if test.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(keychain, test.want) {
+				t.Errorf("keychain = %v, want %v", keychain, test.want)
+			}
+		})
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image string
+		want  Reference
+	}{
+		{"memcached", Reference{Registry: "docker.io", Repository: "library/memcached", Tag: "latest"}},
+		{"rkojedzinszky/thermo-center-api:3.3.1", Reference{Registry: "docker.io", Repository: "rkojedzinszky/thermo-center-api", Tag: "3.3.1"}},
+		{"ghcr.io/rkojedzinszky/thermo-center-api:4.0.0", Reference{Registry: "ghcr.io", Repository: "rkojedzinszky/thermo-center-api", Tag: "4.0.0"}},
+		{"localhost:5000/api@" + testDigest, Reference{Registry: "localhost:5000", Repository: "api", Digest: testDigest}},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseReference(test.image)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.image, err)
+			continue
+		}
+		if ref != test.want {
+			t.Errorf("%s: parsed as %+v, want %+v", test.image, ref, test.want)
+		}
+	}
+}
+
+func TestCached(t *testing.T) {
+	reg := newTestRegistry("")
+	defer reg.Close()
+
+	now := time.Now()
+	c := NewCached(New(Options{PlainHTTP: true}), time.Minute).(*cachedClient)
+	c.now = func() time.Time { return now }
+
+	digest := func(tag string, keychain Keychain) error {
+		_, err := c.Digest(context.Background(), reg.image(tag), keychain)
+		return err
+	}
+
+	if err := digest("4.0.0", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := digest("4.0.0", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reg.requests != 1 {
+		t.Errorf("registry queried %d times, want 1", reg.requests)
+	}
+
+	// Failures are remembered too
+	if err := digest("5.0.0", nil); err == nil {
+		t.Error("expected an error")
+	}
+	if err := digest("5.0.0", nil); err == nil {
+		t.Error("expected a remembered error")
+	}
+	if reg.requests != 2 {
+		t.Errorf("registry queried %d times, want 2", reg.requests)
+	}
+
+	// Other credentials are not served from cache
+	if err := digest("4.0.0", reg.keychain()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reg.requests != 3 {
+		t.Errorf("registry queried %d times, want 3", reg.requests)
+	}
+
+	// Results expire
+	now = now.Add(time.Minute)
+	if err := digest("4.0.0", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reg.requests != 4 {
+		t.Errorf("registry queried %d times, want 4", reg.requests)
+	}
+}